@@ -1,24 +1,22 @@
 package agent
 
 import (
-	"fmt"
+	"context"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
-	"strings"
+	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/qingtao/wxcorp/corp"
-	"github.com/sbzhu/weworkapi_golang/wxbizmsgcrypt"
+	"github.com/qingtao/wxcorp/corp/crypto"
+	"github.com/qingtao/wxcorp/corp/ratelimit"
 )
 
 const (
-	// 重试间隔:1秒
-	retryInterval     = 1 * time.Second
-	retryTimes        = 2
-	refreshBefore     = 5 * 60 // 秒
+	refreshBefore     = 5 * time.Minute   // 令牌提前5分钟刷新
+	forceRefresh      = time.Duration(-1) // 忽略存储中的令牌, 总是请求新的令牌
 	letterForNonceStr = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	minLength         = 16
 )
@@ -55,37 +53,59 @@ type Agent struct {
 	// Token 预留的校验码
 	Token string `json:"token"`
 
-	// ipList 微信企业号服务器的ip地址白名单
-	ipList map[string]struct{}
+	// Replay 回调请求的防重放校验, 为nil时不校验时间戳和随机数
+	Replay *ReplayGuard `json:"-"`
 
-	// accessToken 微信企业好应用的访问令牌
-	accessToken accessToken
-	// refreshAccessToken 刷新令牌标记
-	refreshAccessToken int32
+	// CallbackFormat 回调消息和被动回复的格式, 默认为XML
+	CallbackFormat crypto.Format `json:"callback_format,omitempty"`
 
-	// jsAPITicket jsapi_ticket
-	jsAPITicket jsAPITicket
-	// 刷新jsapi_ticket标记
-	refreshJsAPITicket int32
+	// Client 请求企业微信接口的客户端, 为nil时使用corp.DefaultClient
+	Client *corp.Client `json:"-"`
 
-	// agentJsAPITicket
-	agentJsAPITicket jsAPITicket
-	// 刷新agent jsapi_ticket标记
-	refreshAgentJsAPITicket int32
-}
+	// IPListRefreshInterval 后台刷新IP白名单的间隔, 为0时不刷新, 参考Start
+	IPListRefreshInterval time.Duration `json:"-"`
+	// ipList 企业微信服务器的IP白名单, 由SetIPList设置
+	ipList *corp.IPMatcher
+	// ipListUpdated IP白名单的更新时间
+	ipListUpdated time.Time
 
-// AccessToken access_token
-type accessToken struct {
-	accessToken string
-	expiresAt   int64
-}
+	// crypt 消息加解密工具, cryptKey为创建时的Token、EncodingAESKey和CorpID
+	crypt    *crypto.Crypter
+	cryptKey string
+
+	// Store 令牌存储, 为nil时使用内存存储
+	Store TokenStore `json:"-"`
+
+	// Retry 调用接口的重试策略, 为nil时使用DefaultRetryPolicy
+	Retry *RetryPolicy `json:"-"`
+
+	// RateLimiter 发送消息和获取jsapi_ticket前检查频率限制, 为nil时不限制.
+	// 每企业调用单个接口的频率限制需要使用corp.WithRateLimiter设置Client
+	RateLimiter *ratelimit.Limiter `json:"-"`
+
+	// BulkConcurrency 批量发送消息时并发请求的数量, 为0时使用DefaultBulkConcurrency, 参考SendMsgBulk
+	BulkConcurrency int `json:"-"`
+
+	// OnRefreshError 后台刷新令牌失败时的回调, name为令牌名称, 参考Start
+	OnRefreshError func(name string, err error) `json:"-"`
 
-// JsAPITiket jspaiticket
-type jsAPITicket struct {
-	ticket    string
-	expiresAt int64
+	// flight 合并并发的令牌刷新请求
+	flight flightGroup
+	// stopRefresher 停止后台刷新
+	stopRefresher context.CancelFunc
+	// refresherDone 后台刷新退出后关闭
+	refresherDone chan struct{}
 }
 
+const (
+	// keyAccessToken 访问令牌在存储中的名称
+	keyAccessToken = "access_token"
+	// keyJsAPITicket 企业jsapi_ticket在存储中的名称
+	keyJsAPITicket = "jsapi_ticket"
+	// keyAgentJsAPITicket 应用jsapi_ticket在存储中的名称
+	keyAgentJsAPITicket = "agent_jsapi_ticket"
+)
+
 // NewAgent 新建企业号APP
 func NewAgent(corpid, agentid, secret, encodingAESKey, token string) *Agent {
 	return &Agent{
@@ -94,101 +114,149 @@ func NewAgent(corpid, agentid, secret, encodingAESKey, token string) *Agent {
 		Secret:         secret,
 		EncodingAESKey: encodingAESKey,
 		Token:          token,
-		ipList:         make(map[string]struct{}),
 	}
 }
 
+// client 返回请求企业微信接口的客户端
+func (a *Agent) client() *corp.Client {
+	if a.Client != nil {
+		return a.Client
+	}
+	return corp.DefaultClient
+}
+
+// tokenStore 返回令牌存储
+func (a *Agent) tokenStore() TokenStore {
+	a.Lock()
+	defer a.Unlock()
+	if a.Store == nil {
+		a.Store = NewMemoryStore()
+	}
+	return a.Store
+}
+
+// storeKey 令牌在存储中的键, 同一企业的不同应用互不影响
+func (a *Agent) storeKey(name string) string {
+	return a.CorpID + ":" + a.AgentID + ":" + name
+}
+
+// loadToken 从存储读取令牌, 令牌距离过期不足minTTL时视为无效
+func (a *Agent) loadToken(name string, minTTL time.Duration) (token string, ok bool, err error) {
+	token, expiresAt, err := a.tokenStore().Get(a.storeKey(name))
+	if err != nil || token == "" {
+		return "", false, err
+	}
+	return token, time.Now().Add(minTTL).Before(expiresAt), nil
+}
+
+// saveToken 保存令牌
+func (a *Agent) saveToken(name, token string, expiresIn int) error {
+	expiresAt := time.Now().Add(time.Second * time.Duration(expiresIn))
+	return a.tokenStore().Set(a.storeKey(name), token, expiresAt)
+}
+
+// lockToken 如果存储实现了TokenLocker, 锁定令牌
+func (a *Agent) lockToken(name string) (func(), error) {
+	locker, ok := a.tokenStore().(TokenLocker)
+	if !ok {
+		return func() {}, nil
+	}
+	return locker.Lock(a.storeKey(name))
+}
+
 // GetAccessToken 读取AccessToken
 func (a *Agent) GetAccessToken() (token string, err error) {
-	accessToken, ct := a.accessToken, time.Now().Unix()
-	if accessToken.accessToken != "" && ct < accessToken.expiresAt {
-		token = accessToken.accessToken
+	token, ok, err := a.loadToken(keyAccessToken, refreshBefore)
+	if err != nil || ok {
 		return
 	}
-	return a.RefreshAccessToken()
+	return a.refreshAccessTokenFromServer(context.Background(), refreshBefore)
 }
 
-// var aonce sync.Once
-
 // RefreshAccessToken 刷新访问令牌
 func (a *Agent) RefreshAccessToken() (token string, err error) {
-	// println("--刷新访问令牌--", a.Token)
-	if !atomic.CompareAndSwapInt32(&a.refreshAccessToken, 0, 1) {
-		token = a.accessToken.accessToken
+	return a.refreshAccessTokenFromServer(context.Background(), forceRefresh)
+}
+
+// refreshAccessTokenFromServer 请求新的访问令牌, 并发的调用方等待同一次请求的结果;
+// 加锁后再检查一次存储, 如果其他进程已经刷新且有效期超过minTTL则直接使用, minTTL为forceRefresh时总是请求
+func (a *Agent) refreshAccessTokenFromServer(ctx context.Context, minTTL time.Duration) (string, error) {
+	return a.flight.Do(keyAccessToken, func() (token string, err error) {
+		unlock, err := a.lockToken(keyAccessToken)
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+		if minTTL != forceRefresh {
+			token, ok, err := a.loadToken(keyAccessToken, minTTL)
+			if err != nil || ok {
+				return token, err
+			}
+		}
+		accesstoken, err := a.client().GetAccessToken(ctx, "", a.CorpID, a.Secret)
+		if err != nil {
+			return "", err
+		}
+		token = accesstoken.AccessToken
+		err = a.saveToken(keyAccessToken, token, accesstoken.ExpiresIn)
 		return
-	}
-	defer atomic.StoreInt32(&a.refreshAccessToken, 0)
-	accesstoken, err := corp.GetAccessToken("", a.CorpID, a.Secret)
-	if err != nil {
-		return "", err
-	}
+	})
+}
 
-	token = accesstoken.AccessToken
-	// 提前5分钟刷新
-	if accesstoken.ExpiresIn > refreshBefore {
-		accesstoken.ExpiresIn -= refreshBefore
+// jsAPITicketKey jsapi_ticket类型对应的存储名称
+func jsAPITicketKey(typ string) string {
+	if typ == "agent_config" {
+		return keyAgentJsAPITicket
 	}
-	a.Lock()
-	a.accessToken = accessToken{accesstoken.AccessToken, time.Now().Add(time.Second * time.Duration(accesstoken.ExpiresIn)).Unix()}
-	a.Unlock()
-	return
+	return keyJsAPITicket
 }
 
 // GetJsAPITicket 读取jsapi_ticket
 func (a *Agent) GetJsAPITicket(typ string) (ticket string, err error) {
-	var jsTicket jsAPITicket
-	switch typ {
-	case "agent_config":
-		jsTicket = a.agentJsAPITicket
-	default:
-		jsTicket = a.jsAPITicket
-	}
-	ct := time.Now().Unix()
-	if jsTicket.ticket != "" && ct < jsTicket.expiresAt {
-		ticket = jsTicket.ticket
+	ticket, ok, err := a.loadToken(jsAPITicketKey(typ), refreshBefore)
+	if err != nil || ok {
 		return
 	}
-	return a.RefreshJsAPITicket(typ)
+	return a.refreshJsAPITicketFromServer(context.Background(), typ, refreshBefore)
 }
 
-// RefreshJsAPITicket -
+// RefreshJsAPITicket 刷新jsapi_ticket, typ为"agent_config"时刷新应用的jsapi_ticket
 func (a *Agent) RefreshJsAPITicket(typ string) (ticket string, err error) {
-	// println("--刷新jsapi_ticket--", a.Token)
-	flag := &a.refreshJsAPITicket
-	if typ == "agent_config" {
-		flag = &a.refreshAgentJsAPITicket
-	}
-	if !atomic.CompareAndSwapInt32(flag, 0, 1) {
-		if typ == "agent_config" {
-			ticket = a.agentJsAPITicket.ticket
-		} else {
-			ticket = a.jsAPITicket.ticket
+	return a.refreshJsAPITicketFromServer(context.Background(), typ, forceRefresh)
+}
+
+// refreshJsAPITicketFromServer 请求新的jsapi_ticket, 并发的调用方等待同一次请求的结果
+func (a *Agent) refreshJsAPITicketFromServer(ctx context.Context, typ string, minTTL time.Duration) (string, error) {
+	name := jsAPITicketKey(typ)
+	return a.flight.Do(name, func() (ticket string, err error) {
+		unlock, err := a.lockToken(name)
+		if err != nil {
+			return "", err
 		}
+		defer unlock()
+		if minTTL != forceRefresh {
+			ticket, ok, err := a.loadToken(name, minTTL)
+			if err != nil || ok {
+				return ticket, err
+			}
+		}
+		if a.RateLimiter != nil {
+			if err = a.RateLimiter.JsAPITicket(ctx, a.AgentID); err != nil {
+				return "", err
+			}
+		}
+		accessToken, err := a.GetAccessToken()
+		if err != nil {
+			return "", err
+		}
+		jsTicket, err := a.client().GetJsAPITicket(ctx, "", accessToken, typ)
+		if err != nil {
+			return "", err
+		}
+		ticket = jsTicket.Ticket
+		err = a.saveToken(name, ticket, jsTicket.ExpiresIn)
 		return
-	}
-	defer atomic.StoreInt32(flag, 0)
-	accessToken, err := a.GetAccessToken()
-	if err != nil {
-		return "", err
-	}
-	jsTicket, err := corp.GetJsAPITicket("", accessToken, typ)
-	if err != nil {
-		return "", err
-	}
-	// 提前5分钟刷新
-	if jsTicket.ExpiresIn > refreshBefore {
-		jsTicket.ExpiresIn -= refreshBefore
-	}
-	ticket = jsTicket.Ticket
-	jsAPITicket := jsAPITicket{ticket, time.Now().Add(time.Second * time.Duration(jsTicket.ExpiresIn)).Unix()}
-	a.Lock()
-	if typ == "agent_config" {
-		a.agentJsAPITicket = jsAPITicket
-	} else {
-		a.jsAPITicket = jsAPITicket
-	}
-	a.Unlock()
-	return
+	})
 }
 
 // NewJsAPITicketSignature 生成ticket签名
@@ -197,54 +265,96 @@ func (a *Agent) NewJsAPITicketSignature(ticket, url string) *corp.JsAPITicketSig
 	return corp.NewJsAPITicketSignature(a.CorpID, a.AgentID, ticket, noncestr, url, timestamp)
 }
 
-// SetIPList 设置IP白名单
-func (a *Agent) SetIPList() error {
-	accessToken, err := a.GetAccessToken()
-	if err != nil {
-		return err
+// ReceiveMsg 接收消息, 设置了Replay时处理失败后需要调用Replay.Forget, 以便企业微信重试时可以通过校验
+func (a *Agent) ReceiveMsg(r *http.Request) (msg []byte, err error) {
+	signature, nonce, timestamp := r.FormValue("msg_signature"), r.FormValue("nonce"), r.FormValue("timestamp")
+	if r.Body != nil {
+		defer r.Body.Close()
 	}
-	ipList, err := corp.GetCallBackIPList("", accessToken)
+	b, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return a.decryptMsg(signature, timestamp, nonce, b)
+}
+
+// crypter 返回消息加解密工具, Token、EncodingAESKey和CorpID不变时复用同一个实例
+func (a *Agent) crypter() (*crypto.Crypter, error) {
 	a.Lock()
 	defer a.Unlock()
-	for _, ip := range ipList.IPList {
-		a.ipList[ip] = struct{}{}
+	key := a.Token + "\x00" + a.EncodingAESKey + "\x00" + a.CorpID
+	if a.crypt != nil && a.cryptKey == key {
+		return a.crypt, nil
 	}
-	return nil
+	c, err := crypto.New(a.Token, a.EncodingAESKey, a.CorpID)
+	if err != nil {
+		return nil, err
+	}
+	a.crypt, a.cryptKey = c, key
+	return c, nil
 }
 
-// IsMatch 匹配ip列表
-func (a *Agent) IsMatch(remoteAddr string) bool {
-	// 如果ip列表为空,直接返回真以便不影响服务
-	if len(a.ipList) < 1 {
-		return true
+// verifyURL 校验回调URL的签名并解密echostr, 签名正确后校验时间戳和随机数
+func (a *Agent) verifyURL(signature, timestamp, nonce, echostr string) ([]byte, error) {
+	c, err := a.crypter()
+	if err != nil {
+		return nil, err
 	}
-	index := strings.LastIndex(remoteAddr, ".")
-	ip := remoteAddr[:index] + ".*"
-	if _, ok := a.ipList[ip]; ok {
-		return true
+	b, err := c.VerifyURL(signature, timestamp, nonce, echostr)
+	if err != nil {
+		return nil, err
 	}
-	return false
+	if err = a.checkReplay(timestamp, nonce); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
 
+// decryptMsg 校验签名并解密回调消息, 签名正确后校验时间戳和随机数
+func (a *Agent) decryptMsg(signature, timestamp, nonce string, body []byte) ([]byte, error) {
+	b, err := a.decrypt(signature, timestamp, nonce, body)
+	if err != nil {
+		return nil, err
+	}
+	if err = a.checkReplay(timestamp, nonce); err != nil {
+		return nil, err
+	}
+	return b, nil
 }
 
-// ReceiveMsg 接收消息
-func (a *Agent) ReceiveMsg(r *http.Request) (msg []byte, err error) {
-	crypt := wxbizmsgcrypt.NewWXBizMsgCrypt(a.Token, a.EncodingAESKey, a.CorpID, wxbizmsgcrypt.XmlType)
-	signature, nonce, timestamp := r.FormValue("msg_signature"), r.FormValue("nonce"), r.FormValue("timestamp")
-	if r.Body != nil {
-		defer r.Body.Close()
+// decrypt 校验签名并解密回调消息, 不校验时间戳和随机数
+func (a *Agent) decrypt(signature, timestamp, nonce string, body []byte) ([]byte, error) {
+	c, err := a.crypter()
+	if err != nil {
+		return nil, err
 	}
-	b, err := ioutil.ReadAll(r.Body)
+	b, err := c.DecryptMsg(signature, timestamp, nonce, body, a.CallbackFormat)
 	if err != nil {
 		return nil, err
 	}
-	var cryptErr *wxbizmsgcrypt.CryptError
-	msg, cryptErr = crypt.DecryptMsg(signature, timestamp, nonce, b)
-	if cryptErr != nil {
-		err = fmt.Errorf("[errcode]:%d,[errmsg]:%s", cryptErr.ErrCode, cryptErr.ErrMsg)
+	return b, nil
+}
+
+// EncryptReply 按照CallbackFormat编码并加密被动回复的消息, 返回包含Encrypt、MsgSignature、TimeStamp和Nonce的XML或者JSON,
+// 可以直接写入回调请求的响应
+func (a *Agent) EncryptReply(reply corp.Reply) ([]byte, error) {
+	marshal := corp.MarshalReply
+	if a.CallbackFormat == crypto.JSON {
+		marshal = corp.MarshalReplyJSON
 	}
-	return
+	b, err := marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+	return a.encryptReply(b)
+}
+
+// encryptReply 加密被动回复的消息
+func (a *Agent) encryptReply(reply []byte) ([]byte, error) {
+	c, err := a.crypter()
+	if err != nil {
+		return nil, err
+	}
+	timestamp, nonce := generateTimestampAndNonceStr(minLength)
+	return c.EncryptMsg(reply, strconv.FormatInt(timestamp, 10), nonce, a.CallbackFormat)
 }
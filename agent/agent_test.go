@@ -1,8 +1,16 @@
 package agent
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
 	"reflect"
+	"sync/atomic"
 	"testing"
+
+	"github.com/qingtao/wxcorp/corp"
 )
 
 func TestNewAgent(t *testing.T) {
@@ -35,7 +43,6 @@ func TestNewAgent(t *testing.T) {
 				Secret:         "3",
 				EncodingAESKey: "11",
 				Token:          "22",
-				ipList:         make(map[string]struct{}),
 			},
 		},
 	}
@@ -48,3 +55,71 @@ func TestNewAgent(t *testing.T) {
 		})
 	}
 }
+
+// rewriteTransport 将所有请求转发到测试服务器
+type rewriteTransport struct {
+	u *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = t.u.Scheme, t.u.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newTestAgent 新建请求测试服务器的应用
+func newTestAgent(t *testing.T, h http.HandlerFunc) *Agent {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	u, _ := url.Parse(ts.URL)
+	a := NewAgent("corpid", "1000001", "secret", "", "")
+	a.Client = corp.NewClient(corp.WithTransport(rewriteTransport{u}))
+	return a
+}
+
+func TestAgent_GetAccessToken(t *testing.T) {
+	var n int32
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		i := atomic.AddInt32(&n, 1)
+		switch r.URL.Path {
+		case "/cgi-bin/gettoken":
+			fmt.Fprintf(w, `{"access_token":"token%d","expires_in":7200}`, i)
+		case "/cgi-bin/ticket/get":
+			fmt.Fprintf(w, `{"ticket":"agent%d","expires_in":7200}`, i)
+		default:
+			fmt.Fprintf(w, `{"ticket":"corp%d","expires_in":7200}`, i)
+		}
+	})
+	a.Store = NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+
+	token, err := a.GetAccessToken()
+	if err != nil || token != "token1" {
+		t.Fatalf("Agent.GetAccessToken() = %v, %v", token, err)
+	}
+	// 第二次从存储读取
+	if token, err = a.GetAccessToken(); err != nil || token != "token1" {
+		t.Fatalf("Agent.GetAccessToken() = %v, %v", token, err)
+	}
+	// 另一个进程共享同一个存储
+	b := NewAgent(a.CorpID, a.AgentID, a.Secret, "", "")
+	b.Client, b.Store = a.Client, NewFileStore(a.Store.(*FileStore).path)
+	if token, err = b.GetAccessToken(); err != nil || token != "token1" {
+		t.Fatalf("Agent.GetAccessToken() from shared store = %v, %v", token, err)
+	}
+	if token, err = a.RefreshAccessToken(); err != nil || token != "token2" {
+		t.Fatalf("Agent.RefreshAccessToken() = %v, %v", token, err)
+	}
+	ticket, err := a.GetJsAPITicket("agent_config")
+	if err != nil || ticket != "agent3" {
+		t.Fatalf("Agent.GetJsAPITicket() = %v, %v", ticket, err)
+	}
+	if ticket, err = a.GetJsAPITicket(""); err != nil || ticket != "corp4" {
+		t.Fatalf("Agent.GetJsAPITicket() = %v, %v", ticket, err)
+	}
+	if ticket, err = b.GetJsAPITicket("agent_config"); err != nil || ticket != "agent3" {
+		t.Fatalf("Agent.GetJsAPITicket() from shared store = %v, %v", ticket, err)
+	}
+	if ticket, err = a.RefreshJsAPITicket("agent_config"); err != nil || ticket != "agent5" {
+		t.Fatalf("Agent.RefreshJsAPITicket() = %v, %v", ticket, err)
+	}
+}
@@ -0,0 +1,260 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OverflowPolicy 异步处理的队列已满时的处理方式
+type OverflowPolicy int
+
+const (
+	// OverflowReject 返回503, 企业微信会重试这条消息
+	OverflowReject OverflowPolicy = iota
+	// OverflowDrop 返回200并丢弃这条消息
+	OverflowDrop
+	// OverflowBlock 等待队列出现空位, 直到回调请求结束
+	OverflowBlock
+)
+
+var (
+	// ErrQueueFull 异步处理的队列已满
+	ErrQueueFull = errors.New("回调消息队列已满")
+	// ErrHandlerClosed 回调处理器已经关闭
+	ErrHandlerClosed = errors.New("回调处理器已关闭")
+)
+
+// AsyncConfig 异步处理的配置
+type AsyncConfig struct {
+	// Workers 处理消息的goroutine数量, 默认4
+	Workers int
+	// QueueSize 等待处理的消息数量上限, 默认100
+	QueueSize int
+	// Overflow 队列已满时的处理方式, 默认OverflowReject
+	Overflow OverflowPolicy
+	// Timeout 处理每条消息的超时时间, 为0时不限制
+	Timeout time.Duration
+}
+
+// AsyncStats 异步处理的统计数据
+type AsyncStats struct {
+	// Workers 处理消息的goroutine数量
+	Workers int
+	// QueueSize 队列容量
+	QueueSize int
+	// Queued 队列中等待处理的消息数
+	Queued int
+	// Enqueued 进入队列的消息总数
+	Enqueued uint64
+	// Processed 处理成功的消息总数
+	Processed uint64
+	// Failed 处理失败的消息总数
+	Failed uint64
+	// Dropped 因为队列已满被丢弃或者拒绝的消息总数
+	Dropped uint64
+}
+
+// asyncJob 等待处理的消息, r是回调请求的副本, 只包含传给错误回调的方法、URL、请求头和远程地址,
+// 不持有ServeHTTP返回后已经失效的原始请求
+type asyncJob struct {
+	r   *http.Request
+	msg *Message
+}
+
+// detachRequest 复制回调请求中错误回调需要的字段, 不包括请求体和context
+func detachRequest(r *http.Request) *http.Request {
+	c := &http.Request{
+		Method:     r.Method,
+		Proto:      r.Proto,
+		ProtoMajor: r.ProtoMajor,
+		ProtoMinor: r.ProtoMinor,
+		Header:     r.Header.Clone(),
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		RequestURI: r.RequestURI,
+	}
+	if r.URL != nil {
+		u := *r.URL
+		c.URL = &u
+	}
+	return c
+}
+
+// asyncPool 处理回调消息的goroutine池
+type asyncPool struct {
+	cfg   AsyncConfig
+	queue chan asyncJob
+	// onPanic 处理消息时发生panic的回调
+	onPanic func(job asyncJob, err error)
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	enqueued  uint64
+	processed uint64
+	failed    uint64
+	dropped   uint64
+}
+
+// newAsyncPool 新建goroutine池并启动workers, handle处理每条消息, handle发生panic时调用onPanic
+func newAsyncPool(cfg AsyncConfig, handle func(ctx context.Context, job asyncJob) error, onPanic func(job asyncJob, err error)) *asyncPool {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100
+	}
+	p := &asyncPool{cfg: cfg, queue: make(chan asyncJob, cfg.QueueSize), onPanic: onPanic}
+	p.wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for job := range p.queue {
+				p.run(job, handle)
+			}
+		}()
+	}
+	return p
+}
+
+// run 处理一条消息, panic计为处理失败, 不影响其他消息
+func (p *asyncPool) run(job asyncJob, handle func(ctx context.Context, job asyncJob) error) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&p.failed, 1)
+			if p.onPanic != nil {
+				p.onPanic(job, errors.Errorf("处理回调消息时发生panic: %v", r))
+			}
+		}
+	}()
+	ctx := context.Background()
+	if p.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.Timeout)
+		defer cancel()
+	}
+	if err := handle(ctx, job); err != nil {
+		atomic.AddUint64(&p.failed, 1)
+		return
+	}
+	atomic.AddUint64(&p.processed, 1)
+}
+
+// enqueue 把消息放入队列, 队列已满时按照Overflow处理, OverflowBlock时等待到ctx结束
+func (p *asyncPool) enqueue(ctx context.Context, job asyncJob) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrHandlerClosed
+	}
+	if p.cfg.Overflow == OverflowBlock {
+		select {
+		case p.queue <- job:
+			atomic.AddUint64(&p.enqueued, 1)
+			return nil
+		case <-ctx.Done():
+		}
+	} else {
+		select {
+		case p.queue <- job:
+			atomic.AddUint64(&p.enqueued, 1)
+			return nil
+		default:
+		}
+	}
+	atomic.AddUint64(&p.dropped, 1)
+	return ErrQueueFull
+}
+
+// close 停止接收消息, 等待队列中的消息处理完成或者ctx结束
+func (p *asyncPool) close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stats 返回统计数据
+func (p *asyncPool) stats() AsyncStats {
+	return AsyncStats{
+		Workers:   p.cfg.Workers,
+		QueueSize: p.cfg.QueueSize,
+		Queued:    len(p.queue),
+		Enqueued:  atomic.LoadUint64(&p.enqueued),
+		Processed: atomic.LoadUint64(&p.processed),
+		Failed:    atomic.LoadUint64(&p.failed),
+		Dropped:   atomic.LoadUint64(&p.dropped),
+	}
+}
+
+// WithAsync 异步处理回调消息: 解密后立即返回空的200响应, 由goroutine池调用Handler,
+// Handler返回的被动回复会被忽略, 需要回复时使用Agent.SendMsg. 使用Close停止处理
+func WithAsync(cfg AsyncConfig) CallbackOption {
+	return func(h *CallbackHandler) {
+		h.asyncConfig = &cfg
+	}
+}
+
+// AsyncStats 返回异步处理的统计数据, 未启用异步处理时返回零值
+func (h *CallbackHandler) AsyncStats() AsyncStats {
+	if h.async == nil {
+		return AsyncStats{}
+	}
+	return h.async.stats()
+}
+
+// Close 停止接收新消息, 等待异步队列中的消息处理完成或者ctx结束, 未启用异步处理时直接返回
+func (h *CallbackHandler) Close(ctx context.Context) error {
+	if h.async == nil {
+		return nil
+	}
+	return h.async.close(ctx)
+}
+
+// serveAsync 把消息放入异步队列, 无法放入队列时返回状态码和错误
+func (h *CallbackHandler) serveAsync(r *http.Request, key string, msg *Message) (int, error) {
+	err := h.async.enqueue(r.Context(), asyncJob{r: detachRequest(r), msg: msg})
+	if err == nil {
+		return 0, nil
+	}
+	if err == ErrQueueFull && h.async.cfg.Overflow == OverflowDrop {
+		h.onError(r, err)
+		return 0, nil
+	}
+	h.unmarkSeen(r, key)
+	return http.StatusServiceUnavailable, err
+}
+
+// asyncPanic 异步处理消息发生panic时记录错误
+func (h *CallbackHandler) asyncPanic(job asyncJob, err error) {
+	h.onError(job.r, err)
+}
+
+// processAsync 异步调用Handler
+func (h *CallbackHandler) processAsync(ctx context.Context, job asyncJob) error {
+	if _, err := h.handler.ServeMessage(ctx, job.msg); err != nil {
+		h.onError(job.r, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestCallbackHandler_Async(t *testing.T) {
+	a := newCallbackAgent()
+	raw := `<xml><ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName><FromUserName><![CDATA[zhangsan]]></FromUserName><CreateTime>1348831860</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[%s]]></Content><MsgId>%d</MsgId><AgentID>1</AgentID></xml>`
+	post := func(h http.Handler, id int, content string) *httptest.ResponseRecorder {
+		m := encryptForTest(t, a, fmt.Sprintf(raw, content, id))
+		body := fmt.Sprintf("<xml><Encrypt><![CDATA[%s]]></Encrypt></xml>", m.Encrypt)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, callbackQuery(m, false), strings.NewReader(body)))
+		return w
+	}
+
+	tests := []struct {
+		name        string
+		overflow    OverflowPolicy
+		wantCodes   []int
+		wantDropped uint64
+		wantDone    uint64
+		wantFailed  uint64
+	}{
+		// 1个worker处理第1条消息, 队列容量为1, 第3条消息溢出
+		{name: "reject", overflow: OverflowReject, wantCodes: []int{200, 200, 503}, wantDropped: 1, wantDone: 1, wantFailed: 1},
+		{name: "drop", overflow: OverflowDrop, wantCodes: []int{200, 200, 200}, wantDropped: 1, wantDone: 1, wantFailed: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			started := make(chan struct{}, 3)
+			var errs []error
+			h := NewCallbackHandler(a, HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+				started <- struct{}{}
+				<-release
+				if msg.Content == "error" {
+					return nil, errors.New("handler")
+				}
+				return []byte("ignored"), nil
+			}), WithAsync(AsyncConfig{Workers: 1, QueueSize: 1, Overflow: tt.overflow}), WithErrorHandler(func(r *http.Request, err error) {
+				errs = append(errs, err)
+			}))
+
+			w := post(h, 1, "hello")
+			if w.Code != tt.wantCodes[0] || w.Body.Len() != 0 {
+				t.Fatalf("ServeHTTP() = %d %q, want empty %d", w.Code, w.Body.String(), tt.wantCodes[0])
+			}
+			<-started
+			for i, content := range []string{"error", "overflow"} {
+				if w := post(h, i+2, content); w.Code != tt.wantCodes[i+1] {
+					t.Errorf("ServeHTTP(%s) = %d, want %d", content, w.Code, tt.wantCodes[i+1])
+				}
+			}
+			if st := h.AsyncStats(); st.Queued != 1 || st.Dropped != tt.wantDropped || st.Workers != 1 {
+				t.Errorf("AsyncStats() = %+v", st)
+			}
+			close(release)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := h.Close(ctx); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			st := h.AsyncStats()
+			if st.Processed != tt.wantDone || st.Failed != tt.wantFailed || st.Enqueued != 2 || st.Queued != 0 {
+				t.Errorf("AsyncStats() = %+v", st)
+			}
+			if len(errs) != 2 {
+				t.Errorf("errors = %v, want queue full and handler error", errs)
+			}
+			if w := post(h, 4, "closed"); w.Code != http.StatusServiceUnavailable {
+				t.Errorf("ServeHTTP() after Close = %d, want 503", w.Code)
+			}
+		})
+	}
+}
+
+func TestCallbackHandler_AsyncBlock(t *testing.T) {
+	a := newCallbackAgent()
+	release := make(chan struct{})
+	h := NewCallbackHandler(a, HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+		<-release
+		return nil, nil
+	}), WithAsync(AsyncConfig{Workers: 1, QueueSize: 1, Overflow: OverflowBlock}))
+	defer h.Close(context.Background())
+	defer close(release)
+
+	raw := `<xml><ToUserName>wx5823bf96d3bd56c7</ToUserName><FromUserName>zhangsan</FromUserName><MsgType>text</MsgType><MsgId>%d</MsgId></xml>`
+	for i := 1; i <= 3; i++ {
+		m := encryptForTest(t, a, fmt.Sprintf(raw, i))
+		body := fmt.Sprintf("<xml><Encrypt><![CDATA[%s]]></Encrypt></xml>", m.Encrypt)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		r := httptest.NewRequest(http.MethodPost, callbackQuery(m, false), strings.NewReader(body)).WithContext(ctx)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		cancel()
+		want := http.StatusOK
+		if i == 3 {
+			// worker和队列都已占满, 等待到请求超时
+			want = http.StatusServiceUnavailable
+		}
+		if w.Code != want {
+			t.Errorf("ServeHTTP(%d) = %d, want %d", i, w.Code, want)
+		}
+		if i == 1 {
+			// 等待worker取走第1条消息
+			for h.AsyncStats().Queued != 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+}
+
+func TestCallbackHandler_AsyncPanic(t *testing.T) {
+	a := newCallbackAgent()
+	errs := make(chan error, 2)
+	var remote []string
+	h := NewCallbackHandler(a, HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+		if msg.Content == "panic" {
+			panic("boom")
+		}
+		return nil, nil
+	}), WithAsync(AsyncConfig{Workers: 1}), WithErrorHandler(func(r *http.Request, err error) {
+		remote = append(remote, r.RemoteAddr)
+		errs <- err
+	}))
+
+	raw := `<xml><ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName><FromUserName><![CDATA[zhangsan]]></FromUserName><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[%s]]></Content><MsgId>%d</MsgId></xml>`
+	for i, content := range []string{"panic", "hello"} {
+		m := encryptForTest(t, a, fmt.Sprintf(raw, content, i+1))
+		body := fmt.Sprintf("<xml><Encrypt><![CDATA[%s]]></Encrypt></xml>", m.Encrypt)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, callbackQuery(m, false), strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Errorf("ServeHTTP(%s) = %d, want 200", content, w.Code)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	// panic只影响当前消息, worker继续处理后面的消息
+	if st := h.AsyncStats(); st.Failed != 1 || st.Processed != 1 {
+		t.Errorf("AsyncStats() = %+v", st)
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "boom") {
+			t.Errorf("error = %v, want panic", err)
+		}
+	default:
+		t.Error("error handler not called for panic")
+	}
+	if len(remote) != 1 || remote[0] != "192.0.2.1:1234" {
+		t.Errorf("error handler request RemoteAddr = %v", remote)
+	}
+}
@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp"
+)
+
+// DefaultBulkConcurrency 批量发送消息时默认的并发请求数量
+const DefaultBulkConcurrency = 4
+
+// Recipients 批量发送消息的接收人
+type Recipients struct {
+	// Users 成员的UserID, 包含"@all"时发送给全部成员并忽略其他接收人
+	Users []string
+	// Parties 部门ID
+	Parties []string
+	// Tags 标签ID
+	Tags []string
+}
+
+// BatchResult 每个批次的发送结果
+type BatchResult struct {
+	// Recipients 本批次的接收人
+	Recipients Recipients
+	// Result 发送结果, 请求失败时可能为nil
+	Result *corp.SendResult
+	// Err 发送失败的错误
+	Err error
+}
+
+// BulkResult 批量发送消息的结果, Batches与拆分的批次顺序一致
+type BulkResult struct {
+	Batches []BatchResult
+}
+
+// MsgIDs 发送成功的批次的msgid, 用于撤回消息
+func (r *BulkResult) MsgIDs() []string {
+	var ids []string
+	for _, b := range r.Batches {
+		if b.Err == nil && b.Result != nil && b.Result.MsgID != "" {
+			ids = append(ids, b.Result.MsgID)
+		}
+	}
+	return ids
+}
+
+// Failed 发送失败的批次
+func (r *BulkResult) Failed() []BatchResult {
+	var failed []BatchResult
+	for _, b := range r.Batches {
+		if b.Err != nil {
+			failed = append(failed, b)
+		}
+	}
+	return failed
+}
+
+// Invalid 合并所有批次返回的无效接收人
+func (r *BulkResult) Invalid() *corp.SendResult {
+	res := new(corp.SendResult)
+	for _, b := range r.Batches {
+		if b.Result == nil {
+			continue
+		}
+		res.InvalidUsers = append(res.InvalidUsers, b.Result.InvalidUsers...)
+		res.InvalidParties = append(res.InvalidParties, b.Result.InvalidParties...)
+		res.InvalidTags = append(res.InvalidTags, b.Result.InvalidTags...)
+		res.UnlicensedUsers = append(res.UnlicensedUsers, b.Result.UnlicensedUsers...)
+	}
+	return res
+}
+
+// SendMsgBulk 批量发送消息, 接收人去重后按照每次1000个成员、100个部门和100个标签拆分为多个批次,
+// 使用BulkConcurrency个并发请求发送, msg的ToUser、ToParty和ToTag会被忽略.
+// 有批次发送失败时返回第一个失败批次的错误, 可以使用errors.Is判断错误码, 各批次的结果记录在BulkResult中.
+// 每个批次与SendMsg相同, 未设置EnableDuplicateCheck时结果不确定的错误不会重试
+func (a *Agent) SendMsgBulk(msg *corp.Msg, to Recipients) (*BulkResult, error) {
+	if msg == nil {
+		return nil, errors.New("消息为空")
+	}
+	batches := splitRecipients(to)
+	if len(batches) == 0 {
+		return nil, errors.New("接收人不能为空")
+	}
+	// 所有批次使用相同的消息内容, 发送前先验证一次
+	if err := batchMsg(msg, batches[0]).Validate(); err != nil {
+		return nil, err
+	}
+
+	concurrency := a.BulkConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBulkConcurrency
+	}
+	result := &BulkResult{Batches: make([]BatchResult, len(batches))}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, to := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, to Recipients) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res, err := a.SendMsg(batchMsg(msg, to))
+			result.Batches[i] = BatchResult{Recipients: to, Result: res, Err: err}
+		}(i, to)
+	}
+	wg.Wait()
+
+	if failed := result.Failed(); len(failed) > 0 {
+		return result, errors.WithMessagef(failed[0].Err, "%d/%d个批次发送失败", len(failed), len(batches))
+	}
+	return result, nil
+}
+
+// batchMsg 复制消息并设置批次的接收人
+func batchMsg(msg *corp.Msg, to Recipients) *corp.Msg {
+	m := *msg
+	m.ToUser = strings.Join(to.Users, "|")
+	m.ToParty = strings.Join(to.Parties, "|")
+	m.ToTag = strings.Join(to.Tags, "|")
+	return &m
+}
+
+// splitRecipients 去重后拆分接收人, 每个批次同时包含成员、部门和标签
+func splitRecipients(to Recipients) []Recipients {
+	users := normalizeIDs(to.Users)
+	for _, u := range users {
+		if u == "@all" {
+			return []Recipients{{Users: []string{"@all"}}}
+		}
+	}
+	parties := chunkIDs(normalizeIDs(to.Parties), corp.MaxMsgParties)
+	tags := chunkIDs(normalizeIDs(to.Tags), corp.MaxMsgTags)
+	userChunks := chunkIDs(users, corp.MaxMsgUsers)
+
+	n := len(userChunks)
+	if len(parties) > n {
+		n = len(parties)
+	}
+	if len(tags) > n {
+		n = len(tags)
+	}
+	batches := make([]Recipients, n)
+	for i := range batches {
+		if i < len(userChunks) {
+			batches[i].Users = userChunks[i]
+		}
+		if i < len(parties) {
+			batches[i].Parties = parties[i]
+		}
+		if i < len(tags) {
+			batches[i].Tags = tags[i]
+		}
+	}
+	return batches
+}
+
+// normalizeIDs 去掉空白和重复的id
+func normalizeIDs(ids []string) []string {
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			result = append(result, id)
+		}
+	}
+	return corp.RemoveDuplicateString(result)
+}
+
+// chunkIDs 按照size拆分id列表
+func chunkIDs(ids []string, size int) [][]string {
+	var chunks [][]string
+	for len(ids) > size {
+		chunks = append(chunks, ids[:size:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		chunks = append(chunks, ids)
+	}
+	return chunks
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp"
+	"github.com/qingtao/wxcorp/corp/errcode"
+)
+
+func ids(prefix string, n int) []string {
+	s := make([]string, n)
+	for i := range s {
+		s[i] = prefix + strconv.Itoa(i)
+	}
+	return s
+}
+
+func TestSplitRecipients(t *testing.T) {
+	tests := []struct {
+		name string
+		to   Recipients
+		want [][3]int
+	}{
+		{name: "empty", to: Recipients{Users: []string{"", " "}}, want: nil},
+		{name: "single", to: Recipients{Users: []string{"a", "a", " b"}, Tags: []string{"1"}}, want: [][3]int{{2, 0, 1}}},
+		{name: "users", to: Recipients{Users: append(ids("u", 2500), ids("u", 10)...)}, want: [][3]int{{1000, 0, 0}, {1000, 0, 0}, {500, 0, 0}}},
+		{name: "mixed", to: Recipients{Users: ids("u", 1001), Parties: ids("", 250), Tags: ids("", 100)}, want: [][3]int{{1000, 100, 100}, {1, 100, 0}, {0, 50, 0}}},
+		{name: "@all", to: Recipients{Users: append(ids("u", 2000), "@all"), Parties: ids("", 250)}, want: [][3]int{{1, 0, 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches := splitRecipients(tt.to)
+			if len(batches) != len(tt.want) {
+				t.Fatalf("splitRecipients() = %d batches, want %d", len(batches), len(tt.want))
+			}
+			for i, b := range batches {
+				got := [3]int{len(b.Users), len(b.Parties), len(b.Tags)}
+				if got != tt.want[i] {
+					t.Errorf("splitRecipients()[%d] = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAgent_SendMsgBulk(t *testing.T) {
+	var (
+		calls, running, maxRunning int32
+		failUser                   = "u1500"
+	)
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cgi-bin/gettoken" {
+			fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+			return
+		}
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		i := atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+
+		var msg corp.Msg
+		json.NewDecoder(r.Body).Decode(&msg)
+		users := strings.Split(msg.ToUser, "|")
+		switch {
+		case len(users) > corp.MaxMsgUsers:
+			fmt.Fprint(w, `{"errcode":40058,"errmsg":"too many users"}`)
+		case strings.Contains("|"+msg.ToUser+"|", "|"+failUser+"|"):
+			fmt.Fprint(w, `{"errcode":60011,"errmsg":"no privilege","invaliduser":"u1500"}`)
+		default:
+			fmt.Fprintf(w, `{"errcode":0,"errmsg":"ok","msgid":"msg%d","invaliduser":"%s"}`, i, users[0])
+		}
+	})
+	a.BulkConcurrency = 2
+	msg := &corp.Msg{AgentID: 1000001, MsgType: "text", Text: &corp.TextMsg{Content: "abc"}}
+
+	res, err := a.SendMsgBulk(msg, Recipients{Users: ids("u", 1200), Tags: ids("", 3)})
+	if err != nil {
+		t.Fatalf("Agent.SendMsgBulk() error = %v", err)
+	}
+	if len(res.Batches) != 2 || len(res.MsgIDs()) != 2 {
+		t.Errorf("Agent.SendMsgBulk() batches = %d, msgids = %v", len(res.Batches), res.MsgIDs())
+	}
+	if invalid := res.Invalid().InvalidUsers; len(invalid) != 2 || invalid[0] != "u0" || invalid[1] != "u1000" {
+		t.Errorf("BulkResult.Invalid() = %v", invalid)
+	}
+	if msg.ToUser != "" {
+		t.Errorf("Agent.SendMsgBulk() modified msg.ToUser = %q", msg.ToUser)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	res, err = a.SendMsgBulk(msg, Recipients{Users: ids("u", 4500)})
+	if !errors.Is(err, errcode.ErrNoPrivilege) {
+		t.Fatalf("Agent.SendMsgBulk() error = %v, want %v", err, errcode.ErrNoPrivilege)
+	}
+	if c := atomic.LoadInt32(&calls); c != 5 {
+		t.Errorf("Agent.SendMsgBulk() calls = %d, want 5", c)
+	}
+	failed := res.Failed()
+	if len(failed) != 1 || failed[0].Recipients.Users[0] != "u1000" || len(res.MsgIDs()) != 4 {
+		t.Errorf("BulkResult.Failed() = %d batches, msgids = %v", len(failed), res.MsgIDs())
+	}
+	if m := atomic.LoadInt32(&maxRunning); m > 2 {
+		t.Errorf("Agent.SendMsgBulk() concurrency = %d, want <= 2", m)
+	}
+
+	if _, err = a.SendMsgBulk(msg, Recipients{}); err == nil {
+		t.Error("Agent.SendMsgBulk() without recipients should fail")
+	}
+	if _, err = a.SendMsgBulk(&corp.Msg{AgentID: 1, MsgType: "text"}, Recipients{Users: []string{"u"}}); err == nil {
+		t.Error("Agent.SendMsgBulk() with invalid msg should fail")
+	}
+}
@@ -0,0 +1,333 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp"
+	"github.com/qingtao/wxcorp/corp/crypto"
+)
+
+// defaultMaxCallbackBodySize 回调请求体的默认最大长度
+const defaultMaxCallbackBodySize = 1 << 20
+
+var (
+	// ErrCallbackParams 回调请求缺少签名、时间戳、随机数或者echostr
+	ErrCallbackParams = errors.New("回调请求缺少参数")
+	// ErrCallbackBodyTooLarge 回调请求体超过最大长度
+	ErrCallbackBodyTooLarge = errors.New("回调请求体过大")
+)
+
+// Message 解密后的回调消息, 普通消息和事件解析到Request,
+// 通讯录变更事件(change_contact)同时解析到Contact
+type Message struct {
+	*corp.Request
+	// Contact 通讯录变更事件, 其他消息为nil
+	Contact *corp.ContactEvent
+	// Raw 解密后的XML或者JSON
+	Raw []byte
+	// Format 消息的格式, 与Agent.CallbackFormat相同
+	Format crypto.Format
+}
+
+// IsEvent 是否是事件
+func (m *Message) IsEvent() bool {
+	return m.MsgType == "event"
+}
+
+// Decode 按照消息的格式把Raw解析到v, 例如*corp.TextMessage
+func (m *Message) Decode(v interface{}) error {
+	if m.Format == crypto.JSON {
+		return json.Unmarshal(m.Raw, v)
+	}
+	return xml.Unmarshal(m.Raw, v)
+}
+
+// Reply 按照消息的格式编码被动回复, 返回值可以直接作为Handler的返回值
+func (m *Message) Reply(r corp.Reply) ([]byte, error) {
+	if m.Format == crypto.JSON {
+		return corp.MarshalReplyJSON(r)
+	}
+	return corp.MarshalReply(r)
+}
+
+// decodeMessage 解析解密后的消息
+func decodeMessage(raw []byte, format crypto.Format) (*Message, error) {
+	msg := &Message{Request: new(corp.Request), Raw: raw, Format: format}
+	if err := msg.Decode(msg.Request); err != nil {
+		return nil, errors.Wrap(err, "回调消息格式错误")
+	}
+	if msg.IsEvent() && msg.Event == "change_contact" {
+		msg.Contact = new(corp.ContactEvent)
+		if err := msg.Decode(msg.Contact); err != nil {
+			return nil, errors.Wrap(err, "通讯录变更事件格式错误")
+		}
+	}
+	return msg, nil
+}
+
+// Handler 处理回调消息, 返回被动回复的明文XML或者JSON(例如Message.Reply的结果),
+// 返回空表示不回复
+type Handler interface {
+	ServeMessage(ctx context.Context, msg *Message) (reply []byte, err error)
+}
+
+// HandlerFunc 使用函数实现Handler
+type HandlerFunc func(ctx context.Context, msg *Message) ([]byte, error)
+
+// ServeMessage 调用f(ctx, msg)
+func (f HandlerFunc) ServeMessage(ctx context.Context, msg *Message) ([]byte, error) {
+	return f(ctx, msg)
+}
+
+// CallbackOption 回调处理器选项
+type CallbackOption func(*CallbackHandler)
+
+// WithMaxBodySize 设置回调请求体的最大长度, 默认1MB, 小于等于0时忽略
+func WithMaxBodySize(n int64) CallbackOption {
+	return func(h *CallbackHandler) {
+		if n > 0 {
+			h.maxBodySize = n
+		}
+	}
+}
+
+// WithErrorHandler 设置出现错误时的回调, 用于记录日志等, 为nil时忽略
+func WithErrorHandler(fn func(r *http.Request, err error)) CallbackOption {
+	return func(h *CallbackHandler) {
+		if fn != nil {
+			h.onError = fn
+		}
+	}
+}
+
+// WithSeenSet 设置回调消息的排重集合, 默认使用NewMemorySeenSet(0), 为nil时不排重
+func WithSeenSet(s SeenSet) CallbackOption {
+	return func(h *CallbackHandler) {
+		h.seen = s
+	}
+}
+
+// WithDedupTTL 设置回调消息的排重时间, 默认5分钟, 小于等于0时忽略
+func WithDedupTTL(ttl time.Duration) CallbackOption {
+	return func(h *CallbackHandler) {
+		if ttl > 0 {
+			h.dedupTTL = ttl
+		}
+	}
+}
+
+// CallbackHandler 应用回调地址的http.Handler:
+// GET请求校验签名并返回解密后的echostr, 用于企业微信验证回调URL;
+// POST请求校验签名并解密消息, 排除重复的消息, 调用Handler处理后加密被动回复
+type CallbackHandler struct {
+	agent       *Agent
+	handler     Handler
+	maxBodySize int64
+	onError     func(r *http.Request, err error)
+	seen        SeenSet
+	dedupTTL    time.Duration
+	asyncConfig *AsyncConfig
+	async       *asyncPool
+}
+
+// NewCallbackHandler 新建回调处理器, h为nil时只校验并解密消息, 不回复
+func NewCallbackHandler(a *Agent, h Handler, opts ...CallbackOption) *CallbackHandler {
+	c := &CallbackHandler{
+		agent:       a,
+		handler:     h,
+		maxBodySize: defaultMaxCallbackBodySize,
+		onError:     func(*http.Request, error) {},
+		seen:        NewMemorySeenSet(0),
+		dedupTTL:    defaultSeenTTL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.asyncConfig != nil && h != nil {
+		c.async = newAsyncPool(*c.asyncConfig, c.processAsync, c.asyncPanic)
+	}
+	return c
+}
+
+// ServeHTTP 实现http.Handler
+func (h *CallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.serveVerify(w, r)
+	case http.MethodPost:
+		h.serveMessage(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// fail 记录错误并返回状态码
+func (h *CallbackHandler) fail(w http.ResponseWriter, r *http.Request, code int, err error) {
+	h.onError(r, err)
+	http.Error(w, http.StatusText(code), code)
+}
+
+// decryptStatus 解密失败时的状态码, 过期或者重放的请求返回403
+func decryptStatus(err error) int {
+	var replayErr *ReplayError
+	if errors.As(err, &replayErr) {
+		return http.StatusForbidden
+	}
+	if errors.Is(err, ErrSeenSetFull) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// serveVerify 验证回调URL
+func (h *CallbackHandler) serveVerify(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	signature, timestamp, nonce, echostr := query.Get("msg_signature"), query.Get("timestamp"), query.Get("nonce"), query.Get("echostr")
+	if signature == "" || timestamp == "" || nonce == "" || echostr == "" {
+		h.fail(w, r, http.StatusBadRequest, ErrCallbackParams)
+		return
+	}
+	b, err := h.agent.verifyURL(signature, timestamp, nonce, echostr)
+	if err != nil {
+		h.fail(w, r, decryptStatus(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(b)
+}
+
+// serveMessage 解密并处理回调消息
+func (h *CallbackHandler) serveMessage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	signature, timestamp, nonce := query.Get("msg_signature"), query.Get("timestamp"), query.Get("nonce")
+	if signature == "" || timestamp == "" || nonce == "" {
+		h.fail(w, r, http.StatusBadRequest, ErrCallbackParams)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, h.maxBodySize+1))
+	if err != nil {
+		h.fail(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if int64(len(body)) > h.maxBodySize {
+		h.fail(w, r, http.StatusRequestEntityTooLarge, ErrCallbackBodyTooLarge)
+		return
+	}
+	raw, err := h.agent.decrypt(signature, timestamp, nonce, body)
+	if err != nil {
+		h.fail(w, r, decryptStatus(err), err)
+		return
+	}
+	if err = h.agent.checkReplay(timestamp, nonce); err != nil {
+		// 处理成功但是响应丢失时企业微信使用相同的时间戳和随机数重试, 已经处理的消息按照重复消息返回200
+		if errors.Is(err, ErrNonceReplayed) && h.processed(r, raw) {
+			return
+		}
+		h.fail(w, r, decryptStatus(err), err)
+		return
+	}
+	// 处理失败时删除随机数的记录, 企业微信重试时使用相同的时间戳和随机数
+	fail := func(code int, err error) {
+		if err := h.agent.forgetReplay(timestamp, nonce); err != nil {
+			h.onError(r, errors.WithMessage(err, "删除随机数记录失败"))
+		}
+		h.fail(w, r, code, err)
+	}
+	msg, err := decodeMessage(raw, h.agent.CallbackFormat)
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	if h.handler == nil {
+		return
+	}
+	key, ok, err := h.markSeen(r, msg)
+	if err != nil {
+		fail(http.StatusServiceUnavailable, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	if h.async != nil {
+		if code, err := h.serveAsync(r, key, msg); err != nil {
+			fail(code, err)
+		}
+		return
+	}
+	reply, err := h.handler.ServeMessage(r.Context(), msg)
+	if err != nil {
+		h.unmarkSeen(r, key)
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	if len(reply) == 0 {
+		return
+	}
+	b, err := h.agent.encryptReply(reply)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	if h.agent.CallbackFormat == crypto.JSON {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	}
+	w.Write(b)
+}
+
+// markSeen 记录消息, 重复的消息返回false; 排重集合已满时返回错误, 其他错误时仍然处理消息
+func (h *CallbackHandler) markSeen(r *http.Request, msg *Message) (string, bool, error) {
+	if h.seen == nil {
+		return "", true, nil
+	}
+	key := dedupKey(msg)
+	added, err := h.seen.Add(key, h.dedupTTL)
+	if errors.Is(err, ErrSeenSetFull) {
+		return key, false, err
+	}
+	if err != nil {
+		h.onError(r, errors.WithMessage(err, "记录回调消息失败"))
+		return key, true, nil
+	}
+	return key, added, nil
+}
+
+// processed 判断随机数重复的消息是否已经记录在排重集合中, 未记录时删除检查时添加的记录
+func (h *CallbackHandler) processed(r *http.Request, raw []byte) bool {
+	if h.seen == nil || h.handler == nil {
+		return false
+	}
+	msg, err := decodeMessage(raw, h.agent.CallbackFormat)
+	if err != nil {
+		return false
+	}
+	key := dedupKey(msg)
+	added, err := h.seen.Add(key, h.dedupTTL)
+	if err != nil {
+		return false
+	}
+	if added {
+		h.unmarkSeen(r, key)
+	}
+	return !added
+}
+
+// unmarkSeen 处理消息失败后删除记录, 以便企业微信重试时重新处理
+func (h *CallbackHandler) unmarkSeen(r *http.Request, key string) {
+	if h.seen == nil {
+		return
+	}
+	if err := h.seen.Remove(key); err != nil {
+		h.onError(r, errors.WithMessage(err, "删除回调消息记录失败"))
+	}
+}
@@ -0,0 +1,255 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp"
+	"github.com/qingtao/wxcorp/corp/crypto"
+)
+
+// encryptedMsg 企业微信推送的加密消息
+type encryptedMsg struct {
+	Encrypt      string
+	MsgSignature string
+	TimeStamp    string
+	Nonce        string
+}
+
+// newCallbackAgent 新建用于测试回调的应用
+func newCallbackAgent() *Agent {
+	return NewAgent("wx5823bf96d3bd56c7", "1000001", "secret", "jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2C", "QDG6eK")
+}
+
+// mustCrypter 返回应用的加解密工具
+func mustCrypter(t *testing.T, a *Agent) *crypto.Crypter {
+	t.Helper()
+	c, err := a.crypter()
+	if err != nil {
+		t.Fatalf("crypter() error = %v", err)
+	}
+	return c
+}
+
+// encryptForTest 模拟企业微信加密消息
+func encryptForTest(t *testing.T, a *Agent, plain string) *encryptedMsg {
+	t.Helper()
+	b, err := mustCrypter(t, a).EncryptMsg([]byte(plain), "1409659813", "1372623149", crypto.XML)
+	if err != nil {
+		t.Fatalf("EncryptMsg() error = %v", err)
+	}
+	var m encryptedMsg
+	if err := xml.Unmarshal(b, &m); err != nil {
+		t.Fatalf("xml.Unmarshal() error = %v", err)
+	}
+	return &m
+}
+
+// callbackQuery 回调请求的查询参数
+func callbackQuery(m *encryptedMsg, echostr bool) string {
+	v := url.Values{}
+	v.Set("msg_signature", m.MsgSignature)
+	v.Set("timestamp", m.TimeStamp)
+	v.Set("nonce", m.Nonce)
+	if echostr {
+		v.Set("echostr", m.Encrypt)
+	}
+	return "/callback?" + v.Encode()
+}
+
+func TestCallbackHandler_Verify(t *testing.T) {
+	a := newCallbackAgent()
+	m := encryptForTest(t, a, "6467373778033604605")
+	bad := *m
+	bad.MsgSignature = "0000"
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		wantBody string
+	}{
+		{name: "ok", target: callbackQuery(m, true), wantCode: http.StatusOK, wantBody: "6467373778033604605"},
+		{name: "signature", target: callbackQuery(&bad, true), wantCode: http.StatusBadRequest},
+		{name: "params", target: "/callback?echostr=1", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotErr error
+			h := NewCallbackHandler(a, nil, WithErrorHandler(func(r *http.Request, err error) { gotErr = err }))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("ServeHTTP() code = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK && w.Body.String() != tt.wantBody {
+				t.Errorf("ServeHTTP() body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			if (gotErr != nil) != (tt.wantCode != http.StatusOK) {
+				t.Errorf("ServeHTTP() error = %v", gotErr)
+			}
+		})
+	}
+}
+
+func TestCallbackHandler_Message(t *testing.T) {
+	a := newCallbackAgent()
+	const (
+		textMsg    = `<xml><ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName><FromUserName><![CDATA[zhangsan]]></FromUserName><CreateTime>1348831860</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[hello]]></Content><MsgId>1234567890123456</MsgId><AgentID>1</AgentID></xml>`
+		contactMsg = `<xml><ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName><FromUserName><![CDATA[sys]]></FromUserName><CreateTime>1403610513</CreateTime><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[change_contact]]></Event><ChangeType>create_user</ChangeType><UserID><![CDATA[lisi]]></UserID><Department><![CDATA[1]]></Department></xml>`
+	)
+	echo := HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+		switch {
+		case msg.Contact != nil:
+			return []byte(corp.NewTextReplyMsg(msg.FromUserName, msg.ToUserName, msg.Contact.ChangeType+":"+msg.Contact.UserID, 1)), nil
+		case msg.Content == "error":
+			return nil, errors.New("handler")
+		case msg.Content == "silent":
+			return nil, nil
+		}
+		return []byte(corp.NewTextReplyMsg(msg.FromUserName, msg.ToUserName, msg.Content, 1)), nil
+	})
+	body := func(m *encryptedMsg) string {
+		return fmt.Sprintf("<xml><ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName><Encrypt><![CDATA[%s]]></Encrypt><AgentID><![CDATA[1]]></AgentID></xml>", m.Encrypt)
+	}
+	text := encryptForTest(t, a, textMsg)
+	contact := encryptForTest(t, a, contactMsg)
+	fail := encryptForTest(t, a, strings.Replace(textMsg, "hello", "error", 1))
+	silent := encryptForTest(t, a, strings.Replace(textMsg, "hello", "silent", 1))
+	invalid := encryptForTest(t, a, "<xml><MsgType>")
+
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		body      string
+		opts      []CallbackOption
+		wantCode  int
+		wantReply string
+	}{
+		{name: "text", method: http.MethodPost, target: callbackQuery(text, false), body: body(text), wantCode: http.StatusOK, wantReply: "hello"},
+		{name: "contact", method: http.MethodPost, target: callbackQuery(contact, false), body: body(contact), wantCode: http.StatusOK, wantReply: "create_user:lisi"},
+		{name: "silent", method: http.MethodPost, target: callbackQuery(silent, false), body: body(silent), wantCode: http.StatusOK},
+		{name: "handler error", method: http.MethodPost, target: callbackQuery(fail, false), body: body(fail), wantCode: http.StatusInternalServerError},
+		{name: "invalid xml", method: http.MethodPost, target: callbackQuery(invalid, false), body: body(invalid), wantCode: http.StatusBadRequest},
+		{name: "signature", method: http.MethodPost, target: callbackQuery(contact, false), body: body(text), wantCode: http.StatusBadRequest},
+		{name: "params", method: http.MethodPost, target: "/callback", body: body(text), wantCode: http.StatusBadRequest},
+		{name: "too large", method: http.MethodPost, target: callbackQuery(text, false), body: body(text), opts: []CallbackOption{WithMaxBodySize(16)}, wantCode: http.StatusRequestEntityTooLarge},
+		{name: "method", method: http.MethodPut, target: callbackQuery(text, false), wantCode: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewCallbackHandler(a, echo, tt.opts...)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("ServeHTTP() code = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if tt.wantReply == "" {
+				if w.Body.Len() != 0 {
+					t.Errorf("ServeHTTP() body = %q, want empty", w.Body.String())
+				}
+				return
+			}
+			var reply encryptedMsg
+			if err := xml.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+				t.Fatalf("xml.Unmarshal() error = %v", err)
+			}
+			plain, err := a.decryptMsg(reply.MsgSignature, reply.TimeStamp, reply.Nonce, w.Body.Bytes())
+			if err != nil {
+				t.Fatalf("decryptMsg() error = %v", err)
+			}
+			var got corp.Request
+			if err = xml.Unmarshal(plain, &got); err != nil {
+				t.Fatalf("xml.Unmarshal() error = %v", err)
+			}
+			if got.Content != tt.wantReply || got.ToUserName == "" {
+				t.Errorf("ServeHTTP() reply = %+v, want content %q", got, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestAgent_EncryptReply(t *testing.T) {
+	a := newCallbackAgent()
+	b, err := a.EncryptReply(corp.NewTextReply("zhangsan", a.CorpID, "a]]>b", 1))
+	if err != nil {
+		t.Fatalf("Agent.EncryptReply() error = %v", err)
+	}
+	var env encryptedMsg
+	if err = xml.Unmarshal(b, &env); err != nil {
+		t.Fatalf("xml.Unmarshal() error = %v", err)
+	}
+	plain, err := a.decryptMsg(env.MsgSignature, env.TimeStamp, env.Nonce, b)
+	if err != nil {
+		t.Fatalf("decryptMsg() error = %v", err)
+	}
+	var got corp.TextReply
+	if err = xml.Unmarshal(plain, &got); err != nil || got.Content != "a]]>b" {
+		t.Errorf("Agent.EncryptReply() = %s, error = %v", plain, err)
+	}
+	if _, err = a.EncryptReply(nil); err == nil {
+		t.Errorf("Agent.EncryptReply(nil) want error")
+	}
+}
+
+func TestCallbackHandler_JSON(t *testing.T) {
+	a := newCallbackAgent()
+	a.CallbackFormat = crypto.JSON
+	c, err := a.crypter()
+	if err != nil {
+		t.Fatalf("crypter() error = %v", err)
+	}
+	plain := `{"ToUserName":"wx5823bf96d3bd56c7","FromUserName":"zhangsan","CreateTime":1348831860,"MsgType":"text","Content":"hello","MsgId":1,"AgentID":"1"}`
+	b, err := c.EncryptMsg([]byte(plain), "1409659813", "1372623149", crypto.JSON)
+	if err != nil {
+		t.Fatalf("EncryptMsg() error = %v", err)
+	}
+	var m struct {
+		Encrypt      string `json:"encrypt"`
+		MsgSignature string `json:"msgsignature"`
+	}
+	json.Unmarshal(b, &m)
+	body := fmt.Sprintf(`{"tousername":"wx5823bf96d3bd56c7","encrypt":%q,"agentid":"1"}`, m.Encrypt)
+	target := callbackQuery(&encryptedMsg{MsgSignature: m.MsgSignature, TimeStamp: "1409659813", Nonce: "1372623149"}, false)
+
+	h := NewCallbackHandler(a, HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+		return msg.Reply(corp.NewTextReply(msg.FromUserName, msg.ToUserName, "re:"+msg.Content, 1))
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("ServeHTTP() = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var env struct {
+		MsgSignature string `json:"msgsignature"`
+		TimeStamp    int64  `json:"timestamp"`
+		Nonce        string `json:"nonce"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatalf("json.Unmarshal(%s) error = %v", w.Body.Bytes(), err)
+	}
+	reply, err := a.decryptMsg(env.MsgSignature, fmt.Sprint(env.TimeStamp), env.Nonce, w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("decryptMsg() error = %v", err)
+	}
+	want := `{"ToUserName":"zhangsan","FromUserName":"wx5823bf96d3bd56c7","CreateTime":1,"MsgType":"text","Content":"re:hello"}`
+	if string(reply) != want {
+		t.Errorf("reply = %s, want %s", reply, want)
+	}
+
+	if b, err = a.EncryptReply(corp.NewImageReply("zhangsan", a.CorpID, "media", 1)); err != nil || !json.Valid(b) {
+		t.Errorf("Agent.EncryptReply() = %s, %v", b, err)
+	}
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultSeenCapacity 默认最多记录的消息数
+	defaultSeenCapacity = 10000
+	// defaultSeenTTL 默认的消息排重时间, 企业微信5秒内未收到响应会重试3次
+	defaultSeenTTL = 5 * time.Minute
+)
+
+// ErrSeenSetFull 集合的记录数已经达到容量并且都没有过期
+var ErrSeenSetFull = errors.New("排重集合已满")
+
+// SeenSet 已处理的回调消息集合, 用于排除企业微信重试的重复消息,
+// 多个进程处理同一个应用的回调时可以使用共享的实现(例如Redis的SET NX)
+type SeenSet interface {
+	// Add 记录key并在ttl后过期, key已经存在且未过期时返回false
+	Add(key string, ttl time.Duration) (added bool, err error)
+	// Remove 删除key, 处理消息失败后调用, 以便企业微信重试时重新处理
+	Remove(key string) error
+}
+
+// seenEntry 已处理消息的记录
+type seenEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// MemorySeenSet 内存中的集合, 记录数达到容量时先淘汰已经过期的记录, 仍然没有空间时返回ErrSeenSetFull,
+// 不淘汰未过期的记录, 避免重复处理消息或者放过重放的请求; CallbackHandler此时返回503, 企业微信稍后重试
+type MemorySeenSet struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+	now      func() time.Time
+}
+
+// NewMemorySeenSet 新建内存集合, capacity小于等于0时使用默认容量10000
+func NewMemorySeenSet(capacity int) *MemorySeenSet {
+	if capacity <= 0 {
+		capacity = defaultSeenCapacity
+	}
+	return &MemorySeenSet{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// Add 记录key
+func (s *MemorySeenSet) Add(key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if e, ok := s.items[key]; ok {
+		if now.Before(e.Value.(*seenEntry).expiresAt) {
+			return false, nil
+		}
+		s.removeElement(e)
+	}
+	// 淘汰已经过期的记录, 按照添加的顺序检查
+	for e := s.ll.Back(); e != nil && !now.Before(e.Value.(*seenEntry).expiresAt); e = s.ll.Back() {
+		s.removeElement(e)
+	}
+	if s.ll.Len() >= s.capacity {
+		// ttl不同时过期的记录可能不在末尾
+		for e := s.ll.Front(); e != nil; {
+			next := e.Next()
+			if !now.Before(e.Value.(*seenEntry).expiresAt) {
+				s.removeElement(e)
+			}
+			e = next
+		}
+		if s.ll.Len() >= s.capacity {
+			return false, ErrSeenSetFull
+		}
+	}
+	s.items[key] = s.ll.PushFront(&seenEntry{key: key, expiresAt: now.Add(ttl)})
+	return true, nil
+}
+
+// Remove 删除key
+func (s *MemorySeenSet) Remove(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok {
+		s.removeElement(e)
+	}
+	return nil
+}
+
+// Len 返回记录数
+func (s *MemorySeenSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// removeElement 删除记录
+func (s *MemorySeenSet) removeElement(e *list.Element) {
+	s.ll.Remove(e)
+	delete(s.items, e.Value.(*seenEntry).key)
+}
+
+// dedupKey 消息的排重键: 普通消息使用MsgId, 事件没有MsgId, 使用发送者、创建时间和事件内容
+func dedupKey(msg *Message) string {
+	if msg.MsgID != 0 {
+		return msg.ToUserName + "/" + msg.AgentID + "/msg/" + strconv.FormatInt(msg.MsgID, 10)
+	}
+	parts := []string{msg.ToUserName, msg.AgentID, "event", msg.FromUserName, strconv.FormatInt(msg.CreateTime, 10), msg.Event, msg.EventKey}
+	if c := msg.Contact; c != nil {
+		parts = append(parts, c.ChangeType, c.UserID, strconv.Itoa(c.ID), strconv.Itoa(c.TagID))
+	}
+	return strings.Join(parts, "/")
+}
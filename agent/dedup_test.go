@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp"
+)
+
+func TestMemorySeenSet(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	s := NewMemorySeenSet(2)
+	s.now = func() time.Time { return now }
+
+	add := func(key string, ttl time.Duration, want bool) {
+		t.Helper()
+		if got, err := s.Add(key, ttl); err != nil || got != want {
+			t.Fatalf("MemorySeenSet.Add(%q) = %v, %v, want %v", key, got, err, want)
+		}
+	}
+	add("a", time.Minute, true)
+	add("a", time.Minute, false)
+	add("b", 2*time.Minute, true)
+	// 达到容量时不淘汰未过期的记录
+	if got, err := s.Add("c", time.Minute); got || err != ErrSeenSetFull {
+		t.Errorf("MemorySeenSet.Add(%q) = %v, %v, want %v", "c", got, err, ErrSeenSetFull)
+	}
+	if s.Len() != 2 {
+		t.Errorf("MemorySeenSet.Len() = %d, want 2", s.Len())
+	}
+	add("a", time.Minute, false)
+	s.Remove("a")
+	add("c", 30*time.Second, true)
+	// 不在末尾的过期记录也会被淘汰, 过期的记录可以重新添加
+	now = now.Add(time.Minute)
+	add("d", time.Minute, true)
+	now = now.Add(time.Minute)
+	add("b", time.Minute, true)
+	if s.Len() != 1 {
+		t.Errorf("MemorySeenSet.Len() = %d, want 1", s.Len())
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	text := &Message{Request: &corp.Request{ToUserName: "corp", AgentID: "1", FromUserName: "zhangsan", MsgID: 123, CreateTime: 1}}
+	click := &Message{Request: &corp.Request{ToUserName: "corp", AgentID: "1", FromUserName: "zhangsan", MsgType: "event", Event: "click", EventKey: "k", CreateTime: 1}}
+	location := &Message{Request: &corp.Request{ToUserName: "corp", AgentID: "1", FromUserName: "zhangsan", MsgType: "event", Event: "LOCATION", CreateTime: 1}}
+	contact := &Message{Request: &corp.Request{ToUserName: "corp", FromUserName: "sys", MsgType: "event", Event: "change_contact", CreateTime: 1}, Contact: &corp.ContactEvent{ChangeType: "create_user", UserID: "lisi"}}
+	contact2 := &Message{Request: contact.Request, Contact: &corp.ContactEvent{ChangeType: "create_user", UserID: "wangwu"}}
+
+	keys := make(map[string]bool)
+	for _, m := range []*Message{text, click, location, contact, contact2} {
+		k := dedupKey(m)
+		if keys[k] {
+			t.Errorf("dedupKey() = %q duplicated", k)
+		}
+		keys[k] = true
+	}
+	if got := dedupKey(text); got != "corp/1/msg/123" {
+		t.Errorf("dedupKey() = %q, want corp/1/msg/123", got)
+	}
+}
+
+func TestCallbackHandler_Dedup(t *testing.T) {
+	a := newCallbackAgent()
+	raw := `<xml><ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName><FromUserName><![CDATA[zhangsan]]></FromUserName><CreateTime>1348831860</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[%s]]></Content><MsgId>%d</MsgId><AgentID>1</AgentID></xml>`
+
+	tests := []struct {
+		name    string
+		content string
+		opts    []CallbackOption
+		want    int
+	}{
+		{name: "dedup", content: "hello", want: 1},
+		{name: "retry after error", content: "error", want: 3},
+		{name: "disabled", content: "hello", opts: []CallbackOption{WithSeenSet(nil)}, want: 3},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			h := NewCallbackHandler(a, HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+				calls++
+				if msg.Content == "error" {
+					return nil, errors.New("handler")
+				}
+				return nil, nil
+			}), tt.opts...)
+			m := encryptForTest(t, a, fmt.Sprintf(raw, tt.content, i+1))
+			body := fmt.Sprintf("<xml><Encrypt><![CDATA[%s]]></Encrypt></xml>", m.Encrypt)
+			for j := 0; j < 3; j++ {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, callbackQuery(m, false), strings.NewReader(body)))
+			}
+			if calls != tt.want {
+				t.Errorf("handler calls = %d, want %d", calls, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// flightCall 正在进行中的请求
+type flightCall struct {
+	wg  sync.WaitGroup
+	val string
+	err error
+}
+
+// flightGroup 合并相同key的并发请求, 请求进行中时其他调用方等待并得到同一个结果
+type flightGroup struct {
+	mu sync.Mutex
+	m  map[string]*flightCall
+}
+
+// Do 执行fn, 同一时刻相同的key只有一个fn在执行
+func (g *flightGroup) Do(key string, fn func() (string, error)) (string, error) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*flightCall)
+	}
+	if c, ok := g.m[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := new(flightCall)
+	c.wg.Add(1)
+	g.m[key] = c
+	g.mu.Unlock()
+
+	g.call(c, key, fn)
+	return c.val, c.err
+}
+
+// call 执行fn并唤醒等待的调用方, fn发生panic时等待的调用方得到错误, 当前调用方继续panic
+func (g *flightGroup) call(c *flightCall, key string, fn func() (string, error)) {
+	normal := false
+	defer func() {
+		if !normal {
+			r := recover()
+			c.val, c.err = "", errors.Errorf("请求%s时发生panic: %v", key, r)
+			if r != nil {
+				defer panic(r)
+			}
+		}
+		c.wg.Done()
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+	}()
+	c.val, c.err = fn()
+	normal = true
+}
@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroup_Do(t *testing.T) {
+	var (
+		g     flightGroup
+		n     int32
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			v, err := g.Do("k", func() (string, error) {
+				atomic.AddInt32(&n, 1)
+				time.Sleep(20 * time.Millisecond)
+				return "v", nil
+			})
+			if err != nil || v != "v" {
+				t.Errorf("flightGroup.Do() = %v, %v", v, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if n != 1 {
+		t.Errorf("flightGroup.Do() called fn %d times, want 1", n)
+	}
+}
+
+// testConcurrentRefresh 并发读取令牌, 所有调用方得到相同的结果且只请求一次服务器
+func testConcurrentRefresh(t *testing.T, path string, ok bool, get func(a *Agent) (string, error)) {
+	var n int32
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cgi-bin/gettoken" && path != r.URL.Path {
+			fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+			return
+		}
+		if r.URL.Path != path {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+		atomic.AddInt32(&n, 1)
+		time.Sleep(50 * time.Millisecond)
+		if !ok {
+			fmt.Fprint(w, `{"errcode":-1,"errmsg":"system busy"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"fresh","ticket":"fresh","expires_in":7200}`)
+	})
+	if path != "/cgi-bin/gettoken" {
+		// 预先获取访问令牌, 只统计ticket请求
+		if _, err := a.GetAccessToken(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var (
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			v, err := get(a)
+			if ok && (err != nil || v != "fresh") {
+				t.Errorf("got %q, %v, want fresh", v, err)
+			}
+			if !ok && (err == nil || v != "") {
+				t.Errorf("got %q, %v, want error", v, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if n != 1 {
+		t.Errorf("%s requested %d times, want 1", path, n)
+	}
+}
+
+func TestAgent_ConcurrentRefresh(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		get  func(a *Agent) (string, error)
+	}{
+		{
+			name: "GetAccessToken",
+			path: "/cgi-bin/gettoken",
+			get:  func(a *Agent) (string, error) { return a.GetAccessToken() },
+		},
+		{
+			name: "RefreshAccessToken",
+			path: "/cgi-bin/gettoken",
+			get:  func(a *Agent) (string, error) { return a.RefreshAccessToken() },
+		},
+		{
+			name: "GetJsAPITicket",
+			path: "/cgi-bin/get_jsapi_ticket",
+			get:  func(a *Agent) (string, error) { return a.GetJsAPITicket("") },
+		},
+		{
+			name: "GetAgentJsAPITicket",
+			path: "/cgi-bin/ticket/get",
+			get:  func(a *Agent) (string, error) { return a.GetJsAPITicket("agent_config") },
+		},
+		{
+			name: "RefreshAgentJsAPITicket",
+			path: "/cgi-bin/ticket/get",
+			get:  func(a *Agent) (string, error) { return a.RefreshJsAPITicket("agent_config") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConcurrentRefresh(t, tt.path, true, tt.get)
+		})
+		t.Run(tt.name+"Error", func(t *testing.T) {
+			testConcurrentRefresh(t, tt.path, false, tt.get)
+		})
+	}
+}
+
+func TestFlightGroup_DoPanic(t *testing.T) {
+	var g flightGroup
+	started, release := make(chan struct{}), make(chan struct{})
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() { panicked <- recover() }()
+		g.Do("k", func() (string, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := g.Do("k", func() (string, error) { return "v", nil })
+		done <- err
+	}()
+	// 等待第二个调用方进入等待
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("flightGroup.Do() waiter error = nil, want panic error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("flightGroup.Do() waiter blocked after panic")
+	}
+	if r := <-panicked; r != "boom" {
+		t.Errorf("flightGroup.Do() panic = %v, want boom", r)
+	}
+	// panic后key已经释放
+	if v, err := g.Do("k", func() (string, error) { return "v", nil }); err != nil || v != "v" {
+		t.Errorf("flightGroup.Do() after panic = %v, %v", v, err)
+	}
+}
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qingtao/wxcorp/corp"
+)
+
+// refreshIPList 后台刷新IP白名单的名称, 参考OnRefreshError
+const refreshIPList = "ip_list"
+
+// SetIPList 从企业微信获取回调服务器的IP列表并替换当前的白名单
+func (a *Agent) SetIPList() error {
+	return a.setIPList(context.Background())
+}
+
+// setIPList 获取并替换IP白名单
+func (a *Agent) setIPList(ctx context.Context) error {
+	var res *corp.IPListResponse
+	err := a.do(ctx, func(accessToken string) (err error) {
+		res, err = a.client().GetCallBackIPList(ctx, "", accessToken)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	m, err := corp.ParseIPList(res.IPList)
+	if err != nil {
+		return err
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.ipList, a.ipListUpdated = m, time.Now()
+	return nil
+}
+
+// ipListState 返回IP白名单和更新时间
+func (a *Agent) ipListState() (*corp.IPMatcher, time.Time) {
+	a.Lock()
+	defer a.Unlock()
+	return a.ipList, a.ipListUpdated
+}
+
+// parseRemoteAddr 解析"host:port"或者单独的IP地址, 支持IPv6
+func parseRemoteAddr(remoteAddr string) net.IP {
+	host := strings.TrimSpace(remoteAddr)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host)
+}
+
+// IsMatch 判断remoteAddr(可以包含端口)是否在IP白名单中, 白名单未设置时返回false
+func (a *Agent) IsMatch(remoteAddr string) bool {
+	m, _ := a.ipListState()
+	return m.Contains(parseRemoteAddr(remoteAddr))
+}
+
+// IPFilterOption IP白名单中间件选项
+type IPFilterOption func(*ipFilter)
+
+// WithTrustedProxies 设置可信的反向代理, 请求来自可信代理时从X-Forwarded-For或者X-Real-IP获取客户端地址
+func WithTrustedProxies(m *corp.IPMatcher) IPFilterOption {
+	return func(f *ipFilter) {
+		f.trusted = m
+	}
+}
+
+// ipFilter IP白名单中间件
+type ipFilter struct {
+	agent   *Agent
+	next    http.Handler
+	trusted *corp.IPMatcher
+}
+
+// IPFilter 返回只允许IP白名单中的地址访问next的中间件, 其他请求返回403.
+// 白名单为空时拒绝所有请求, 需要先调用SetIPList或者设置IPListRefreshInterval后Start
+func (a *Agent) IPFilter(next http.Handler, opts ...IPFilterOption) http.Handler {
+	f := &ipFilter{agent: a, next: next}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// ServeHTTP 实现http.Handler
+func (f *ipFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m, _ := f.agent.ipListState()
+	if !m.Contains(f.clientIP(r)) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	f.next.ServeHTTP(w, r)
+}
+
+// clientIP 获取客户端地址: 请求来自可信代理时, 从右向左查找X-Forwarded-For中第一个不是可信代理的地址,
+// 格式错误时返回nil
+func (f *ipFilter) clientIP(r *http.Request) net.IP {
+	ip := parseRemoteAddr(r.RemoteAddr)
+	if ip == nil || !f.trusted.Contains(ip) {
+		return ip
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		if v := r.Header.Get("X-Real-IP"); v != "" {
+			return parseRemoteAddr(v)
+		}
+		return ip
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = parseRemoteAddr(hops[i])
+		if ip == nil || !f.trusted.Contains(ip) {
+			return ip
+		}
+	}
+	return ip
+}
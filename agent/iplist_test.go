@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qingtao/wxcorp/corp"
+)
+
+// newIPListAgent 新建返回IP列表的测试应用, 每次请求依次返回lists中的列表
+func newIPListAgent(t *testing.T, lists ...string) (*Agent, *int32) {
+	var n int32
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cgi-bin/gettoken":
+			fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+		case "/cgi-bin/getcallbackip":
+			i := int(atomic.AddInt32(&n, 1)) - 1
+			if i >= len(lists) {
+				i = len(lists) - 1
+			}
+			fmt.Fprintf(w, `{"ip_list":[%s]}`, lists[i])
+		}
+	})
+	return a, &n
+}
+
+func TestAgent_IsMatch(t *testing.T) {
+	a, _ := newIPListAgent(t, `"101.226.103.*","182.254.11.176","2001:db8::/32"`, `"10.0.0.0/8"`)
+	if a.IsMatch("101.226.103.59:443") {
+		t.Errorf("Agent.IsMatch() before SetIPList should be false")
+	}
+	if err := a.SetIPList(); err != nil {
+		t.Fatalf("Agent.SetIPList() error = %v", err)
+	}
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{remoteAddr: "101.226.103.59:443", want: true},
+		{remoteAddr: "101.226.103.59", want: true},
+		{remoteAddr: "182.254.11.176:80", want: true},
+		{remoteAddr: "182.254.11.177:80", want: false},
+		{remoteAddr: "[2001:db8::1]:8080", want: true},
+		{remoteAddr: "2001:db8::1", want: true},
+		{remoteAddr: "localhost", want: false},
+		{remoteAddr: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			if got := a.IsMatch(tt.remoteAddr); got != tt.want {
+				t.Errorf("Agent.IsMatch(%q) = %v, want %v", tt.remoteAddr, got, tt.want)
+			}
+		})
+	}
+	// 再次设置时替换原来的列表
+	if err := a.SetIPList(); err != nil {
+		t.Fatalf("Agent.SetIPList() error = %v", err)
+	}
+	if a.IsMatch("101.226.103.59:443") || !a.IsMatch("10.1.1.1:443") {
+		t.Errorf("Agent.SetIPList() should replace the old list")
+	}
+}
+
+func TestAgent_IPFilter(t *testing.T) {
+	a, _ := newIPListAgent(t, `"101.226.103.*"`)
+	if err := a.SetIPList(); err != nil {
+		t.Fatalf("Agent.SetIPList() error = %v", err)
+	}
+	trusted, _ := corp.ParseIPList([]string{"127.0.0.1", "10.0.0.0/8"})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     map[string]string
+		opts       []IPFilterOption
+		want       int
+	}{
+		{name: "direct", remoteAddr: "101.226.103.59:443", want: http.StatusOK},
+		{name: "denied", remoteAddr: "8.8.8.8:443", want: http.StatusForbidden},
+		{name: "untrusted proxy", remoteAddr: "127.0.0.1:80", header: map[string]string{"X-Forwarded-For": "101.226.103.59"}, want: http.StatusForbidden},
+		{name: "trusted proxy", remoteAddr: "127.0.0.1:80", header: map[string]string{"X-Forwarded-For": "101.226.103.59, 10.0.0.2"}, opts: []IPFilterOption{WithTrustedProxies(trusted)}, want: http.StatusOK},
+		{name: "spoofed", remoteAddr: "127.0.0.1:80", header: map[string]string{"X-Forwarded-For": "101.226.103.59, 8.8.8.8"}, opts: []IPFilterOption{WithTrustedProxies(trusted)}, want: http.StatusForbidden},
+		{name: "real ip", remoteAddr: "127.0.0.1:80", header: map[string]string{"X-Real-IP": "101.226.103.59"}, opts: []IPFilterOption{WithTrustedProxies(trusted)}, want: http.StatusOK},
+		{name: "malformed", remoteAddr: "127.0.0.1:80", header: map[string]string{"X-Forwarded-For": "unknown"}, opts: []IPFilterOption{WithTrustedProxies(trusted)}, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/callback", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			a.IPFilter(next, tt.opts...).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("IPFilter() code = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgent_StartIPList(t *testing.T) {
+	a, n := newIPListAgent(t, `"101.226.103.*"`)
+	a.IPListRefreshInterval = time.Hour
+	if err := a.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !a.IsMatch("101.226.103.59") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.Stop()
+	if !a.IsMatch("101.226.103.59") || atomic.LoadInt32(n) != 1 {
+		t.Errorf("IP list after background refresh: match = %v, requests = %d", a.IsMatch("101.226.103.59"), atomic.LoadInt32(n))
+	}
+}
@@ -1,35 +1,17 @@
 package agent
 
-import (
-	"time"
+import "context"
 
-	"github.com/qingtao/wxcorp/corp"
-	"github.com/qingtao/wxcorp/corp/errcode"
-)
-
-// GetUserInfo 通过微信授权返回用户信息
+// GetUserInfo 通过微信授权返回用户信息. code只能使用一次, 重试会返回code无效并掩盖原来的错误,
+// 因此只在令牌错误时刷新令牌后重试
 func (a *Agent) GetUserInfo(code string) (userid, deviceid string, err error) {
-	accessToken, err := a.GetAccessToken()
-	if err != nil {
-		return "", "", err
-	}
-	for i := 0; i < retryTimes; i++ {
-		res, err := corp.GetUserInfoWithCode("", accessToken, code)
-		// 无错误返回
+	ctx := context.Background()
+	err = a.doOnce(ctx, func(accessToken string) error {
+		res, err := a.client().GetUserInfoWithCode(ctx, "", accessToken, code)
 		if err == nil {
 			userid, deviceid = res.UserID, res.DeviceID
-			break
-		}
-		// 如果是令牌错误,主动刷新令牌
-		if err == errcode.ErrInvalidAccessToken {
-			// 刷新令牌错误返回
-			accessToken, err = a.RefreshAccessToken()
-			if err != nil {
-				return "", "", err
-			}
 		}
-		// 等待指定时间后重试
-		time.Sleep(retryInterval)
-	}
+		return err
+	})
 	return
 }
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp/errcode"
+)
+
+func TestAgent_GetUserInfo(t *testing.T) {
+	tests := []struct {
+		name      string
+		first     string
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "busy", first: `{"errcode":-1,"errmsg":"system busy"}`, wantCalls: 1, wantErr: errcode.ErrSystemBusy},
+		{name: "expired", first: `{"errcode":42001,"errmsg":"access_token expired"}`, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/cgi-bin/gettoken" {
+					fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+					return
+				}
+				calls++
+				if calls == 1 {
+					fmt.Fprint(w, tt.first)
+					return
+				}
+				// code只能使用一次
+				fmt.Fprint(w, `{"errcode":40029,"errmsg":"invalid code"}`)
+			})
+			a.Retry = &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+			_, _, err := a.GetUserInfo("code")
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Agent.GetUserInfo() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("getuserinfo requested %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp"
+	"github.com/qingtao/wxcorp/corp/errcode"
+)
+
+var (
+	// ErrOutboxDuplicate 发件箱中已经存在相同key的消息
+	ErrOutboxDuplicate = errors.New("发件箱中已存在相同key的消息")
+	// ErrOutboxStarted 发件箱已经启动
+	ErrOutboxStarted = errors.New("发件箱已经启动")
+)
+
+// DefaultOutboxRetry 发件箱默认的重试策略, 最多尝试10次, 间隔从1秒增长到10分钟
+var DefaultOutboxRetry = RetryPolicy{
+	MaxAttempts: 10,
+	BaseDelay:   time.Second,
+	MaxDelay:    10 * time.Minute,
+}
+
+const (
+	// defaultOutboxPollInterval 默认检查待发送消息的间隔
+	defaultOutboxPollInterval = time.Second
+	// defaultOutboxRetention 发送成功的消息默认保留的时间
+	defaultOutboxRetention = 24 * time.Hour
+)
+
+// OutboxConfig 发件箱的配置
+type OutboxConfig struct {
+	// Store 消息存储, 默认使用内存存储; 需要在进程重启后继续发送时使用FileOutboxStore
+	Store OutboxStore
+	// Retry 发送失败后的重试策略, MaxAttempts为0时使用DefaultOutboxRetry
+	Retry RetryPolicy
+	// PollInterval 检查待发送消息的间隔, 默认1秒
+	PollInterval time.Duration
+	// Retention 发送成功的消息保留的时间, 保留期间相同key的消息不会重复发送, 默认24小时
+	Retention time.Duration
+	// OnDead 消息进入死信时的回调
+	OnDead func(e *OutboxEntry)
+}
+
+// Outbox 持久化的发件箱, 消息先保存到存储再由后台发送, 失败后按照重试策略退避重试,
+// 超过重试次数或者出现不可恢复的错误时进入死信. 每次尝试只发送一次请求, 不使用Agent.Retry,
+// 仅在令牌失效时刷新令牌后重新请求.
+// 与Agent.SendMsg相同, 网络错误、服务器错误和系统繁忙时消息可能已经送达, 未设置corp.Msg.EnableDuplicateCheck时
+// 这类消息直接进入死信, 需要确认未送达后使用Retry重新发送; 设置了EnableDuplicateCheck时由企业微信排重, 按照重试策略重试.
+// 发送成功但更新状态前进程退出时, 重启后会再次发送, 同样需要设置EnableDuplicateCheck避免重复
+type Outbox struct {
+	agent *Agent
+	cfg   OutboxConfig
+	now   func() time.Time
+
+	// flushMu 保证同时只有一个Flush
+	flushMu sync.Mutex
+
+	mu   sync.Mutex
+	stop context.CancelFunc
+	done chan struct{}
+	wake chan struct{}
+}
+
+// NewOutbox 新建发件箱, 使用Start启动后台发送
+func NewOutbox(a *Agent, cfg OutboxConfig) *Outbox {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryOutboxStore()
+	}
+	if cfg.Retry.MaxAttempts <= 0 {
+		cfg.Retry = DefaultOutboxRetry
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultOutboxPollInterval
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = defaultOutboxRetention
+	}
+	return &Outbox{
+		agent: a,
+		cfg:   cfg,
+		now:   time.Now,
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// Enqueue 把消息的副本保存到发件箱, 之后修改msg不影响发送的内容;
+// key用于去重, 已经存在相同key的消息时返回已有的消息和ErrOutboxDuplicate
+func (o *Outbox) Enqueue(key string, msg *corp.Msg) (*OutboxEntry, error) {
+	if key == "" {
+		return nil, errors.New("发件箱消息的key为空")
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	msg, err := copyMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+	now := o.now()
+	e := &OutboxEntry{
+		Key:       key,
+		Msg:       msg,
+		Status:    OutboxPending,
+		NextAt:    now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	existing, added, err := o.cfg.Store.Add(e)
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return existing, ErrOutboxDuplicate
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return e.clone(), nil
+}
+
+// Status 查询消息的状态
+func (o *Outbox) Status(key string) (*OutboxEntry, error) {
+	e, err := o.cfg.Store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, ErrOutboxNotFound
+	}
+	return e, nil
+}
+
+// Pending 等待发送的消息
+func (o *Outbox) Pending() ([]*OutboxEntry, error) {
+	return o.cfg.Store.List(OutboxPending)
+}
+
+// DeadLetters 进入死信的消息
+func (o *Outbox) DeadLetters() ([]*OutboxEntry, error) {
+	return o.cfg.Store.List(OutboxDead)
+}
+
+// Retry 把死信重新放入发件箱, 重置尝试次数
+func (o *Outbox) Retry(key string) error {
+	e, err := o.Status(key)
+	if err != nil {
+		return err
+	}
+	if e.Status != OutboxDead {
+		return errors.Errorf("消息%s的状态为%s, 只能重试死信", key, e.Status)
+	}
+	now := o.now()
+	e.Status, e.Attempts, e.NextAt, e.UpdatedAt = OutboxPending, 0, now, now
+	return o.cfg.Store.Update(e)
+}
+
+// Delete 从发件箱删除消息, 可以用于清理死信
+func (o *Outbox) Delete(key string) error {
+	return o.cfg.Store.Delete(key)
+}
+
+// Flush 发送所有到期的消息并清理超过保留时间的已发送消息, 存储出错时返回错误
+func (o *Outbox) Flush(ctx context.Context) error {
+	o.flushMu.Lock()
+	defer o.flushMu.Unlock()
+
+	pending, err := o.cfg.Store.List(OutboxPending)
+	if err != nil {
+		return err
+	}
+	for _, e := range pending {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if o.now().Before(e.NextAt) {
+			continue
+		}
+		if err = o.send(e); err != nil {
+			return err
+		}
+	}
+
+	sent, err := o.cfg.Store.List(OutboxSent)
+	if err != nil {
+		return err
+	}
+	for _, e := range sent {
+		if o.now().Sub(e.UpdatedAt) > o.cfg.Retention {
+			if err = o.cfg.Store.Delete(e.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// send 发送一条消息并保存结果
+func (o *Outbox) send(e *OutboxEntry) error {
+	res, sendErr := o.agent.sendMsg(e.Msg, false, true)
+	now := o.now()
+	e.Attempts++
+	e.UpdatedAt = now
+	if sendErr == nil {
+		e.Status, e.LastError = OutboxSent, ""
+		if res != nil {
+			e.MsgID = res.MsgID
+		}
+		return o.cfg.Store.Update(e)
+	}
+
+	e.LastError = sendErr.Error()
+	if permanentSendError(sendErr) || e.Attempts >= o.cfg.Retry.MaxAttempts ||
+		(ambiguousSendError(sendErr) && e.Msg.EnableDuplicateCheck != 1) {
+		e.Status = OutboxDead
+		if err := o.cfg.Store.Update(e); err != nil {
+			return err
+		}
+		if o.cfg.OnDead != nil {
+			o.cfg.OnDead(e.clone())
+		}
+		return nil
+	}
+	e.NextAt = now.Add(o.cfg.Retry.delay(e.Attempts))
+	return o.cfg.Store.Update(e)
+}
+
+// permanentSendError 企业微信返回的不可重试的错误码, 例如参数错误或者接收人全部无效, 重试也不会成功
+func permanentSendError(err error) bool {
+	_, ok := errcode.Code(err)
+	return ok && errcode.Classify(err) == errcode.Fatal
+}
+
+// Start 启动后台发送, 每PollInterval或者有新消息时调用Flush, ctx结束或者调用Stop时退出
+func (o *Outbox) Start(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stop != nil {
+		return ErrOutboxStarted
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	o.stop, o.done = cancel, done
+	go o.run(ctx, done)
+	return nil
+}
+
+// Stop 停止后台发送并等待退出, 未发送的消息保留在存储中
+func (o *Outbox) Stop() {
+	o.mu.Lock()
+	cancel, done := o.stop, o.done
+	o.stop, o.done = nil, nil
+	o.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// run 后台发送的主循环
+func (o *Outbox) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(o.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		// 存储出错时等待下一次检查
+		o.Flush(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
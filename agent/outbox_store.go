@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp"
+)
+
+// OutboxStatus 发件箱中消息的状态
+type OutboxStatus string
+
+const (
+	// OutboxPending 等待发送或者等待重试
+	OutboxPending OutboxStatus = "pending"
+	// OutboxSent 发送成功
+	OutboxSent OutboxStatus = "sent"
+	// OutboxDead 超过重试次数或者出现不可恢复的错误, 不再发送
+	OutboxDead OutboxStatus = "dead"
+)
+
+// OutboxEntry 发件箱中的一条消息
+type OutboxEntry struct {
+	// Key 调用方提供的去重键
+	Key string `json:"key"`
+	// Msg 发送的消息
+	Msg *corp.Msg `json:"msg"`
+	// Status 消息状态
+	Status OutboxStatus `json:"status"`
+	// Attempts 已经尝试发送的次数
+	Attempts int `json:"attempts"`
+	// NextAt 下一次尝试发送的时间
+	NextAt time.Time `json:"next_at"`
+	// CreatedAt 进入发件箱的时间
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt 最后一次更新状态的时间
+	UpdatedAt time.Time `json:"updated_at"`
+	// LastError 最后一次发送失败的错误
+	LastError string `json:"last_error,omitempty"`
+	// MsgID 发送成功后的消息id, 用于撤回消息
+	MsgID string `json:"msgid,omitempty"`
+}
+
+// OutboxStore 发件箱的存储, 返回和保存的OutboxEntry不应与调用方共享
+type OutboxStore interface {
+	// Add 保存新消息, key已经存在时不保存, 返回已有的消息和false
+	Add(e *OutboxEntry) (existing *OutboxEntry, added bool, err error)
+	// Get 读取消息, 不存在时返回nil和nil错误
+	Get(key string) (*OutboxEntry, error)
+	// Update 更新已有的消息
+	Update(e *OutboxEntry) error
+	// Delete 删除消息, 不存在时忽略
+	Delete(key string) error
+	// List 按照进入发件箱的时间顺序列出状态为status的消息
+	List(status OutboxStatus) ([]*OutboxEntry, error)
+}
+
+// ErrOutboxNotFound 发件箱中没有这条消息
+var ErrOutboxNotFound = errors.New("发件箱中不存在该消息")
+
+// MemoryOutboxStore 内存中的发件箱存储, 进程退出后消息丢失, 适用于测试
+type MemoryOutboxStore struct {
+	mu      sync.Mutex
+	entries map[string]*OutboxEntry
+	// save 修改后的回调, FileOutboxStore用于写入文件
+	save func(entries map[string]*OutboxEntry) error
+}
+
+// NewMemoryOutboxStore 新建内存发件箱存储
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{entries: make(map[string]*OutboxEntry)}
+}
+
+// clone 复制消息状态和消息内容, 存储中的消息不与调用方共享
+func (e *OutboxEntry) clone() *OutboxEntry {
+	c := *e
+	if msg, err := copyMsg(e.Msg); err == nil {
+		c.Msg = msg
+	}
+	return &c
+}
+
+// copyMsg 通过JSON复制消息, 与保存到文件的内容一致
+func copyMsg(msg *corp.Msg) (*corp.Msg, error) {
+	if msg == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	c := new(corp.Msg)
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Add 保存新消息
+func (s *MemoryOutboxStore) Add(e *OutboxEntry) (*OutboxEntry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.entries[e.Key]; ok {
+		return old.clone(), false, nil
+	}
+	s.entries[e.Key] = e.clone()
+	if err := s.persist(); err != nil {
+		delete(s.entries, e.Key)
+		return nil, false, err
+	}
+	return nil, true, nil
+}
+
+// Get 读取消息
+func (s *MemoryOutboxStore) Get(key string) (*OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		return e.clone(), nil
+	}
+	return nil, nil
+}
+
+// Update 更新已有的消息
+func (s *MemoryOutboxStore) Update(e *OutboxEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.entries[e.Key]
+	if !ok {
+		return ErrOutboxNotFound
+	}
+	s.entries[e.Key] = e.clone()
+	if err := s.persist(); err != nil {
+		s.entries[e.Key] = old
+		return err
+	}
+	return nil
+}
+
+// Delete 删除消息
+func (s *MemoryOutboxStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	delete(s.entries, key)
+	if err := s.persist(); err != nil {
+		s.entries[key] = old
+		return err
+	}
+	return nil
+}
+
+// List 列出状态为status的消息
+func (s *MemoryOutboxStore) List(status OutboxStatus) ([]*OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []*OutboxEntry
+	for _, e := range s.entries {
+		if e.Status == status {
+			list = append(list, e.clone())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].Key < list[j].Key
+		}
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+// persist 调用save保存修改
+func (s *MemoryOutboxStore) persist() error {
+	if s.save == nil {
+		return nil
+	}
+	return s.save(s.entries)
+}
+
+// FileOutboxStore 以JSON文件保存的发件箱, 每次修改都会重写整个文件,
+// 适用于单个进程、消息量不大的场景
+type FileOutboxStore struct {
+	MemoryOutboxStore
+	path string
+}
+
+// NewFileOutboxStore 新建文件发件箱存储, 读取path中已有的消息
+func NewFileOutboxStore(path string) (*FileOutboxStore, error) {
+	s := &FileOutboxStore{
+		MemoryOutboxStore: MemoryOutboxStore{entries: make(map[string]*OutboxEntry)},
+		path:              path,
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err = json.Unmarshal(b, &s.entries); err != nil {
+			return nil, errors.Wrap(err, "发件箱文件格式错误")
+		}
+	}
+	s.save = s.write
+	return s, nil
+}
+
+// write 写入所有消息
+func (s *FileOutboxStore) write(entries map[string]*OutboxEntry) error {
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path, b)
+}
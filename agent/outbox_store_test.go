@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qingtao/wxcorp/corp"
+)
+
+func testOutboxStore(t *testing.T, s OutboxStore) {
+	now := time.Unix(1600000000, 0)
+	msg := &corp.Msg{ToUser: "u1", AgentID: 1, MsgType: "text", Text: &corp.TextMsg{Content: "abc"}}
+	for i, key := range []string{"b", "a", "c"} {
+		e := &OutboxEntry{Key: key, Msg: msg, Status: OutboxPending, CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		if _, added, err := s.Add(e); err != nil || !added {
+			t.Fatalf("Add(%s) = %v, %v", key, added, err)
+		}
+	}
+	existing, added, err := s.Add(&OutboxEntry{Key: "a", Status: OutboxDead})
+	if err != nil || added || existing.Status != OutboxPending {
+		t.Errorf("Add(duplicate) = %+v, %v, %v", existing, added, err)
+	}
+
+	e, err := s.Get("a")
+	if err != nil || e == nil || e.Msg.Text.Content != "abc" {
+		t.Fatalf("Get() = %+v, %v", e, err)
+	}
+	e.Status = OutboxSent
+	if err = s.Update(e); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err = s.Update(&OutboxEntry{Key: "none"}); err != ErrOutboxNotFound {
+		t.Errorf("Update() error = %v, want %v", err, ErrOutboxNotFound)
+	}
+	if e, err = s.Get("none"); e != nil || err != nil {
+		t.Errorf("Get(none) = %+v, %v", e, err)
+	}
+
+	list, err := s.List(OutboxPending)
+	if err != nil || len(list) != 2 || list[0].Key != "b" || list[1].Key != "c" {
+		t.Errorf("List(pending) = %v, %v", list, err)
+	}
+	// 修改返回的消息不影响存储
+	list[0].Status = OutboxDead
+	if list, _ = s.List(OutboxDead); len(list) != 0 {
+		t.Errorf("List(dead) = %v", list)
+	}
+	if err = s.Delete("b"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err = s.Delete("b"); err != nil {
+		t.Errorf("Delete() again error = %v", err)
+	}
+	if list, _ = s.List(OutboxPending); len(list) != 1 {
+		t.Errorf("List(pending) after Delete = %v", list)
+	}
+}
+
+func TestMemoryOutboxStore(t *testing.T) {
+	testOutboxStore(t, NewMemoryOutboxStore())
+}
+
+func TestFileOutboxStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	s, err := NewFileOutboxStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOutboxStore(t, s)
+
+	// 重新打开后读取已有的消息
+	s, err = NewFileOutboxStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := s.Get("a"); e == nil || e.Status != OutboxSent || e.Msg.Text.Content != "abc" {
+		t.Errorf("NewFileOutboxStore() a = %+v", e)
+	}
+	if list, _ := s.List(OutboxPending); len(list) != 1 || list[0].Key != "c" {
+		t.Errorf("NewFileOutboxStore() pending = %v", list)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFileOutboxStore(path); err == nil {
+		t.Error("NewFileOutboxStore() with invalid file, want error")
+	}
+}
@@ -0,0 +1,243 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qingtao/wxcorp/corp"
+)
+
+// newOutboxAgent 消息内容为"busy"时返回接口调用超过限制, 为"invalid"时返回接收人全部无效
+func newOutboxAgent(t *testing.T, busy *int32) *Agent {
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cgi-bin/gettoken" {
+			fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+			return
+		}
+		var msg corp.Msg
+		json.NewDecoder(r.Body).Decode(&msg)
+		switch {
+		case msg.Text.Content == "busy" && atomic.LoadInt32(busy) > 0:
+			fmt.Fprint(w, `{"errcode":45009,"errmsg":"api freq out of limit"}`)
+		case msg.Text.Content == "invalid":
+			fmt.Fprint(w, `{"errcode":81013,"errmsg":"user & party & tag all invalid"}`)
+		default:
+			fmt.Fprintf(w, `{"errcode":0,"errmsg":"ok","msgid":"msgid-%s"}`, msg.Text.Content)
+		}
+	})
+	a.Retry = &RetryPolicy{MaxAttempts: 1}
+	return a
+}
+
+func TestOutbox(t *testing.T) {
+	busy := int32(1)
+	now := time.Unix(1600000000, 0)
+	var dead []string
+	o := NewOutbox(newOutboxAgent(t, &busy), OutboxConfig{
+		Retry:  RetryPolicy{MaxAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Minute},
+		OnDead: func(e *OutboxEntry) { dead = append(dead, e.Key) },
+	})
+	o.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for key, content := range map[string]string{"ok": "hello", "busy": "busy", "invalid": "invalid"} {
+		if _, err := o.Enqueue(key, textMsg("u1", content)); err != nil {
+			t.Fatalf("Outbox.Enqueue(%s) error = %v", key, err)
+		}
+	}
+	if e, err := o.Enqueue("ok", textMsg("u1", "again")); err != ErrOutboxDuplicate || e.Msg.Text.Content != "hello" {
+		t.Errorf("Outbox.Enqueue(duplicate) = %+v, %v", e, err)
+	}
+	if _, err := o.Enqueue("", textMsg("u1", "a")); err == nil {
+		t.Error("Outbox.Enqueue() without key, want error")
+	}
+	if _, err := o.Enqueue("bad", &corp.Msg{}); err == nil {
+		t.Error("Outbox.Enqueue() with invalid msg, want error")
+	}
+
+	if err := o.Flush(ctx); err != nil {
+		t.Fatalf("Outbox.Flush() error = %v", err)
+	}
+	status := func(key string) *OutboxEntry {
+		t.Helper()
+		e, err := o.Status(key)
+		if err != nil {
+			t.Fatalf("Outbox.Status(%s) error = %v", key, err)
+		}
+		return e
+	}
+	if e := status("ok"); e.Status != OutboxSent || e.MsgID != "msgid-hello" || e.Attempts != 1 {
+		t.Errorf("Outbox.Status(ok) = %+v", e)
+	}
+	if e := status("invalid"); e.Status != OutboxDead || e.LastError == "" {
+		t.Errorf("Outbox.Status(invalid) = %+v", e)
+	}
+	e := status("busy")
+	if e.Status != OutboxPending || e.Attempts != 1 || !e.NextAt.After(now) {
+		t.Errorf("Outbox.Status(busy) = %+v", e)
+	}
+
+	// 未到重试时间
+	o.Flush(ctx)
+	if e = status("busy"); e.Attempts != 1 {
+		t.Errorf("Outbox.Flush() before NextAt, attempts = %d", e.Attempts)
+	}
+	now = now.Add(time.Minute)
+	o.Flush(ctx)
+	if e = status("busy"); e.Status != OutboxDead || e.Attempts != 2 {
+		t.Errorf("Outbox.Status(busy) = %+v, want dead", e)
+	}
+	letters, err := o.DeadLetters()
+	if err != nil || len(letters) != 2 || len(dead) != 2 {
+		t.Errorf("Outbox.DeadLetters() = %v, %v, OnDead = %v", letters, err, dead)
+	}
+
+	atomic.StoreInt32(&busy, 0)
+	if err = o.Retry("ok"); err == nil {
+		t.Error("Outbox.Retry(sent), want error")
+	}
+	if err = o.Retry("busy"); err != nil {
+		t.Fatalf("Outbox.Retry() error = %v", err)
+	}
+	if pending, _ := o.Pending(); len(pending) != 1 || pending[0].Key != "busy" {
+		t.Errorf("Outbox.Pending() = %v", pending)
+	}
+	o.Flush(ctx)
+	if e = status("busy"); e.Status != OutboxSent || e.Attempts != 1 {
+		t.Errorf("Outbox.Status(busy) after Retry = %+v", e)
+	}
+
+	if err = o.Delete("invalid"); err != nil {
+		t.Fatal(err)
+	}
+	// 超过保留时间后清理已发送的消息
+	now = now.Add(defaultOutboxRetention + time.Second)
+	o.Flush(ctx)
+	for _, key := range []string{"ok", "busy", "invalid"} {
+		if _, err = o.Status(key); err != ErrOutboxNotFound {
+			t.Errorf("Outbox.Status(%s) error = %v, want %v", key, err, ErrOutboxNotFound)
+		}
+	}
+}
+
+func TestOutbox_Start(t *testing.T) {
+	busy := int32(0)
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	store, err := NewFileOutboxStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 未启动时消息保存在文件中
+	if _, err = NewOutbox(newOutboxAgent(t, &busy), OutboxConfig{Store: store}).Enqueue("k1", textMsg("u1", "persisted")); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileOutboxStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := NewOutbox(newOutboxAgent(t, &busy), OutboxConfig{Store: store, PollInterval: 10 * time.Millisecond})
+	if err = o.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer o.Stop()
+	if err = o.Start(context.Background()); err != ErrOutboxStarted {
+		t.Errorf("Outbox.Start() again error = %v, want %v", err, ErrOutboxStarted)
+	}
+	o.Enqueue("k2", textMsg("u1", "new"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for _, key := range []string{"k1", "k2"} {
+		for {
+			e, err := o.Status(key)
+			if err == nil && e.Status == OutboxSent {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Outbox.Status(%s) = %+v, %v, want sent", key, e, err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	o.Stop()
+	o.Stop()
+}
+
+func TestOutbox_EnqueueCopy(t *testing.T) {
+	rec := &sendRecorder{}
+	o := NewOutbox(newTestAgent(t, rec.handler), OutboxConfig{})
+	msg := textMsg("u1", "original")
+	if _, err := o.Enqueue("k", msg); err != nil {
+		t.Fatal(err)
+	}
+	// 修改调用方和查询结果中的消息不影响发件箱
+	msg.ToUser, msg.Text.Content = "u2", "changed"
+	e, err := o.Status("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Msg.Text.Content = "changed"
+	if err = o.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.contents("u1"); len(got) != 1 || got[0] != "original" {
+		t.Errorf("u1 received %q, want [original]", got)
+	}
+	if got := rec.contents("u2"); len(got) != 0 {
+		t.Errorf("u2 received %q", got)
+	}
+}
+
+func TestOutbox_AmbiguousError(t *testing.T) {
+	var posts int32
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cgi-bin/gettoken" {
+			fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+			return
+		}
+		atomic.AddInt32(&posts, 1)
+		// 请求已经到达服务器, 但客户端没有收到响应
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+	// Agent.Retry不会在每次尝试中叠加
+	a.Retry = &RetryPolicy{MaxAttempts: 3}
+	now := time.Unix(1600000000, 0)
+	o := NewOutbox(a, OutboxConfig{Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute}})
+	o.now = func() time.Time { return now }
+
+	if _, err := o.Enqueue("plain", textMsg("u1", "plain")); err != nil {
+		t.Fatal(err)
+	}
+	o.Flush(context.Background())
+	now = now.Add(time.Hour)
+	o.Flush(context.Background())
+	if e, _ := o.Status("plain"); e == nil || e.Status != OutboxDead || e.Attempts != 1 {
+		t.Errorf("Outbox.Status(plain) = %+v, want dead", e)
+	}
+	if n := atomic.LoadInt32(&posts); n != 1 {
+		t.Errorf("message/send requested %d times, want 1", n)
+	}
+
+	// 设置了重复消息检查时按照重试策略重试, 每次尝试只请求一次
+	msg := textMsg("u1", "checked")
+	msg.EnableDuplicateCheck = 1
+	if _, err := o.Enqueue("checked", msg); err != nil {
+		t.Fatal(err)
+	}
+	o.Flush(context.Background())
+	if e, _ := o.Status("checked"); e == nil || e.Status != OutboxPending || e.Attempts != 1 {
+		t.Errorf("Outbox.Status(checked) = %+v, want pending", e)
+	}
+	if n := atomic.LoadInt32(&posts); n != 2 {
+		t.Errorf("message/send requested %d times, want 2", n)
+	}
+}
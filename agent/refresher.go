@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// backgroundRefreshBefore 后台提前刷新令牌的时间, 早于GetAccessToken的refreshBefore,
+	// 保证请求时令牌总是有效
+	backgroundRefreshBefore = 10 * time.Minute
+	// backgroundRefreshJitter 提前刷新时间的随机抖动上限, 避免多个实例同时刷新
+	backgroundRefreshJitter = 2 * time.Minute
+	// backgroundCheckInterval 后台检查令牌的最长间隔
+	backgroundCheckInterval = time.Minute
+	// backoffMin 刷新失败后第一次重试的等待时间
+	backoffMin = time.Second
+	// backoffMax 刷新失败后重试的最长等待时间
+	backoffMax = time.Minute
+)
+
+// ErrRefresherStarted 后台刷新已经启动
+var ErrRefresherStarted = errors.New("后台刷新令牌已经启动")
+
+// refreshState 后台刷新令牌的状态
+type refreshState struct {
+	// next 下次刷新的时间
+	next time.Time
+	// expiresAt 计算next时令牌的过期时间
+	expiresAt time.Time
+	// backoff 刷新失败后的等待时间, 成功后清零
+	backoff time.Duration
+}
+
+// Start 启动后台刷新, 在令牌过期前主动刷新访问令牌和已经使用过的jsapi_ticket,
+// IPListRefreshInterval大于0时定期刷新IP白名单,
+// 刷新失败时按指数退避重试并调用OnRefreshError. ctx结束或者调用Stop时退出
+func (a *Agent) Start(ctx context.Context) error {
+	a.Lock()
+	defer a.Unlock()
+	if a.stopRefresher != nil {
+		return ErrRefresherStarted
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	a.stopRefresher, a.refresherDone = cancel, done
+	go a.runRefresher(ctx, done)
+	return nil
+}
+
+// Stop 停止后台刷新并等待退出, 未启动时直接返回
+func (a *Agent) Stop() {
+	a.Lock()
+	cancel, done := a.stopRefresher, a.refresherDone
+	a.stopRefresher, a.refresherDone = nil, nil
+	a.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// runRefresher 后台刷新的主循环
+func (a *Agent) runRefresher(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	states := map[string]*refreshState{
+		keyAccessToken:      {},
+		keyJsAPITicket:      {},
+		keyAgentJsAPITicket: {},
+	}
+	if a.IPListRefreshInterval > 0 {
+		states[refreshIPList] = &refreshState{}
+	}
+	for {
+		now := time.Now()
+		wait := backgroundCheckInterval
+		for name, st := range states {
+			if st.backoff == 0 {
+				a.nextRefresh(name, st, now)
+			}
+			if d := st.next.Sub(now); d < wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+		now = time.Now()
+		for name, st := range states {
+			if st.next.After(now) {
+				continue
+			}
+			if err := a.backgroundRefresh(ctx, name); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				st.backoff = nextBackoff(st.backoff)
+				st.next = now.Add(withJitter(st.backoff))
+				if a.OnRefreshError != nil {
+					a.OnRefreshError(name, err)
+				}
+				continue
+			}
+			st.backoff = 0
+			a.rescheduleRefresh(name, st, time.Now())
+		}
+	}
+}
+
+// nextRefresh 计算令牌下次刷新的时间, 令牌的过期时间不变时保持不变; jsapi_ticket未使用过时不刷新;
+// IP白名单在上次更新后间隔IPListRefreshInterval刷新
+func (a *Agent) nextRefresh(name string, st *refreshState, now time.Time) {
+	if name == refreshIPList {
+		if _, updated := a.ipListState(); updated.IsZero() {
+			st.next = now
+		} else {
+			st.next = updated.Add(a.IPListRefreshInterval)
+		}
+		return
+	}
+	token, expiresAt, err := a.tokenStore().Get(a.storeKey(name))
+	switch {
+	case err != nil || token == "":
+		st.expiresAt = time.Time{}
+		if name == keyAccessToken {
+			st.next = now
+		} else {
+			st.next = now.Add(backgroundCheckInterval)
+		}
+	case !expiresAt.Equal(st.expiresAt):
+		jitter := time.Duration(rand.Int63n(int64(backgroundRefreshJitter)))
+		st.next, st.expiresAt = expiresAt.Add(-backgroundRefreshBefore-jitter), expiresAt
+	}
+}
+
+// rescheduleRefresh 刷新成功后计算令牌下次刷新的时间. 新令牌的有效期可能短于提前刷新的时间,
+// 此时按照refreshAt推迟, 避免反复刷新
+func (a *Agent) rescheduleRefresh(name string, st *refreshState, now time.Time) {
+	if name == refreshIPList {
+		return
+	}
+	token, expiresAt, err := a.tokenStore().Get(a.storeKey(name))
+	if err != nil || token == "" {
+		st.expiresAt = time.Time{}
+		return
+	}
+	jitter := time.Duration(rand.Int63n(int64(backgroundRefreshJitter)))
+	st.next, st.expiresAt = refreshAt(expiresAt, backgroundRefreshBefore+jitter, now), expiresAt
+}
+
+// refreshAt 计算在expiresAt之前提前before刷新的时间, 最多提前剩余有效期的一半, 且不早于now+backoffMin
+func refreshAt(expiresAt time.Time, before time.Duration, now time.Time) time.Time {
+	if half := expiresAt.Sub(now) / 2; before > half {
+		before = half
+	}
+	next := expiresAt.Add(-before)
+	if min := now.Add(backoffMin); next.Before(min) {
+		next = min
+	}
+	return next
+}
+
+// backgroundRefresh 刷新令牌, 如果其他实例已经刷新且未到提前刷新的时间则直接使用
+func (a *Agent) backgroundRefresh(ctx context.Context, name string) (err error) {
+	minTTL := backgroundRefreshBefore + backgroundRefreshJitter
+	switch name {
+	case keyAccessToken:
+		_, err = a.refreshAccessTokenFromServer(ctx, minTTL)
+	case keyAgentJsAPITicket:
+		_, err = a.refreshJsAPITicketFromServer(ctx, "agent_config", minTTL)
+	case refreshIPList:
+		err = a.setIPList(ctx)
+	default:
+		_, err = a.refreshJsAPITicketFromServer(ctx, "", minTTL)
+	}
+	return
+}
+
+// nextBackoff 计算下一次重试的等待时间, 每次翻倍, 不超过backoffMax
+func nextBackoff(d time.Duration) time.Duration {
+	if d < backoffMin {
+		return backoffMin
+	}
+	if d *= 2; d > backoffMax {
+		d = backoffMax
+	}
+	return d
+}
+
+// withJitter 返回[d/2, d]之间的随机时间
+func withJitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
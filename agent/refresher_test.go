@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNextBackoff(t *testing.T) {
+	var d time.Duration
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if d = nextBackoff(d); d != want {
+			t.Errorf("nextBackoff() = %v, want %v", d, want)
+		}
+	}
+	if d = nextBackoff(backoffMax); d != backoffMax {
+		t.Errorf("nextBackoff() = %v, want %v", d, backoffMax)
+	}
+	for i := 0; i < 10; i++ {
+		if j := withJitter(d); j < d/2 || j > d {
+			t.Errorf("withJitter(%v) = %v", d, j)
+		}
+	}
+}
+
+func TestAgent_Start(t *testing.T) {
+	var n int32
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cgi-bin/gettoken":
+			fmt.Fprintf(w, `{"access_token":"token%d","expires_in":7200}`, atomic.AddInt32(&n, 1))
+		default:
+			fmt.Fprint(w, `{"ticket":"ticket","expires_in":7200}`)
+		}
+	})
+	// 即将过期的令牌需要立即刷新
+	a.tokenStore().Set(a.storeKey(keyAccessToken), "old", time.Now().Add(time.Minute))
+	if err := a.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Start(context.Background()); err != ErrRefresherStarted {
+		t.Errorf("Agent.Start() error = %v, want %v", err, ErrRefresherStarted)
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&n) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.Stop()
+	a.Stop()
+	token, ok, err := a.loadToken(keyAccessToken, backgroundRefreshBefore)
+	if err != nil || !ok || token != "token1" {
+		t.Errorf("token after background refresh = %v, %v, %v", token, ok, err)
+	}
+	if n != 1 {
+		t.Errorf("gettoken requested %d times, want 1", n)
+	}
+}
+
+func TestRefreshAt(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		want      time.Time
+	}{
+		{"long", now.Add(2 * time.Hour), now.Add(2*time.Hour - backgroundRefreshBefore)},
+		{"short", now.Add(10 * time.Minute), now.Add(5 * time.Minute)},
+		{"expired", now.Add(-time.Minute), now.Add(backoffMin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refreshAt(tt.expiresAt, backgroundRefreshBefore, now); !got.Equal(tt.want) {
+				t.Errorf("refreshAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgent_StartShortTTL(t *testing.T) {
+	var n int32
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cgi-bin/gettoken":
+			// 有效期短于backgroundRefreshBefore
+			fmt.Fprintf(w, `{"access_token":"token%d","expires_in":600}`, atomic.AddInt32(&n, 1))
+		default:
+			fmt.Fprint(w, `{"ticket":"ticket","expires_in":600}`)
+		}
+	})
+	if err := a.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	a.Stop()
+	if n := atomic.LoadInt32(&n); n != 1 {
+		t.Errorf("gettoken requested %d times, want 1", n)
+	}
+}
+
+func TestAgent_StartError(t *testing.T) {
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errcode":-1,"errmsg":"system busy"}`)
+	})
+	errc := make(chan error, 1)
+	a.OnRefreshError = func(name string, err error) {
+		if name != keyAccessToken {
+			t.Errorf("OnRefreshError() name = %v", name)
+		}
+		select {
+		case errc <- err:
+		default:
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := a.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Errorf("OnRefreshError() error = nil")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("OnRefreshError() not called")
+	}
+	// ctx结束后Stop立即返回
+	cancel()
+	a.Stop()
+}
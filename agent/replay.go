@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultMaxSkew 回调请求的时间戳与当前时间的默认最大偏差
+const defaultMaxSkew = 5 * time.Minute
+
+var (
+	// ErrTimestampExpired 回调请求的时间戳超出允许的偏差
+	ErrTimestampExpired = errors.New("回调请求已过期")
+	// ErrNonceReplayed 回调请求的时间戳和随机数已经使用过
+	ErrNonceReplayed = errors.New("回调请求重复")
+)
+
+// ReplayError 过期或者重放的回调请求, 可以使用errors.Is和ErrTimestampExpired、ErrNonceReplayed比较
+type ReplayError struct {
+	// Err ErrTimestampExpired或者ErrNonceReplayed
+	Err error
+	// Timestamp 请求的时间戳
+	Timestamp string
+	// Nonce 请求的随机数
+	Nonce string
+}
+
+// Error 实现error接口
+func (e *ReplayError) Error() string {
+	return e.Err.Error() + ": timestamp=" + e.Timestamp + ", nonce=" + e.Nonce
+}
+
+// Unwrap 返回ErrTimestampExpired或者ErrNonceReplayed
+func (e *ReplayError) Unwrap() error {
+	return e.Err
+}
+
+// ReplayGuard 回调请求的防重放校验, 在签名校验通过后检查时间戳是否在允许的偏差内, 以及时间戳和随机数是否使用过.
+// 企业微信在回调失败或者超时后会使用相同的时间戳和随机数重试, 处理失败时需要调用Forget删除随机数的记录,
+// CallbackHandler会自动处理; 直接使用Agent.ReceiveMsg时由调用方处理.
+// CallbackHandler收到随机数重复但是已经在排重集合中的消息时返回200, 避免企业微信继续重试
+type ReplayGuard struct {
+	// MaxSkew 时间戳与当前时间的最大偏差, 小于等于0时使用默认值5分钟
+	MaxSkew time.Duration
+	// Nonces 已经使用的随机数, 为nil时只校验时间戳
+	Nonces SeenSet
+	// Now 返回当前时间, 为nil时使用time.Now
+	Now func() time.Time
+}
+
+// NewReplayGuard 新建防重放校验, 使用内存记录随机数, maxSkew小于等于0时使用默认值5分钟
+func NewReplayGuard(maxSkew time.Duration) *ReplayGuard {
+	return &ReplayGuard{MaxSkew: maxSkew, Nonces: NewMemorySeenSet(0)}
+}
+
+// Check 校验时间戳和随机数, 过期或者重复时返回*ReplayError
+func (g *ReplayGuard) Check(timestamp, nonce string) error {
+	skew := g.MaxSkew
+	if skew <= 0 {
+		skew = defaultMaxSkew
+	}
+	now := time.Now
+	if g.Now != nil {
+		now = g.Now
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return &ReplayError{Err: ErrTimestampExpired, Timestamp: timestamp, Nonce: nonce}
+	}
+	if d := now().Sub(time.Unix(ts, 0)); d > skew || d < -skew {
+		return &ReplayError{Err: ErrTimestampExpired, Timestamp: timestamp, Nonce: nonce}
+	}
+	if g.Nonces == nil {
+		return nil
+	}
+	// 超过2倍偏差后时间戳校验不会通过, 不需要继续记录
+	added, err := g.Nonces.Add(nonceKey(timestamp, nonce), 2*skew)
+	if err != nil {
+		return errors.WithMessage(err, "记录随机数失败")
+	}
+	if !added {
+		return &ReplayError{Err: ErrNonceReplayed, Timestamp: timestamp, Nonce: nonce}
+	}
+	return nil
+}
+
+// Forget 删除随机数的记录, 回调处理失败后调用, 以便企业微信使用相同的时间戳和随机数重试时可以通过校验
+func (g *ReplayGuard) Forget(timestamp, nonce string) error {
+	if g.Nonces == nil {
+		return nil
+	}
+	return g.Nonces.Remove(nonceKey(timestamp, nonce))
+}
+
+// nonceKey 随机数在SeenSet中的key
+func nonceKey(timestamp, nonce string) string {
+	return "nonce/" + timestamp + "/" + nonce
+}
+
+// checkReplay 使用Agent.Replay校验回调请求, 未设置时不校验
+func (a *Agent) checkReplay(timestamp, nonce string) error {
+	if a.Replay == nil {
+		return nil
+	}
+	return a.Replay.Check(timestamp, nonce)
+}
+
+// forgetReplay 回调处理失败后删除随机数的记录, 未设置Agent.Replay时忽略
+func (a *Agent) forgetReplay(timestamp, nonce string) error {
+	if a.Replay == nil {
+		return nil
+	}
+	return a.Replay.Forget(timestamp, nonce)
+}
@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestReplayGuard_Check(t *testing.T) {
+	now := time.Unix(1409659813, 0)
+	g := NewReplayGuard(time.Minute)
+	g.Now = func() time.Time { return now }
+	ts := func(d time.Duration) string {
+		return strconv.FormatInt(now.Add(d).Unix(), 10)
+	}
+
+	tests := []struct {
+		name      string
+		timestamp string
+		nonce     string
+		wantErr   error
+	}{
+		{name: "ok", timestamp: ts(0), nonce: "1"},
+		{name: "replayed", timestamp: ts(0), nonce: "1", wantErr: ErrNonceReplayed},
+		{name: "other nonce", timestamp: ts(0), nonce: "2"},
+		{name: "skew", timestamp: ts(-59 * time.Second), nonce: "1"},
+		{name: "expired", timestamp: ts(-2 * time.Minute), nonce: "3", wantErr: ErrTimestampExpired},
+		{name: "future", timestamp: ts(2 * time.Minute), nonce: "3", wantErr: ErrTimestampExpired},
+		{name: "invalid", timestamp: "abc", nonce: "3", wantErr: ErrTimestampExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := g.Check(tt.timestamp, tt.nonce)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("ReplayGuard.Check() error = %v, want %v", err, tt.wantErr)
+			}
+			var replayErr *ReplayError
+			if err != nil && (!errors.As(err, &replayErr) || replayErr.Nonce != tt.nonce) {
+				t.Errorf("ReplayGuard.Check() error = %#v, want *ReplayError", err)
+			}
+		})
+	}
+
+	// 只校验时间戳
+	g = &ReplayGuard{Now: g.Now}
+	if err := g.Check(ts(0), "1"); err != nil {
+		t.Errorf("ReplayGuard.Check() error = %v", err)
+	}
+	if err := g.Check(ts(0), "1"); err != nil {
+		t.Errorf("ReplayGuard.Check() without nonces error = %v", err)
+	}
+	if err := g.Check(ts(-10*time.Minute), "1"); !errors.Is(err, ErrTimestampExpired) {
+		t.Errorf("ReplayGuard.Check() error = %v, want %v", err, ErrTimestampExpired)
+	}
+}
+
+func TestCallbackHandler_Replay(t *testing.T) {
+	a := newCallbackAgent()
+	// encryptForTest使用的时间戳为1409659813
+	now := time.Unix(1409659813, 0)
+	a.Replay = NewReplayGuard(0)
+	a.Replay.Now = func() time.Time { return now }
+	m := encryptForTest(t, a, "6467373778033604605")
+	h := NewCallbackHandler(a, nil)
+
+	for i, want := range []int{http.StatusOK, http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, callbackQuery(m, true), nil))
+		if w.Code != want {
+			t.Errorf("ServeHTTP() #%d code = %d, want %d", i, w.Code, want)
+		}
+	}
+
+	now = now.Add(time.Hour)
+	m = encryptForTest(t, a, "6467373778033604605")
+	m.Nonce = "other"
+	m.MsgSignature = mustCrypter(t, a).Signature(m.TimeStamp, m.Nonce, m.Encrypt)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, callbackQuery(m, true), nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("ServeHTTP() expired code = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if _, err := a.verifyURL(m.MsgSignature, m.TimeStamp, m.Nonce, m.Encrypt); !errors.Is(err, ErrTimestampExpired) {
+		t.Errorf("verifyURL() error = %v, want %v", err, ErrTimestampExpired)
+	}
+}
+
+func TestCallbackHandler_ReplayRetry(t *testing.T) {
+	a := newCallbackAgent()
+	now := time.Unix(1409659813, 0)
+	a.Replay = NewReplayGuard(0)
+	a.Replay.Now = func() time.Time { return now }
+	raw := `<xml><ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName><FromUserName><![CDATA[zhangsan]]></FromUserName><CreateTime>1409659813</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[hello]]></Content><MsgId>1</MsgId><AgentID>1</AgentID></xml>`
+	m := encryptForTest(t, a, raw)
+	body := fmt.Sprintf("<xml><Encrypt><![CDATA[%s]]></Encrypt></xml>", m.Encrypt)
+
+	calls := 0
+	h := NewCallbackHandler(a, HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("handler")
+		}
+		return nil, nil
+	}))
+	// 处理失败后企业微信使用相同的时间戳和随机数重试, 处理成功后再次重试按照重复消息返回200
+	for i, want := range []int{http.StatusInternalServerError, http.StatusOK, http.StatusOK} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, callbackQuery(m, false), strings.NewReader(body)))
+		if w.Code != want {
+			t.Errorf("ServeHTTP() #%d code = %d, want %d", i, w.Code, want)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler calls = %d, want 2", calls)
+	}
+
+	// 使用相同的时间戳和随机数发送未处理过的消息返回403
+	other := encryptForTest(t, a, strings.Replace(raw, "<MsgId>1</MsgId>", "<MsgId>2</MsgId>", 1))
+	body = fmt.Sprintf("<xml><Encrypt><![CDATA[%s]]></Encrypt></xml>", other.Encrypt)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, callbackQuery(other, false), strings.NewReader(body)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("ServeHTTP() replayed code = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if calls != 2 {
+		t.Errorf("handler calls = %d, want 2", calls)
+	}
+}
+
+func TestCallbackHandler_SeenSetFull(t *testing.T) {
+	a := newCallbackAgent()
+	now := time.Unix(1409659813, 0)
+	a.Replay = &ReplayGuard{Nonces: NewMemorySeenSet(1), Now: func() time.Time { return now }}
+	a.Replay.Nonces.Add("other", time.Hour)
+	raw := `<xml><ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName><FromUserName><![CDATA[zhangsan]]></FromUserName><CreateTime>1409659813</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[hello]]></Content><MsgId>1</MsgId><AgentID>1</AgentID></xml>`
+	m := encryptForTest(t, a, raw)
+	body := fmt.Sprintf("<xml><Encrypt><![CDATA[%s]]></Encrypt></xml>", m.Encrypt)
+	h := NewCallbackHandler(a, HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+		return nil, nil
+	}))
+	// 随机数集合已满时不淘汰未过期的记录, 返回503以便企业微信稍后重试
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, callbackQuery(m, false), strings.NewReader(body)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("ServeHTTP() code = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	// 排重集合已满时同样返回503, 并删除随机数的记录
+	a.Replay.Nonces = NewMemorySeenSet(0)
+	seen := NewMemorySeenSet(1)
+	seen.Add("other", time.Hour)
+	h = NewCallbackHandler(a, HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+		return nil, nil
+	}), WithSeenSet(seen))
+	for i, want := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, callbackQuery(m, false), strings.NewReader(body)))
+		if w.Code != want {
+			t.Errorf("ServeHTTP() #%d code = %d, want %d", i, w.Code, want)
+		}
+	}
+}
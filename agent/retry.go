@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/qingtao/wxcorp/corp/errcode"
+)
+
+// RetryPolicy 调用企业微信接口的重试策略, 错误的分类参考errcode.Classify.
+// 发送消息不是幂等的, 网络错误或者系统繁忙时消息可能已经送达, 因此SendMsg只在令牌错误和频率限制时重试,
+// 消息设置了EnableDuplicateCheck时企业微信会丢弃重复的消息, 才按照重试策略重试所有临时错误
+type RetryPolicy struct {
+	// MaxAttempts 最多尝试的次数, 包括第一次请求
+	MaxAttempts int
+	// BaseDelay 第一次重试前的等待时间, 之后每次翻倍
+	BaseDelay time.Duration
+	// MaxDelay 重试前等待的最长时间
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy 默认的重试策略, 企业微信建议系统繁忙时重试次数不超过3次
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// delay 第attempt次请求失败后的等待时间, 指数增长并加入随机抖动
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryPolicy 返回应用使用的重试策略
+func (a *Agent) retryPolicy() RetryPolicy {
+	if a.Retry != nil {
+		return *a.Retry
+	}
+	return DefaultRetryPolicy
+}
+
+// do 使用访问令牌调用fn, 令牌错误时刷新令牌后重试, 临时错误按重试策略等待后重试
+func (a *Agent) do(ctx context.Context, fn func(accessToken string) error) error {
+	return a.doWith(ctx, errcode.Classify, fn)
+}
+
+// doSend 调用发送消息等非幂等的接口, duplicateCheck为false时不重试结果不确定的错误
+func (a *Agent) doSend(ctx context.Context, duplicateCheck bool, fn func(accessToken string) error) error {
+	if duplicateCheck {
+		return a.do(ctx, fn)
+	}
+	return a.doWith(ctx, classifySend, fn)
+}
+
+// doOnce 只在令牌错误时刷新令牌后重试, 此时请求已经被拒绝; 其他错误直接返回, 由调用方决定是否重试
+func (a *Agent) doOnce(ctx context.Context, fn func(accessToken string) error) error {
+	return a.doWith(ctx, classifyRefreshToken, fn)
+}
+
+// classifyRefreshToken 只保留令牌错误的重试
+func classifyRefreshToken(err error) errcode.Action {
+	if action := errcode.Classify(err); action == errcode.RefreshToken {
+		return action
+	}
+	return errcode.Fatal
+}
+
+// ambiguousSendError 网络错误、服务器错误和系统繁忙时无法确定消息是否已经送达
+func ambiguousSendError(err error) bool {
+	return errcode.Classify(err) == errcode.Retry && classifySend(err) == errcode.Fatal
+}
+
+// classifySend 非幂等接口的错误分类, 网络错误和系统繁忙时请求可能已经处理, 不再重试;
+// 令牌错误和频率限制说明请求被拒绝, 可以重试
+func classifySend(err error) errcode.Action {
+	action := errcode.Classify(err)
+	if action != errcode.Retry {
+		return action
+	}
+	if code, ok := errcode.Code(err); ok && code != -1 {
+		return errcode.Retry
+	}
+	return errcode.Fatal
+}
+
+// doWith 使用classify判断错误的处理方式并重试
+func (a *Agent) doWith(ctx context.Context, classify func(error) errcode.Action, fn func(accessToken string) error) error {
+	policy := a.retryPolicy()
+	accessToken, err := a.GetAccessToken()
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = fn(accessToken)
+		if err == nil || attempt >= policy.MaxAttempts {
+			return err
+		}
+		switch classify(err) {
+		case errcode.RefreshToken:
+			if accessToken, err = a.RefreshAccessToken(); err != nil {
+				return err
+			}
+		case errcode.Retry:
+			timer := time.NewTimer(policy.delay(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		default:
+			return err
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp/errcode"
+)
+
+func TestRetryPolicy_delay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{attempt: 1, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{attempt: 2, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{attempt: 5, min: 150 * time.Millisecond, max: 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if d := p.delay(tt.attempt); d < tt.min || d > tt.max {
+			t.Errorf("RetryPolicy.delay(%d) = %v, want [%v, %v]", tt.attempt, d, tt.min, tt.max)
+		}
+	}
+	if d := (RetryPolicy{}).delay(1); d != 0 {
+		t.Errorf("RetryPolicy.delay() = %v, want 0", d)
+	}
+}
+
+func TestAgent_do(t *testing.T) {
+	tokens := 0
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		tokens++
+		fmt.Fprintf(w, `{"access_token":"token%d","expires_in":7200}`, tokens)
+	})
+	a.Retry = &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "ok", errs: []error{nil}, wantCalls: 1},
+		{name: "busy", errs: []error{errcode.New(-1, "system busy"), nil}, wantCalls: 2},
+		{name: "expired", errs: []error{errcode.New(42001, "access_token expired"), nil}, wantCalls: 2},
+		{name: "fatal", errs: []error{errcode.New(60011, "no privilege")}, wantCalls: 1, wantErr: errcode.ErrNoPrivilege},
+		{name: "exhausted", errs: []error{errcode.ErrFrequencyLimit, errcode.ErrFrequencyLimit, errcode.ErrFrequencyLimit}, wantCalls: 3, wantErr: errcode.ErrFrequencyLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			err := a.do(context.Background(), func(accessToken string) error {
+				calls = append(calls, accessToken)
+				return tt.errs[len(calls)-1]
+			})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Agent.do() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(calls) != tt.wantCalls {
+				t.Errorf("Agent.do() calls = %v, want %d", calls, tt.wantCalls)
+			}
+			if tt.name == "expired" && calls[0] == calls[1] {
+				t.Errorf("Agent.do() did not refresh token: %v", calls)
+			}
+		})
+	}
+}
+
+func TestAgent_doSend(t *testing.T) {
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+	})
+	a.Retry = &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	tests := []struct {
+		name           string
+		err            error
+		duplicateCheck bool
+		wantCalls      int
+	}{
+		{name: "busy", err: errcode.New(-1, "system busy"), wantCalls: 1},
+		{name: "network", err: &net.OpError{Op: "read", Err: errors.New("connection reset")}, wantCalls: 1},
+		{name: "frequency limit", err: errcode.ErrFrequencyLimit, wantCalls: 2},
+		{name: "expired", err: errcode.New(42001, "access_token expired"), wantCalls: 2},
+		{name: "busy with duplicate check", err: errcode.New(-1, "system busy"), duplicateCheck: true, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			a.doSend(context.Background(), tt.duplicateCheck, func(accessToken string) error {
+				calls++
+				if calls == 1 {
+					return tt.err
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("Agent.doSend() calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp"
+)
+
+// Middleware 处理器中间件, 可以在调用next前后执行日志、鉴权等逻辑
+type Middleware func(next Handler) Handler
+
+// routeKey 路由的键, 事件名称不区分大小写
+type routeKey struct {
+	msgType string
+	event   string
+	key     string
+}
+
+// Router 按消息类型、事件名称和事件KEY分发回调消息的Handler, 每种消息解析为对应的类型.
+// 匹配顺序为: 类型+事件+KEY, 类型+事件, Fallback. 注册处理器应在开始处理消息前完成
+type Router struct {
+	routes      map[routeKey]Handler
+	middlewares []Middleware
+	fallback    Handler
+}
+
+// NewRouter 新建路由
+func NewRouter() *Router {
+	return &Router{routes: make(map[routeKey]Handler)}
+}
+
+// decodeAs 把处理T类型消息的函数转换为Handler
+func decodeAs[T any](fn func(ctx context.Context, msg *T) ([]byte, error)) Handler {
+	return HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+		v := new(T)
+		if err := msg.Decode(v); err != nil {
+			return nil, errors.Wrap(err, "回调消息格式错误")
+		}
+		return fn(ctx, v)
+	})
+}
+
+// Use 添加中间件, 先添加的中间件先执行
+func (r *Router) Use(mws ...Middleware) {
+	r.middlewares = append(r.middlewares, mws...)
+}
+
+// Fallback 设置没有匹配的处理器时使用的处理器, 未设置时不回复
+func (r *Router) Fallback(h Handler) {
+	r.fallback = h
+}
+
+// Handle 注册处理msgType类型消息的通用处理器, 消息为事件时event为事件名称, key为空时匹配所有KEY
+func (r *Router) Handle(msgType, event, key string, h Handler) {
+	r.routes[routeKey{msgType: msgType, event: strings.ToLower(event), key: key}] = h
+}
+
+// OnText 处理文本消息
+func (r *Router) OnText(fn func(ctx context.Context, msg *corp.TextMessage) ([]byte, error)) {
+	r.Handle("text", "", "", decodeAs(fn))
+}
+
+// OnImage 处理图片消息
+func (r *Router) OnImage(fn func(ctx context.Context, msg *corp.ImageMessage) ([]byte, error)) {
+	r.Handle("image", "", "", decodeAs(fn))
+}
+
+// OnVoice 处理语音消息
+func (r *Router) OnVoice(fn func(ctx context.Context, msg *corp.VoiceMessage) ([]byte, error)) {
+	r.Handle("voice", "", "", decodeAs(fn))
+}
+
+// OnVideo 处理视频消息
+func (r *Router) OnVideo(fn func(ctx context.Context, msg *corp.VideoMessage) ([]byte, error)) {
+	r.Handle("video", "", "", decodeAs(fn))
+}
+
+// OnLocation 处理位置消息
+func (r *Router) OnLocation(fn func(ctx context.Context, msg *corp.LocationMessage) ([]byte, error)) {
+	r.Handle("location", "", "", decodeAs(fn))
+}
+
+// OnLink 处理链接消息
+func (r *Router) OnLink(fn func(ctx context.Context, msg *corp.LinkMessage) ([]byte, error)) {
+	r.Handle("link", "", "", decodeAs(fn))
+}
+
+// OnEvent 处理事件, 例如OnEvent("click", "menu_1", fn), key为空时匹配所有KEY
+func (r *Router) OnEvent(event, key string, fn func(ctx context.Context, msg *corp.EventMessage) ([]byte, error)) {
+	r.Handle("event", event, key, decodeAs(fn))
+}
+
+// OnLocationEvent 处理上报地理位置事件
+func (r *Router) OnLocationEvent(fn func(ctx context.Context, msg *corp.LocationEvent) ([]byte, error)) {
+	r.Handle("event", "LOCATION", "", decodeAs(fn))
+}
+
+// OnScanCode 处理扫码事件, event为scancode_push或scancode_waitmsg, key为空时匹配所有KEY
+func (r *Router) OnScanCode(event, key string, fn func(ctx context.Context, msg *corp.ScanCodeEvent) ([]byte, error)) {
+	r.Handle("event", event, key, decodeAs(fn))
+}
+
+// OnSendPics 处理发图事件, event为pic_sysphoto、pic_photo_or_album或pic_weixin, key为空时匹配所有KEY
+func (r *Router) OnSendPics(event, key string, fn func(ctx context.Context, msg *corp.SendPicsEvent) ([]byte, error)) {
+	r.Handle("event", event, key, decodeAs(fn))
+}
+
+// OnLocationSelect 处理弹出地理位置选择器事件, key为空时匹配所有KEY
+func (r *Router) OnLocationSelect(key string, fn func(ctx context.Context, msg *corp.LocationSelectEvent) ([]byte, error)) {
+	r.Handle("event", "location_select", key, decodeAs(fn))
+}
+
+// OnContactChange 处理通讯录变更事件, changeType例如create_user、update_party、update_tag, 为空时匹配所有变更
+func (r *Router) OnContactChange(changeType string, fn func(ctx context.Context, msg *corp.ContactEvent) ([]byte, error)) {
+	r.Handle("event", "change_contact", changeType, HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+		if msg.Contact == nil {
+			return nil, errors.New("通讯录变更事件为空")
+		}
+		return fn(ctx, msg.Contact)
+	}))
+}
+
+// OnBatchJobResult 处理异步任务完成事件
+func (r *Router) OnBatchJobResult(fn func(ctx context.Context, msg *corp.BatchJobResultEvent) ([]byte, error)) {
+	r.Handle("event", "batch_job_result", "", decodeAs(fn))
+}
+
+// match 查找处理器
+func (r *Router) match(msg *Message) Handler {
+	k := routeKey{msgType: msg.MsgType}
+	if msg.IsEvent() {
+		k.event = strings.ToLower(msg.Event)
+		k.key = msg.EventKey
+		if msg.Contact != nil {
+			k.key = msg.Contact.ChangeType
+		}
+	}
+	if h, ok := r.routes[k]; ok {
+		return h
+	}
+	k.key = ""
+	if h, ok := r.routes[k]; ok {
+		return h
+	}
+	return r.fallback
+}
+
+// ServeMessage 实现Handler, 依次执行中间件后调用匹配的处理器
+func (r *Router) ServeMessage(ctx context.Context, msg *Message) ([]byte, error) {
+	var h Handler = HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+		if h := r.match(msg); h != nil {
+			return h.ServeMessage(ctx, msg)
+		}
+		return nil, nil
+	})
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		h = r.middlewares[i](h)
+	}
+	return h.ServeMessage(ctx, msg)
+}
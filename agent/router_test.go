@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/qingtao/wxcorp/corp"
+	"github.com/qingtao/wxcorp/corp/crypto"
+)
+
+func TestRouter_ServeMessage(t *testing.T) {
+	const head = `<ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName><FromUserName><![CDATA[zhangsan]]></FromUserName><CreateTime>1348831860</CreateTime><AgentID>1</AgentID>`
+	event := func(name, key string) string {
+		return fmt.Sprintf(`<xml>%s<MsgType><![CDATA[event]]></MsgType><Event><![CDATA[%s]]></Event><EventKey><![CDATA[%s]]></EventKey></xml>`, head, name, key)
+	}
+
+	var trace []string
+	r := NewRouter()
+	r.Use(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+			trace = append(trace, "first")
+			return next.ServeMessage(ctx, msg)
+		})
+	}, func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+			trace = append(trace, "second")
+			return next.ServeMessage(ctx, msg)
+		})
+	})
+	r.OnText(func(ctx context.Context, msg *corp.TextMessage) ([]byte, error) {
+		return []byte("text:" + msg.Content), nil
+	})
+	r.OnEvent("click", "menu_1", func(ctx context.Context, msg *corp.EventMessage) ([]byte, error) {
+		return []byte("click:menu_1"), nil
+	})
+	r.OnEvent("click", "", func(ctx context.Context, msg *corp.EventMessage) ([]byte, error) {
+		return []byte("click:" + msg.EventKey), nil
+	})
+	r.OnLocationEvent(func(ctx context.Context, msg *corp.LocationEvent) ([]byte, error) {
+		return []byte(fmt.Sprintf("location:%v", msg.Latitude)), nil
+	})
+	r.OnScanCode("scancode_push", "", func(ctx context.Context, msg *corp.ScanCodeEvent) ([]byte, error) {
+		return []byte("scancode:" + msg.ScanCodeInfo.ScanResult), nil
+	})
+	r.OnContactChange("create_user", func(ctx context.Context, msg *corp.ContactEvent) ([]byte, error) {
+		return []byte("create_user:" + msg.UserID), nil
+	})
+	r.OnContactChange("", func(ctx context.Context, msg *corp.ContactEvent) ([]byte, error) {
+		return []byte("contact:" + msg.ChangeType), nil
+	})
+	r.OnBatchJobResult(func(ctx context.Context, msg *corp.BatchJobResultEvent) ([]byte, error) {
+		return []byte("batch:" + msg.BatchJob.JobType), nil
+	})
+
+	tests := []struct {
+		name     string
+		raw      string
+		fallback bool
+		want     string
+	}{
+		{name: "text", raw: `<xml>` + head + `<MsgType><![CDATA[text]]></MsgType><Content><![CDATA[hello]]></Content></xml>`, want: "text:hello"},
+		{name: "click key", raw: event("click", "menu_1"), want: "click:menu_1"},
+		{name: "click any", raw: event("click", "menu_2"), want: "click:menu_2"},
+		{name: "location", raw: strings.Replace(event("LOCATION", ""), "</xml>", "<Latitude>23.5</Latitude></xml>", 1), want: "location:23.5"},
+		{name: "scancode", raw: strings.Replace(event("scancode_push", "k"), "</xml>", "<ScanCodeInfo><ScanResult>abc</ScanResult></ScanCodeInfo></xml>", 1), want: "scancode:abc"},
+		{name: "create_user", raw: strings.Replace(event("change_contact", ""), "</xml>", "<ChangeType>create_user</ChangeType><UserID>lisi</UserID></xml>", 1), want: "create_user:lisi"},
+		{name: "update_party", raw: strings.Replace(event("change_contact", ""), "</xml>", "<ChangeType>update_party</ChangeType></xml>", 1), want: "contact:update_party"},
+		{name: "batch", raw: strings.Replace(event("batch_job_result", ""), "</xml>", "<BatchJob><JobType>sync_user</JobType></BatchJob></xml>", 1), want: "batch:sync_user"},
+		{name: "unmatched", raw: event("view", "http://a.com"), want: ""},
+		{name: "fallback", raw: event("view", "http://a.com"), fallback: true, want: "fallback:view"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.Fallback(nil)
+			if tt.fallback {
+				r.Fallback(HandlerFunc(func(ctx context.Context, msg *Message) ([]byte, error) {
+					return []byte("fallback:" + msg.Event), nil
+				}))
+			}
+			trace = nil
+			msg, err := decodeMessage([]byte(tt.raw), crypto.XML)
+			if err != nil {
+				t.Fatalf("decodeMessage() error = %v", err)
+			}
+			got, err := r.ServeMessage(context.Background(), msg)
+			if err != nil {
+				t.Fatalf("Router.ServeMessage() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Router.ServeMessage() = %q, want %q", got, tt.want)
+			}
+			if strings.Join(trace, ",") != "first,second" {
+				t.Errorf("middlewares = %v, want [first second]", trace)
+			}
+		})
+	}
+}
+
+func TestRouter_JSON(t *testing.T) {
+	r := NewRouter()
+	r.OnLocation(func(ctx context.Context, msg *corp.LocationMessage) ([]byte, error) {
+		return corp.MarshalReplyJSON(corp.NewTextReply(msg.FromUserName, msg.ToUserName, fmt.Sprintf("%v,%v:%s", msg.LocationX, msg.LocationY, msg.Label), msg.CreateTime))
+	})
+	raw := `{"ToUserName":"corp","FromUserName":"zhangsan","CreateTime":1348831860,"MsgType":"location","Location_X":23.5,"Location_Y":113.5,"Label":"位置","MsgId":1,"AgentID":"1"}`
+	msg, err := decodeMessage([]byte(raw), crypto.JSON)
+	if err != nil {
+		t.Fatalf("decodeMessage() error = %v", err)
+	}
+	if msg.MsgID != 1 || msg.FromUserName != "zhangsan" {
+		t.Errorf("decodeMessage() = %+v", msg.Request)
+	}
+	got, err := r.ServeMessage(context.Background(), msg)
+	want := `{"ToUserName":"zhangsan","FromUserName":"corp","CreateTime":1348831860,"MsgType":"text","Content":"23.5,113.5:位置"}`
+	if err != nil || string(got) != want {
+		t.Errorf("Router.ServeMessage() = %s, %v, want %s", got, err, want)
+	}
+}
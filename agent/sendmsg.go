@@ -1,33 +1,60 @@
 package agent
 
 import (
-	"time"
+	"context"
+	"strings"
 
 	"github.com/qingtao/wxcorp/corp"
-	"github.com/qingtao/wxcorp/corp/errcode"
 )
 
-// SendMsg 应用发送消息
-func (a *Agent) SendMsg(msg *corp.Msg) error {
-	accessToken, err := a.GetAccessToken()
-	if err != nil {
-		return err
-	}
-	for i := 0; i < retryTimes; i++ {
-		err = corp.SendMsg("", accessToken, msg)
-		if err == nil {
-			break
-		}
+// SendMsg 应用发送消息, 返回的结果包含用于撤回消息的msgid和无效的接收人.
+// 为了避免重复发送, 未设置EnableDuplicateCheck时网络错误和系统繁忙不会重试, 参考RetryPolicy
+func (a *Agent) SendMsg(msg *corp.Msg) (*corp.SendResult, error) {
+	return a.sendMsg(msg, false, false)
+}
 
-		// 只检查令牌错误
-		if err != errcode.ErrInvalidAccessToken {
-			return err
+// sendMsg 发送消息, reserved为true时调用方已经使用RateLimiter.ReserveSendMsg为ToUser中的成员计数,
+// 只检查企业每天发送的人次; once为true时除了刷新令牌不重试, 由调用方重试
+func (a *Agent) sendMsg(msg *corp.Msg, reserved, once bool) (res *corp.SendResult, err error) {
+	ctx := context.Background()
+	if a.RateLimiter != nil && msg != nil && msg.ToUser != "@all" {
+		users := normalizeIDs(strings.Split(msg.ToUser, "|"))
+		if reserved {
+			err = a.RateLimiter.SendMsgQuota(ctx, len(users))
+		} else {
+			err = a.RateLimiter.SendMsg(ctx, a.AgentID, users)
 		}
-		accessToken, err = a.RefreshAccessToken()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		time.Sleep(retryInterval)
 	}
-	return nil
+	send := func(accessToken string) error {
+		res, err = a.client().SendMsg(ctx, "", accessToken, msg)
+		return err
+	}
+	if once {
+		err = a.doOnce(ctx, send)
+	} else {
+		err = a.doSend(ctx, msg != nil && msg.EnableDuplicateCheck == 1, send)
+	}
+	return
+}
+
+// RecallMsg 撤回24小时内发送的应用消息
+func (a *Agent) RecallMsg(msgid string) error {
+	ctx := context.Background()
+	return a.do(ctx, func(accessToken string) error {
+		return a.client().RecallMsg(ctx, "", accessToken, msgid)
+	})
+}
+
+// UpdateTemplateCard 更新交互型模板卡片消息. response_code只能使用一次,
+// 与SendMsg相同, 网络错误和系统繁忙时更新可能已经完成, 不会重试
+func (a *Agent) UpdateTemplateCard(msg *corp.UpdateTemplateCardMsg) (res *corp.UpdateTemplateCardResponse, err error) {
+	ctx := context.Background()
+	err = a.doSend(ctx, false, func(accessToken string) error {
+		res, err = a.client().UpdateTemplateCard(ctx, "", accessToken, msg)
+		return err
+	})
+	return
 }
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp"
+	"github.com/qingtao/wxcorp/corp/ratelimit"
+)
+
+func TestAgent_SendMsg(t *testing.T) {
+	var recalled string
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cgi-bin/gettoken":
+			fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+		case "/cgi-bin/message/send":
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","msgid":"msgid1","response_code":"code1"}`)
+		case "/cgi-bin/message/recall":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			recalled = body["msgid"]
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+		case "/cgi-bin/message/update_template_card":
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","invaliduser":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	res, err := a.SendMsg(&corp.Msg{
+		ToUser:  "userid1",
+		AgentID: 1000001,
+		MsgType: "text",
+		Text:    &corp.TextMsg{Content: "abc"},
+	})
+	if err != nil || res.MsgID != "msgid1" || res.ResponseCode != "code1" {
+		t.Fatalf("Agent.SendMsg() = %+v, %v", res, err)
+	}
+	if err = a.RecallMsg(res.MsgID); err != nil || recalled != "msgid1" {
+		t.Fatalf("Agent.RecallMsg() error = %v, recalled %q", err, recalled)
+	}
+	_, err = a.UpdateTemplateCard(&corp.UpdateTemplateCardMsg{
+		UserIDs:      []string{"userid1"},
+		AgentID:      1000001,
+		ResponseCode: res.ResponseCode,
+		Button:       &corp.UpdateTemplateCardButton{ReplaceName: "已处理"},
+	})
+	if err != nil {
+		t.Fatalf("Agent.UpdateTemplateCard() error = %v", err)
+	}
+}
+
+func TestAgent_UpdateTemplateCardNoRetry(t *testing.T) {
+	var n int32
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cgi-bin/gettoken" {
+			fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+			return
+		}
+		atomic.AddInt32(&n, 1)
+		// 服务器可能已经使用了response_code, 但客户端没有收到响应
+		if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			conn.Close()
+		}
+	})
+	a.Retry = &RetryPolicy{MaxAttempts: 3}
+	_, err := a.UpdateTemplateCard(&corp.UpdateTemplateCardMsg{
+		UserIDs:      []string{"userid1"},
+		AgentID:      1000001,
+		ResponseCode: "code1",
+		Button:       &corp.UpdateTemplateCardButton{ReplaceName: "已处理"},
+	})
+	if err == nil {
+		t.Error("Agent.UpdateTemplateCard() error = nil, want transport error")
+	}
+	if n := atomic.LoadInt32(&n); n != 1 {
+		t.Errorf("update_template_card requested %d times, want 1", n)
+	}
+}
+
+func TestAgent_SendMsgRateLimit(t *testing.T) {
+	var sent int
+	a := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cgi-bin/gettoken":
+			fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+		case "/cgi-bin/message/send":
+			sent++
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","msgid":"msgid1"}`)
+		default:
+			fmt.Fprint(w, `{"ticket":"ticket","expires_in":7200}`)
+		}
+	})
+	a.RateLimiter = ratelimit.New("corpid", ratelimit.WithFailFast())
+	msg := &corp.Msg{
+		ToUser:  "userid1|userid2",
+		AgentID: 1000001,
+		MsgType: "text",
+		Text:    &corp.TextMsg{Content: "abc"},
+	}
+	for i := 0; i < ratelimit.BaseTimesSendMsgOneAppUserMinute; i++ {
+		if _, err := a.SendMsg(msg); err != nil {
+			t.Fatalf("Agent.SendMsg() error = %v", err)
+		}
+	}
+	if _, err := a.SendMsg(msg); !errors.Is(err, ratelimit.ErrLimited) {
+		t.Errorf("Agent.SendMsg() error = %v, want %v", err, ratelimit.ErrLimited)
+	}
+	if sent != ratelimit.BaseTimesSendMsgOneAppUserMinute {
+		t.Errorf("Agent.SendMsg() sent = %d, want %d", sent, ratelimit.BaseTimesSendMsgOneAppUserMinute)
+	}
+	// @all不按成员计数
+	msg.ToUser = "@all"
+	if _, err := a.SendMsg(msg); err != nil {
+		t.Errorf("Agent.SendMsg() to @all error = %v", err)
+	}
+
+	for i := 0; i < ratelimit.TimesJsAPITicketOneCorpAppHour; i++ {
+		if _, err := a.RefreshJsAPITicket("agent_config"); err != nil {
+			t.Fatalf("Agent.RefreshJsAPITicket() error = %v", err)
+		}
+	}
+	if _, err := a.RefreshJsAPITicket(""); !errors.Is(err, ratelimit.ErrLimited) {
+		t.Errorf("Agent.RefreshJsAPITicket() error = %v, want %v", err, ratelimit.ErrLimited)
+	}
+}
@@ -0,0 +1,417 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp"
+	"github.com/qingtao/wxcorp/corp/ratelimit"
+)
+
+// ErrSendQueueClosed 发送队列已经关闭
+var ErrSendQueueClosed = errors.New("发送队列已关闭")
+
+// 合并后的消息内容长度上限, 与corp.TextMsg和corp.MarkdownMsg的限制相同
+const maxCoalescedContent = 2048
+
+// SendQueueConfig 发送队列的配置
+type SendQueueConfig struct {
+	// Rule 每个成员的发送频率, 默认每分钟30条; 设置了Agent.RateLimiter时忽略
+	Rule ratelimit.Rule
+	// Backend 成员发送次数的计数器, 默认使用内存存储, 多个进程发送时可以使用共享存储;
+	// 设置了Agent.RateLimiter时忽略, 使用RateLimiter的计数器
+	Backend ratelimit.Backend
+	// MaxPending 每个成员等待发送的消息数量上限, 超过时丢弃新消息, 默认100
+	MaxPending int
+	// OnError 延迟发送的消息发送失败时的回调
+	OnError func(msg *corp.Msg, err error)
+}
+
+// SendReport 发送到队列的结果
+type SendReport struct {
+	// Result 立即发送的结果, 所有成员都被延迟时为nil
+	Result *corp.SendResult
+	// Deferred 超过发送频率被延迟的成员
+	Deferred []string
+	// Coalesced 延迟时与等待中的消息合并的成员, 是Deferred的子集
+	Coalesced []string
+	// Dropped 等待的消息超过MaxPending被丢弃的成员
+	Dropped []string
+}
+
+// SendQueueStats 发送队列的统计数据
+type SendQueueStats struct {
+	// Users 有消息等待发送的成员数
+	Users int
+	// Pending 等待发送的消息数
+	Pending int
+	// Sent 发送成功的请求数
+	Sent uint64
+	// Deferred 被延迟的成员消息数
+	Deferred uint64
+	// Coalesced 合并到等待中的消息的成员消息数
+	Coalesced uint64
+	// Failed 发送失败的请求数
+	Failed uint64
+	// Dropped 被丢弃的成员消息数
+	Dropped uint64
+}
+
+// userQueue 一个成员等待发送的消息
+type userQueue struct {
+	msgs []*corp.Msg
+	// nextAt 下一次可以尝试发送的时间
+	nextAt time.Time
+	// sending 第一条消息正在发送
+	sending bool
+}
+
+// SendQueue 按成员限制发送频率的消息队列. 企业微信会直接丢弃超过频率的消息,
+// 队列在成员超过频率时把发送给该成员的消息延迟到窗口空出后再发送,
+// 等待中的相同设置的text和markdown消息会合并为一条. 使用Close停止队列.
+// Agent设置了RateLimiter时, 队列与RateLimiter共享成员的计数, 每条消息只计数一次;
+// 否则使用SendQueueConfig中的Rule和Backend计数. 队列只处理应用消息, 不包括群聊消息的频率限制
+type SendQueue struct {
+	agent *Agent
+	cfg   SendQueueConfig
+	now   func() time.Time
+
+	mu     sync.Mutex
+	users  map[string]*userQueue
+	closed bool
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	sent      uint64
+	deferred  uint64
+	coalesced uint64
+	failed    uint64
+	dropped   uint64
+}
+
+// NewSendQueue 新建发送队列并启动后台发送
+func NewSendQueue(a *Agent, cfg SendQueueConfig) *SendQueue {
+	if cfg.Rule.Limit <= 0 || cfg.Rule.Window <= 0 {
+		cfg.Rule = ratelimit.Rule{Limit: ratelimit.BaseTimesSendMsgOneAppUserMinute, Window: time.Minute}
+	}
+	if cfg.Backend == nil {
+		cfg.Backend = ratelimit.NewMemoryBackend()
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = 100
+	}
+	q := &SendQueue{
+		agent: a,
+		cfg:   cfg,
+		now:   time.Now,
+		users: make(map[string]*userQueue),
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Send 发送消息, 未超过频率的成员立即发送, 其余成员延迟发送, 部门和标签总是立即发送.
+// 返回的错误只表示立即发送的结果, 延迟发送的错误通过OnError回调
+func (q *SendQueue) Send(msg *corp.Msg) (*SendReport, error) {
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	report := new(SendReport)
+	if msg.ToUser == "" || msg.ToUser == "@all" {
+		if q.isClosed() {
+			return nil, ErrSendQueueClosed
+		}
+		return report, q.send(msg, report)
+	}
+
+	ready, err := q.classify(msg, report)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Deferred) > 0 {
+		q.notify()
+	}
+	if len(ready) == 0 && msg.ToParty == "" && msg.ToTag == "" {
+		return report, nil
+	}
+	m := *msg
+	m.ToUser = strings.Join(ready, "|")
+	return report, q.send(&m, report)
+}
+
+// send 立即发送消息
+func (q *SendQueue) send(msg *corp.Msg, report *SendReport) error {
+	res, err := q.agent.sendMsg(msg, true, false)
+	report.Result = res
+	if err != nil {
+		atomic.AddUint64(&q.failed, 1)
+		return err
+	}
+	atomic.AddUint64(&q.sent, 1)
+	return nil
+}
+
+// classify 区分可以立即发送的成员, 其余成员的消息放入等待队列
+func (q *SendQueue) classify(msg *corp.Msg, report *SendReport) (ready []string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrSendQueueClosed
+	}
+	now := q.now()
+	for _, user := range normalizeIDs(strings.Split(msg.ToUser, "|")) {
+		// 已有等待的消息时排在后面, 保证同一个成员的消息顺序
+		if uq, ok := q.users[user]; ok {
+			q.enqueue(uq, user, msg, report)
+			continue
+		}
+		wait, err := q.take(user)
+		if err != nil {
+			return nil, err
+		}
+		if wait <= 0 {
+			ready = append(ready, user)
+			continue
+		}
+		uq := &userQueue{nextAt: now.Add(wait)}
+		q.users[user] = uq
+		q.enqueue(uq, user, msg, report)
+	}
+	return ready, nil
+}
+
+// enqueue 把发送给user的消息放入等待队列, 能合并时合并到最后一条消息
+func (q *SendQueue) enqueue(uq *userQueue, user string, msg *corp.Msg, report *SendReport) {
+	if n := len(uq.msgs); n > 0 && !(n == 1 && uq.sending) && coalesce(uq.msgs[n-1], msg) {
+		report.Deferred = append(report.Deferred, user)
+		report.Coalesced = append(report.Coalesced, user)
+		atomic.AddUint64(&q.deferred, 1)
+		atomic.AddUint64(&q.coalesced, 1)
+		return
+	}
+	if len(uq.msgs) >= q.cfg.MaxPending {
+		report.Dropped = append(report.Dropped, user)
+		atomic.AddUint64(&q.dropped, 1)
+		return
+	}
+	uq.msgs = append(uq.msgs, userMsg(msg, user))
+	report.Deferred = append(report.Deferred, user)
+	atomic.AddUint64(&q.deferred, 1)
+}
+
+// userMsg 复制发送给一个成员的消息, 合并时会修改复制的内容
+func userMsg(msg *corp.Msg, user string) *corp.Msg {
+	m := *msg
+	m.ToUser, m.ToParty, m.ToTag = user, "", ""
+	if m.Text != nil {
+		text := *m.Text
+		m.Text = &text
+	}
+	if m.Markdown != nil {
+		md := *m.Markdown
+		m.Markdown = &md
+	}
+	return &m
+}
+
+// coalesce 把msg的内容合并到等待中的消息last, 只合并设置相同的text和markdown消息
+func coalesce(last, msg *corp.Msg) bool {
+	if last.MsgType != msg.MsgType || last.AgentID != msg.AgentID || last.Safe != msg.Safe ||
+		last.EnableIDTrans != msg.EnableIDTrans || last.EnableDuplicateCheck != msg.EnableDuplicateCheck {
+		return false
+	}
+	var dst *string
+	var src string
+	switch msg.MsgType {
+	case "text":
+		dst, src = &last.Text.Content, msg.Text.Content
+	case "markdown":
+		dst, src = &last.Markdown.Content, msg.Markdown.Content
+	default:
+		return false
+	}
+	if len(*dst)+1+len(src) > maxCoalescedContent {
+		return false
+	}
+	*dst += "\n" + src
+	return true
+}
+
+// take 为发送给user的消息计数, 超过频率时返回需要等待的时间
+func (q *SendQueue) take(user string) (time.Duration, error) {
+	if l := q.agent.RateLimiter; l != nil {
+		return l.ReserveSendMsg(context.Background(), q.agent.AgentID, user)
+	}
+	return q.cfg.Backend.Take(context.Background(), "sendqueue:"+q.agent.AgentID+":"+user, 1, []ratelimit.Rule{q.cfg.Rule})
+}
+
+// notify 唤醒后台发送
+func (q *SendQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// isClosed 队列是否已经关闭
+func (q *SendQueue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// run 后台发送等待中的消息, 关闭后发送完所有消息或者收到stop时退出
+func (q *SendQueue) run() {
+	defer close(q.done)
+	for {
+		next, empty := q.flush()
+		if empty && q.isClosed() {
+			return
+		}
+		var (
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(q.now()))
+			timeout = timer.C
+		}
+		select {
+		case <-q.stop:
+		case <-q.wake:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+	}
+}
+
+// dueMsg 可以发送的消息
+type dueMsg struct {
+	user string
+	msg  *corp.Msg
+}
+
+// flush 发送所有到期的成员的第一条消息, 返回下一次发送的时间和队列是否为空
+func (q *SendQueue) flush() (next time.Time, empty bool) {
+	for {
+		due := q.due()
+		if len(due) == 0 {
+			break
+		}
+		for _, d := range due {
+			_, err := q.agent.sendMsg(d.msg, true, false)
+			if err != nil {
+				atomic.AddUint64(&q.failed, 1)
+				if q.cfg.OnError != nil {
+					q.cfg.OnError(d.msg, err)
+				}
+			} else {
+				atomic.AddUint64(&q.sent, 1)
+			}
+			q.mu.Lock()
+			uq := q.users[d.user]
+			uq.msgs, uq.sending = uq.msgs[1:], false
+			if len(uq.msgs) == 0 {
+				delete(q.users, d.user)
+			}
+			q.mu.Unlock()
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, uq := range q.users {
+		if next.IsZero() || uq.nextAt.Before(next) {
+			next = uq.nextAt
+		}
+	}
+	return next, len(q.users) == 0
+}
+
+// due 取得到期且未超过频率的成员的第一条消息
+func (q *SendQueue) due() []dueMsg {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var due []dueMsg
+	now := q.now()
+	for user, uq := range q.users {
+		if uq.sending || now.Before(uq.nextAt) {
+			continue
+		}
+		wait, err := q.take(user)
+		if err != nil {
+			// 计数器不可用时稍后重试
+			wait = time.Second
+		}
+		if wait > 0 {
+			uq.nextAt = now.Add(wait)
+			continue
+		}
+		uq.sending = true
+		due = append(due, dueMsg{user: user, msg: uq.msgs[0]})
+	}
+	return due
+}
+
+// Stats 返回发送队列的统计数据
+func (q *SendQueue) Stats() SendQueueStats {
+	q.mu.Lock()
+	users, pending := len(q.users), 0
+	for _, uq := range q.users {
+		pending += len(uq.msgs)
+	}
+	q.mu.Unlock()
+	return SendQueueStats{
+		Users:     users,
+		Pending:   pending,
+		Sent:      atomic.LoadUint64(&q.sent),
+		Deferred:  atomic.LoadUint64(&q.deferred),
+		Coalesced: atomic.LoadUint64(&q.coalesced),
+		Failed:    atomic.LoadUint64(&q.failed),
+		Dropped:   atomic.LoadUint64(&q.dropped),
+	}
+}
+
+// Close 停止接收新消息, 等待延迟的消息发送完成或者ctx结束, ctx结束时未发送的消息计入Dropped
+func (q *SendQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		<-q.done
+		return nil
+	}
+	q.closed = true
+	q.mu.Unlock()
+	q.notify()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+	}
+	close(q.stop)
+	<-q.done
+	q.mu.Lock()
+	for user, uq := range q.users {
+		atomic.AddUint64(&q.dropped, uint64(len(uq.msgs)))
+		delete(q.users, user)
+	}
+	q.mu.Unlock()
+	return ctx.Err()
+}
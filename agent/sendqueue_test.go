@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp"
+	"github.com/qingtao/wxcorp/corp/ratelimit"
+)
+
+// sendRecorder 记录发送的消息
+type sendRecorder struct {
+	mu   sync.Mutex
+	msgs []corp.Msg
+}
+
+func (s *sendRecorder) handler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/cgi-bin/gettoken" {
+		fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+		return
+	}
+	var msg corp.Msg
+	json.NewDecoder(r.Body).Decode(&msg)
+	s.mu.Lock()
+	s.msgs = append(s.msgs, msg)
+	s.mu.Unlock()
+	fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","msgid":"msgid"}`)
+}
+
+// contents 发送给user的消息内容
+func (s *sendRecorder) contents(user string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var contents []string
+	for _, msg := range s.msgs {
+		if msg.Text == nil {
+			continue
+		}
+		for _, u := range strings.Split(msg.ToUser, "|") {
+			if u == user {
+				contents = append(contents, msg.Text.Content)
+			}
+		}
+	}
+	return contents
+}
+
+func textMsg(to, content string) *corp.Msg {
+	return &corp.Msg{ToUser: to, AgentID: 1000001, MsgType: "text", Text: &corp.TextMsg{Content: content}}
+}
+
+func TestSendQueue(t *testing.T) {
+	rec := &sendRecorder{}
+	a := newTestAgent(t, rec.handler)
+	q := NewSendQueue(a, SendQueueConfig{
+		Rule:       ratelimit.Rule{Limit: 2, Window: 100 * time.Millisecond},
+		MaxPending: 2,
+	})
+
+	for i := 1; i <= 5; i++ {
+		report, err := q.Send(textMsg("u1|u2", fmt.Sprint(i)))
+		if err != nil {
+			t.Fatalf("SendQueue.Send() error = %v", err)
+		}
+		switch {
+		case i <= 2 && (report.Result == nil || len(report.Deferred) != 0):
+			t.Errorf("SendQueue.Send(%d) = %+v, want sent", i, report)
+		case i == 3 && (report.Result != nil || len(report.Deferred) != 2 || len(report.Coalesced) != 0):
+			t.Errorf("SendQueue.Send(%d) = %+v, want deferred", i, report)
+		case i > 3 && len(report.Coalesced) != 2:
+			t.Errorf("SendQueue.Send(%d) = %+v, want coalesced", i, report)
+		}
+	}
+	// 部门总是立即发送
+	if report, _ := q.Send(&corp.Msg{ToUser: "u1", ToParty: "1", AgentID: 1000001, MsgType: "text", Text: &corp.TextMsg{Content: "party"}}); report.Result == nil || len(report.Deferred) != 1 {
+		t.Errorf("SendQueue.Send(party) = %+v", report)
+	}
+	// 无法合并的消息排在后面, 超过MaxPending时丢弃
+	image := &corp.Msg{ToUser: "u1", AgentID: 1000001, MsgType: "image", Image: &corp.MediaMsg{MediaID: "1"}}
+	if report, _ := q.Send(image); len(report.Deferred) != 1 {
+		t.Errorf("SendQueue.Send(image) = %+v, want deferred", report)
+	}
+	if report, _ := q.Send(image); len(report.Dropped) != 1 {
+		t.Errorf("SendQueue.Send(image) = %+v, want dropped", report)
+	}
+	if st := q.Stats(); st.Users != 2 || st.Pending != 3 || st.Dropped != 1 {
+		t.Errorf("SendQueue.Stats() = %+v", st)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := q.Close(ctx); err != nil {
+		t.Fatalf("SendQueue.Close() error = %v", err)
+	}
+	want := []string{"1", "2", "3\n4\n5"}
+	if got := rec.contents("u2"); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("u2 received %q, want %q", got, want)
+	}
+	if got := rec.contents("u1"); len(got) != 3 || got[2] != "3\n4\n5\nparty" {
+		t.Errorf("u1 received %q", got)
+	}
+	st := q.Stats()
+	if st.Pending != 0 || st.Sent != 6 || st.Deferred != 8 || st.Coalesced != 5 || st.Failed != 0 {
+		t.Errorf("SendQueue.Stats() = %+v", st)
+	}
+	if _, err := q.Send(textMsg("u1", "closed")); err != ErrSendQueueClosed {
+		t.Errorf("SendQueue.Send() error = %v, want %v", err, ErrSendQueueClosed)
+	}
+}
+
+func TestSendQueue_CloseTimeout(t *testing.T) {
+	rec := &sendRecorder{}
+	a := newTestAgent(t, rec.handler)
+	q := NewSendQueue(a, SendQueueConfig{Rule: ratelimit.Rule{Limit: 1, Window: time.Hour}})
+	q.Send(textMsg("u1", "1"))
+	q.Send(textMsg("u1", "2"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("SendQueue.Close() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if st := q.Stats(); st.Pending != 0 || st.Dropped != 1 || st.Sent != 1 {
+		t.Errorf("SendQueue.Stats() = %+v", st)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	md := func(content string) *corp.Msg {
+		return &corp.Msg{AgentID: 1, MsgType: "markdown", Markdown: &corp.MarkdownMsg{Content: content}}
+	}
+	tests := []struct {
+		name      string
+		last, msg *corp.Msg
+		want      string
+		ok        bool
+	}{
+		{name: "text", last: textMsg("", "a"), msg: textMsg("", "b"), want: "a\nb", ok: true},
+		{name: "markdown", last: md("a"), msg: md("b"), want: "a\nb", ok: true},
+		{name: "type", last: textMsg("", "a"), msg: md("b"), want: "a"},
+		{name: "too long", last: textMsg("", strings.Repeat("a", 2000)), msg: textMsg("", strings.Repeat("b", 48)), want: strings.Repeat("a", 2000)},
+		{name: "safe", last: textMsg("", "a"), msg: &corp.Msg{AgentID: 1000001, Safe: 1, MsgType: "text", Text: &corp.TextMsg{Content: "b"}}, want: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok := coalesce(tt.last, tt.msg); ok != tt.ok {
+				t.Errorf("coalesce() = %v, want %v", ok, tt.ok)
+			}
+			got := ""
+			if tt.last.Text != nil {
+				got = tt.last.Text.Content
+			} else {
+				got = tt.last.Markdown.Content
+			}
+			if got != tt.want {
+				t.Errorf("coalesce() content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendQueue_RateLimiter(t *testing.T) {
+	rec := &sendRecorder{}
+	a := newTestAgent(t, rec.handler)
+	a.RateLimiter = ratelimit.New("corp", ratelimit.WithFailFast())
+	q := NewSendQueue(a, SendQueueConfig{})
+	defer func() {
+		// 延迟的消息需要等待一分钟, 不等待发送完成
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		q.Close(ctx)
+	}()
+
+	// 队列与RateLimiter共享计数, 每个成员每分钟可以发送30条
+	n := ratelimit.BaseTimesSendMsgOneAppUserMinute
+	for i := 0; i < n+1; i++ {
+		report, err := q.Send(textMsg("u1", fmt.Sprint(i)))
+		if err != nil {
+			t.Fatalf("SendQueue.Send(%d) error = %v", i, err)
+		}
+		if deferred := len(report.Deferred) > 0; deferred != (i == n) {
+			t.Errorf("SendQueue.Send(%d) = %+v", i, report)
+		}
+	}
+	if got := len(rec.contents("u1")); got != n {
+		t.Errorf("u1 received %d messages, want %d", got, n)
+	}
+	// 直接发送时同样超过频率
+	if _, err := a.SendMsg(textMsg("u1", "direct")); !errors.Is(err, ratelimit.ErrLimited) {
+		t.Errorf("Agent.SendMsg() error = %v, want %v", err, ratelimit.ErrLimited)
+	}
+}
@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TokenStore 令牌存储, 用于保存access_token和jsapi_ticket,
+// 多个进程共享同一个存储时可以避免重复请求令牌
+type TokenStore interface {
+	// Get 读取令牌和过期时间, 令牌不存在时返回空字符串和nil错误
+	Get(key string) (value string, expiresAt time.Time, err error)
+	// Set 保存令牌和过期时间
+	Set(key, value string, expiresAt time.Time) error
+}
+
+// TokenLocker 令牌存储的可选接口, 实现此接口的存储在刷新令牌时会先加锁,
+// 保证多个进程同时只有一个请求企业微信服务器
+type TokenLocker interface {
+	// Lock 锁定key, 返回的unlock用于解锁
+	Lock(key string) (unlock func(), err error)
+}
+
+// storedToken 存储的令牌
+type storedToken struct {
+	Value     string `json:"value"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// MemoryStore 内存中的令牌存储, 仅在当前进程内有效
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tokens map[string]storedToken
+}
+
+// NewMemoryStore 新建内存令牌存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: make(map[string]storedToken)}
+}
+
+// Get 读取令牌
+func (s *MemoryStore) Get(key string) (string, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tokens[key]
+	if !ok {
+		return "", time.Time{}, nil
+	}
+	return t.Value, time.Unix(t.ExpiresAt, 0), nil
+}
+
+// Set 保存令牌
+func (s *MemoryStore) Set(key, value string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = storedToken{Value: value, ExpiresAt: expiresAt.Unix()}
+	return nil
+}
+
+const (
+	// fileLockRetryInterval 获取文件锁失败时的重试间隔
+	fileLockRetryInterval = 50 * time.Millisecond
+	// fileLockStale 文件锁超过此时间视为持有者已退出
+	fileLockStale = 30 * time.Second
+	// defaultFileLockTimeout 获取文件锁的默认超时时间
+	defaultFileLockTimeout = time.Minute
+)
+
+// ErrLockTimeout 获取文件锁超时
+var ErrLockTimeout = errors.New("获取文件锁超时")
+
+// reLockName 锁文件名中不允许的字符
+var reLockName = regexp.MustCompile(`[^0-9A-Za-z_.-]`)
+
+// FileStore 以JSON文件保存令牌, 适用于同一台机器上的多个进程共享令牌,
+// 修改令牌文件时使用锁文件保证多个进程不会互相覆盖
+type FileStore struct {
+	// LockTimeout 获取文件锁的超时时间, 超时后返回ErrLockTimeout, 小于等于0时使用默认值1分钟
+	LockTimeout time.Duration
+
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore 新建文件令牌存储, path为保存令牌的文件
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// load 读取令牌文件, 文件不存在时返回空的令牌列表
+func (s *FileStore) load() (map[string]storedToken, error) {
+	tokens := make(map[string]storedToken)
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tokens, nil
+		}
+		return nil, err
+	}
+	if len(b) == 0 {
+		return tokens, nil
+	}
+	if err = json.Unmarshal(b, &tokens); err != nil {
+		return nil, errors.Wrap(err, "令牌文件格式错误")
+	}
+	return tokens, nil
+}
+
+// Get 读取令牌
+func (s *FileStore) Get(key string) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	t, ok := tokens[key]
+	if !ok {
+		return "", time.Time{}, nil
+	}
+	return t.Value, time.Unix(t.ExpiresAt, 0), nil
+}
+
+// Set 保存令牌, 持有令牌文件的锁读取并修改令牌, 先写入临时文件再重命名, 避免其他进程读到不完整的文件
+func (s *FileStore) Set(key, value string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(s.path+".lock", s.lockTimeout())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	tokens[key] = storedToken{Value: value, ExpiresAt: expiresAt.Unix()}
+	b, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path, b)
+}
+
+// writeFile 先写入临时文件再重命名为path, 避免其他进程读到不完整的文件
+func writeFile(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err = os.Chmod(f.Name(), 0600); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Lock 通过创建锁文件锁定key, 锁文件存在超过30秒时视为失效, 超过LockTimeout时返回ErrLockTimeout
+func (s *FileStore) Lock(key string) (func(), error) {
+	return lockFile(s.path+"."+reLockName.ReplaceAllString(key, "_")+".lock", s.lockTimeout())
+}
+
+// lockTimeout 获取文件锁的超时时间
+func (s *FileStore) lockTimeout() time.Duration {
+	if s.LockTimeout > 0 {
+		return s.LockTimeout
+	}
+	return defaultFileLockTimeout
+}
+
+// lockFile 创建锁文件name, 文件内容为持有者的进程ID和随机数, 解锁时只删除自己创建的锁文件
+func lockFile(name string, timeout time.Duration) (func(), error) {
+	owner, err := lockOwner()
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_, err = f.Write(owner)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(name)
+				return nil, err
+			}
+			return func() {
+				if b, err := ioutil.ReadFile(name); err == nil && bytes.Equal(b, owner) {
+					os.Remove(name)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if removeStaleLock(name, owner) {
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.WithMessage(ErrLockTimeout, name)
+		}
+		time.Sleep(fileLockRetryInterval)
+	}
+}
+
+// lockOwner 生成锁文件的内容
+func lockOwner() ([]byte, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return []byte(strconv.Itoa(os.Getpid()) + "-" + hex.EncodeToString(nonce)), nil
+}
+
+// removeStaleLock 删除失效的锁文件, 删除成功时返回true.
+// 先把锁文件重命名为只属于当前调用方的文件, 确认仍然是检查过的失效锁后再删除,
+// 否则说明其他调用方已经重新加锁, 把锁文件恢复原状
+func removeStaleLock(name string, owner []byte) bool {
+	stale, err := ioutil.ReadFile(name)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(name)
+	if err != nil || time.Since(fi.ModTime()) <= fileLockStale {
+		return false
+	}
+	tmp := name + "." + string(owner) + ".stale"
+	if err = os.Rename(name, tmp); err != nil {
+		return false
+	}
+	if b, err := ioutil.ReadFile(tmp); err == nil && bytes.Equal(b, stale) {
+		os.Remove(tmp)
+		return true
+	}
+	// Link在name已经存在时失败, 不会覆盖其他调用方新建的锁文件
+	os.Link(tmp, name)
+	os.Remove(tmp)
+	return false
+}
@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func testTokenStore(t *testing.T, s TokenStore) {
+	value, expiresAt, err := s.Get("a")
+	if err != nil || value != "" || !expiresAt.IsZero() {
+		t.Fatalf("Get() of missing key = %v, %v, %v", value, expiresAt, err)
+	}
+	want := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err = s.Set("a", "token", want); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err = s.Set("b", "ticket", want); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	value, expiresAt, err = s.Get("a")
+	if err != nil || value != "token" || !expiresAt.Equal(want) {
+		t.Errorf("Get() = %v, %v, %v, want token, %v", value, expiresAt, err, want)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testTokenStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	s := NewFileStore(path)
+	testTokenStore(t, s)
+	// 新的实例读取同一个文件
+	value, _, err := NewFileStore(path).Get("b")
+	if err != nil || value != "ticket" {
+		t.Errorf("Get() = %v, %v, want ticket", value, err)
+	}
+}
+
+func TestFileStore_Lock(t *testing.T) {
+	s := NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		holding bool
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := s.Lock("corp:1:access_token")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			if holding {
+				t.Error("Lock() held by two goroutines")
+			}
+			holding = true
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			holding = false
+			mu.Unlock()
+			unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFileStore_ConcurrentSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	var wg sync.WaitGroup
+	// 每个FileStore模拟一个进程
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := NewFileStore(path)
+			for j := 0; j < 5; j++ {
+				if err := s.Set(fmt.Sprintf("%d-%d", i, j), "v", time.Now().Add(time.Hour)); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	tokens, err := NewFileStore(path).load()
+	if err != nil || len(tokens) != 40 {
+		t.Errorf("load() = %d tokens, %v, want 40", len(tokens), err)
+	}
+}
+
+func TestFileStore_LockStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	s := NewFileStore(path)
+	s.LockTimeout = 100 * time.Millisecond
+	name := path + ".key.lock"
+
+	unlock, err := s.Lock("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Lock("key"); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Lock() error = %v, want %v", err, ErrLockTimeout)
+	}
+
+	// 失效的锁被删除, 原持有者解锁时不会删除新的锁
+	old := time.Now().Add(-2 * fileLockStale)
+	if err = os.Chtimes(name, old, old); err != nil {
+		t.Fatal(err)
+	}
+	unlock2, err := s.Lock("key")
+	if err != nil {
+		t.Fatalf("Lock() stale error = %v", err)
+	}
+	unlock()
+	if _, err = os.Stat(name); err != nil {
+		t.Errorf("unlock() removed lock of other owner: %v", err)
+	}
+	unlock2()
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("unlock() lock file still exists: %v", err)
+	}
+	if matches, _ := filepath.Glob(name + ".*"); len(matches) != 0 {
+		t.Errorf("temporary lock files left: %v", matches)
+	}
+}
+
+func TestRemoveStaleLock(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "key.lock")
+	if err := ioutil.WriteFile(name, []byte("other"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if removeStaleLock(name, []byte("me")) {
+		t.Error("removeStaleLock() removed fresh lock")
+	}
+	old := time.Now().Add(-2 * fileLockStale)
+	os.Chtimes(name, old, old)
+	if !removeStaleLock(name, []byte("me")) {
+		t.Error("removeStaleLock() did not remove stale lock")
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("stale lock still exists: %v", err)
+	}
+}
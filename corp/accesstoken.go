@@ -1,21 +1,17 @@
 package corp
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"context"
 	"net/http"
 	"net/url"
-	"time"
 
 	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp/crypto"
 	"github.com/qingtao/wxcorp/corp/errcode"
-
-	"github.com/sbzhu/weworkapi_golang/wxbizmsgcrypt"
 )
 
 const (
-	defaultGetTokenURL = `https://qyapi.weixin.qq.com/cgi-bin/gettoken`
+	getTokenPath = "/cgi-bin/gettoken"
 )
 
 var (
@@ -27,47 +23,28 @@ var (
 	ErrIsNil = errors.New("对象为空")
 )
 
-// 设置http请求超时时间为10秒
-var httpClient = http.Client{
-	Timeout: 10 * time.Second,
-}
-
 // NewAccessTokenURL 新建获取access_token的URL
 func NewAccessTokenURL(url, corpid, secret string) string {
 	if corpid == "" || secret == "" {
 		return ""
 	}
 	if url == "" {
-		url = defaultGetTokenURL
+		url = apiURL(getTokenPath)
 	}
-	return fmt.Sprintf("%s?corpid=%s&corpsecret=%s", url, corpid, secret)
+	return buildURL(url, "corpid", corpid, "corpsecret", secret)
 }
 
 // GetAccessToken 获取access_token, 返回err != nil 如果出现错误
 func GetAccessToken(url, corpid, secret string) (res *AccessTokenResponse, err error) {
+	return DefaultClient.GetAccessToken(context.Background(), url, corpid, secret)
+}
+
+// GetAccessToken 获取access_token, 返回err != nil 如果出现错误
+func (c *Client) GetAccessToken(ctx context.Context, url, corpid, secret string) (res *AccessTokenResponse, err error) {
 	if corpid == "" || secret == "" {
 		return nil, ErrCorpIDOrSecretIsEmpty
 	}
-	addr := NewAccessTokenURL(url, corpid, secret)
-	resp, err := httpClient.Get(addr)
-	if err != nil {
-		return
-	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
-	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	err = json.Unmarshal(b, &res)
-	if err != nil {
-		return
-	}
-
-	err = res.Validate()
-	return
+	return execute[AccessTokenResponse](ctx, c, http.MethodGet, NewAccessTokenURL(url, corpid, secret), nil)
 }
 
 // AccessTokenResponse 企业微信的access_token响应
@@ -83,7 +60,7 @@ func (token *AccessTokenResponse) Validate() error {
 	if token == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(token.ErrCode)
+	return errcode.New(token.ErrCode, token.ErrMsg)
 }
 
 // GetEchoStr 获取echostr
@@ -103,11 +80,9 @@ func GetEchoStr(corpid, token, encodingAESKey, s string) (echostr []byte, err er
 	if msgsign == "" || timestamp == "" || nonce == "" || encechostr == "" {
 		return nil, ErrEchoStrURLInvalid
 	}
-	wxcpt := wxbizmsgcrypt.NewWXBizMsgCrypt(token, encodingAESKey, corpid, wxbizmsgcrypt.XmlType)
-	var cryptErr *wxbizmsgcrypt.CryptError
-	echostr, cryptErr = wxcpt.VerifyURL(msgsign, timestamp, nonce, encechostr)
-	if cryptErr != nil {
-		return nil, errors.New(cryptErr.ErrMsg)
+	crypter, err := crypto.New(token, encodingAESKey, corpid)
+	if err != nil {
+		return nil, err
 	}
-	return
+	return crypter.VerifyURL(msgsign, timestamp, nonce, encechostr)
 }
@@ -0,0 +1,50 @@
+package corp
+
+import (
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultAPIBaseURL 企业微信接口的默认地址
+const DefaultAPIBaseURL = "https://qyapi.weixin.qq.com"
+
+// ErrAPIBaseURLInvalid 接口地址格式错误
+var ErrAPIBaseURLInvalid = errors.New("接口地址格式错误")
+
+// apiBaseURL 当前使用的接口地址
+var apiBaseURL atomic.Value
+
+func init() {
+	apiBaseURL.Store(DefaultAPIBaseURL)
+}
+
+// SetAPIBaseURL 设置所有New*URL使用的接口地址, 例如私有网关或者本地测试服务,
+// base必须是http或https的绝对地址, 可以包含路径前缀, 不能包含查询参数; 为空时恢复默认地址
+func SetAPIBaseURL(base string) error {
+	if base == "" {
+		apiBaseURL.Store(DefaultAPIBaseURL)
+		return nil
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return errors.Wrap(ErrAPIBaseURLInvalid, err.Error())
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return ErrAPIBaseURLInvalid
+	}
+	apiBaseURL.Store(strings.TrimRight(base, "/"))
+	return nil
+}
+
+// APIBaseURL 返回当前使用的接口地址
+func APIBaseURL() string {
+	return apiBaseURL.Load().(string)
+}
+
+// apiURL 返回接口地址和path拼接后的URL
+func apiURL(path string) string {
+	return APIBaseURL() + path
+}
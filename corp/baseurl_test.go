@@ -0,0 +1,46 @@
+package corp
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestSetAPIBaseURL(t *testing.T) {
+	defer SetAPIBaseURL("")
+
+	tests := []struct {
+		name    string
+		base    string
+		want    string
+		wantErr bool
+	}{
+		{name: "gateway", base: "http://127.0.0.1:8080/wx/", want: "http://127.0.0.1:8080/wx/cgi-bin/gettoken?corpid=1&corpsecret=2"},
+		{name: "scheme", base: "ftp://a.com", wantErr: true},
+		{name: "relative", base: "/wx", wantErr: true},
+		{name: "query", base: "https://a.com?x=1", wantErr: true},
+		{name: "parse", base: "http://a.com/%zz", wantErr: true},
+		{name: "default", base: "", want: "https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=1&corpsecret=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := APIBaseURL()
+			err := SetAPIBaseURL(tt.base)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetAPIBaseURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if errors.Cause(err) != ErrAPIBaseURLInvalid {
+					t.Errorf("SetAPIBaseURL() error = %v, want %v", err, ErrAPIBaseURLInvalid)
+				}
+				if APIBaseURL() != prev {
+					t.Errorf("APIBaseURL() = %v, want unchanged %v", APIBaseURL(), prev)
+				}
+				return
+			}
+			if got := NewAccessTokenURL("", "1", "2"); got != tt.want {
+				t.Errorf("NewAccessTokenURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
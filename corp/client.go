@@ -0,0 +1,69 @@
+package corp
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/qingtao/wxcorp/corp/ratelimit"
+)
+
+// defaultTimeout 默认的http请求超时时间
+const defaultTimeout = 10 * time.Second
+
+// DefaultClient 包级别函数使用的默认客户端, http请求超时时间为10秒
+var DefaultClient = NewClient()
+
+// Client 企业微信接口客户端, 所有请求都携带调用方提供的context,
+// 可以通过ClientOption替换底层的http.Client或者http.RoundTripper以支持代理、自定义TLS等
+type Client struct {
+	httpClient *http.Client
+	limiter    *ratelimit.Limiter
+}
+
+// ClientOption 客户端选项
+type ClientOption func(*Client)
+
+// WithHTTPClient 使用指定的http.Client, 为nil时忽略
+func WithHTTPClient(hc *http.Client) ClientOption {
+	return func(c *Client) {
+		if hc != nil {
+			c.httpClient = hc
+		}
+	}
+}
+
+// WithTransport 使用指定的http.RoundTripper发送请求, 为nil时忽略
+func WithTransport(rt http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		if rt == nil {
+			return
+		}
+		hc := *c.httpClient
+		hc.Transport = rt
+		c.httpClient = &hc
+	}
+}
+
+// WithRateLimiter 每次请求前检查企业调用单个接口的频率, 为nil时不限制.
+// 发送消息和获取jsapi_ticket有单独的频率限制, 不在这里计数, 参考Limiter.SendMsg和Limiter.JsAPITicket
+func WithRateLimiter(l *ratelimit.Limiter) ClientOption {
+	return func(c *Client) {
+		c.limiter = l
+	}
+}
+
+// NewClient 新建客户端, 未指定http.Client时使用超时时间为10秒的默认配置
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{
+		httpClient: &http.Client{Timeout: defaultTimeout},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// HTTPClient 返回客户端使用的http.Client
+func (c *Client) HTTPClient() *http.Client {
+	return c.httpClient
+}
@@ -0,0 +1,124 @@
+package corp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp/ratelimit"
+)
+
+type countTransport struct {
+	n  int
+	rt http.RoundTripper
+}
+
+func (t *countTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.n++
+	return t.rt.RoundTrip(r)
+}
+
+func TestNewClient(t *testing.T) {
+	c := NewClient()
+	if c.HTTPClient().Timeout != defaultTimeout {
+		t.Errorf("NewClient() timeout = %v, want %v", c.HTTPClient().Timeout, defaultTimeout)
+	}
+	hc := &http.Client{Timeout: time.Second}
+	if got := NewClient(WithHTTPClient(hc)).HTTPClient(); got != hc {
+		t.Errorf("WithHTTPClient() = %v, want %v", got, hc)
+	}
+	if got := NewClient(WithHTTPClient(nil), WithTransport(nil)).HTTPClient(); got.Timeout != defaultTimeout || got.Transport != nil {
+		t.Errorf("NewClient() with nil options = %v", got)
+	}
+}
+
+func TestClient_GetAccessToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("corpid") == "slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		fmt.Fprint(w, `{"access_token":"accesstoken000001","expires_in":7200}`)
+	}))
+	defer ts.Close()
+
+	tr := &countTransport{rt: http.DefaultTransport}
+	c := NewClient(WithTransport(tr))
+	res, err := c.GetAccessToken(context.Background(), ts.URL, "1", "2")
+	if err != nil {
+		t.Fatalf("Client.GetAccessToken() error = %v", err)
+	}
+	if res.AccessToken != "accesstoken000001" || tr.n != 1 {
+		t.Errorf("Client.GetAccessToken() = %v, requests = %d", res, tr.n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = c.GetAccessToken(ctx, ts.URL, "slow", "2"); err == nil {
+		t.Errorf("Client.GetAccessToken() with canceled context, want error")
+	}
+}
+
+// limitBackend 超过limit次后返回等待时间
+type limitBackend struct {
+	limit int
+	keys  []string
+}
+
+func (b *limitBackend) Take(_ context.Context, key string, n int, rules []ratelimit.Rule) (time.Duration, error) {
+	if len(b.keys) >= b.limit {
+		return time.Minute, nil
+	}
+	b.keys = append(b.keys, key)
+	return 0, nil
+}
+
+func TestClient_RateLimiter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"accesstoken000001","expires_in":7200}`)
+	}))
+	defer ts.Close()
+
+	b := &limitBackend{limit: 1}
+	tr := &countTransport{rt: http.DefaultTransport}
+	c := NewClient(WithTransport(tr), WithRateLimiter(ratelimit.New("corp", ratelimit.WithBackend(b), ratelimit.WithFailFast())))
+	if _, err := c.GetAccessToken(context.Background(), ts.URL+getTokenPath, "1", "2"); err != nil {
+		t.Fatalf("Client.GetAccessToken() error = %v", err)
+	}
+	if len(b.keys) != 1 || b.keys[0] != "corp:api:"+getTokenPath {
+		t.Errorf("Client.GetAccessToken() limiter keys = %v", b.keys)
+	}
+	_, err := c.GetAccessToken(context.Background(), ts.URL+getTokenPath, "1", "2")
+	if !errors.Is(err, ratelimit.ErrLimited) || tr.n != 1 {
+		t.Errorf("Client.GetAccessToken() error = %v, requests = %d", err, tr.n)
+	}
+}
+
+func TestClient_RateLimiterPath(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","access_token":"accesstoken000001","expires_in":7200}`)
+	}))
+	defer ts.Close()
+	if err := SetAPIBaseURL(ts.URL + "/gateway"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetAPIBaseURL("")
+
+	b := &limitBackend{limit: 10}
+	c := NewClient(WithRateLimiter(ratelimit.New("corp", ratelimit.WithBackend(b))))
+	ctx := context.Background()
+	if _, err := c.GetAccessToken(ctx, "", "1", "2"); err != nil {
+		t.Fatalf("Client.GetAccessToken() error = %v", err)
+	}
+	msg := &Msg{ToUser: "u1", AgentID: 1, MsgType: "text", Text: &TextMsg{Content: "hi"}}
+	if _, err := c.SendMsg(ctx, "", "token", msg); err != nil {
+		t.Fatalf("Client.SendMsg() error = %v", err)
+	}
+	// 使用去掉网关前缀的路径计数, 发送消息不计入调用单个接口的频率
+	if len(b.keys) != 1 || b.keys[0] != "corp:api:"+getTokenPath {
+		t.Errorf("limiter keys = %v", b.keys)
+	}
+}
@@ -0,0 +1,237 @@
+// Package crypto 实现企业微信回调消息的加解密方案: 消息使用AES-256-CBC加密并以PKCS#7填充(块大小32字节),
+// 签名为token、timestamp、nonce和密文排序后拼接的SHA1, 支持XML和JSON两种格式的消息
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Error 加解密错误, 错误码与企业微信官方库一致, 可以使用errors.Is和下面的错误变量比较
+type Error struct {
+	// Code 错误码
+	Code int
+	// Msg 错误信息
+	Msg string
+}
+
+// Error 实现error接口
+func (e *Error) Error() string {
+	return fmt.Sprintf("[errcode]:%d,[errmsg]:%s", e.Code, e.Msg)
+}
+
+var (
+	// ErrSignature 签名校验失败
+	ErrSignature = &Error{Code: -40001, Msg: "签名校验失败"}
+	// ErrParse 消息格式错误
+	ErrParse = &Error{Code: -40002, Msg: "消息格式错误"}
+	// ErrAESKey EncodingAESKey格式错误
+	ErrAESKey = &Error{Code: -40004, Msg: "EncodingAESKey格式错误"}
+	// ErrReceiverID 消息的接收者(CorpID)不一致
+	ErrReceiverID = &Error{Code: -40005, Msg: "接收者ID不一致"}
+	// ErrPadding 密文长度或者填充错误
+	ErrPadding = &Error{Code: -40007, Msg: "密文填充错误"}
+	// ErrLength 明文中的消息长度错误
+	ErrLength = &Error{Code: -40008, Msg: "消息长度错误"}
+	// ErrBase64 密文不是有效的base64编码
+	ErrBase64 = &Error{Code: -40010, Msg: "base64解码失败"}
+)
+
+// Format 消息格式
+type Format int
+
+const (
+	// XML XML格式的消息
+	XML Format = iota
+	// JSON JSON格式的消息
+	JSON
+)
+
+const (
+	// blockSize PKCS#7的填充块大小
+	blockSize = 32
+	// randomSize 明文开头的随机字节数
+	randomSize = 16
+	// encodingAESKeyLen EncodingAESKey的长度
+	encodingAESKeyLen = 43
+)
+
+// Crypter 加解密工具, 创建后可以被多个goroutine同时使用
+type Crypter struct {
+	token      string
+	receiverID string
+	key        []byte
+	block      cipher.Block
+}
+
+// New 新建加解密工具, receiverID为企业ID(CorpID)
+func New(token, encodingAESKey, receiverID string) (*Crypter, error) {
+	if len(encodingAESKey) != encodingAESKeyLen {
+		return nil, ErrAESKey
+	}
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil {
+		return nil, errors.Wrap(ErrAESKey, err.Error())
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(ErrAESKey, err.Error())
+	}
+	return &Crypter{token: token, receiverID: receiverID, key: key, block: block}, nil
+}
+
+// Signature 计算签名
+func (c *Crypter) Signature(timestamp, nonce, encrypted string) string {
+	s := []string{c.token, timestamp, nonce, encrypted}
+	sort.Strings(s)
+	sum := sha1.Sum([]byte(strings.Join(s, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifySignature 校验签名
+func (c *Crypter) VerifySignature(signature, timestamp, nonce, encrypted string) error {
+	if subtle.ConstantTimeCompare([]byte(c.Signature(timestamp, nonce, encrypted)), []byte(signature)) != 1 {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Encrypt 加密消息, 返回base64编码的密文
+func (c *Crypter) Encrypt(plain []byte) (string, error) {
+	n := randomSize + 4 + len(plain) + len(c.receiverID)
+	pad := blockSize - n%blockSize
+	buf := make([]byte, n+pad)
+	if _, err := io.ReadFull(rand.Reader, buf[:randomSize]); err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint32(buf[randomSize:], uint32(len(plain)))
+	copy(buf[randomSize+4:], plain)
+	copy(buf[randomSize+4+len(plain):], c.receiverID)
+	copy(buf[n:], bytes.Repeat([]byte{byte(pad)}, pad))
+	cipher.NewCBCEncrypter(c.block, c.key[:aes.BlockSize]).CryptBlocks(buf, buf)
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// Decrypt 解密base64编码的密文, 并校验接收者ID
+func (c *Crypter) Decrypt(encrypted string) ([]byte, error) {
+	buf, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, ErrBase64
+	}
+	if len(buf) == 0 || len(buf)%aes.BlockSize != 0 {
+		return nil, ErrPadding
+	}
+	cipher.NewCBCDecrypter(c.block, c.key[:aes.BlockSize]).CryptBlocks(buf, buf)
+	pad := int(buf[len(buf)-1])
+	if pad < 1 || pad > blockSize || pad > len(buf) {
+		return nil, ErrPadding
+	}
+	for _, b := range buf[len(buf)-pad:] {
+		if int(b) != pad {
+			return nil, ErrPadding
+		}
+	}
+	buf = buf[:len(buf)-pad]
+	if len(buf) < randomSize+4 {
+		return nil, ErrLength
+	}
+	n := binary.BigEndian.Uint32(buf[randomSize:])
+	buf = buf[randomSize+4:]
+	if uint64(n) > uint64(len(buf)) {
+		return nil, ErrLength
+	}
+	if string(buf[n:]) != c.receiverID {
+		return nil, ErrReceiverID
+	}
+	return buf[:n], nil
+}
+
+// VerifyURL 校验回调URL的签名并解密echostr
+func (c *Crypter) VerifyURL(signature, timestamp, nonce, echostr string) ([]byte, error) {
+	if err := c.VerifySignature(signature, timestamp, nonce, echostr); err != nil {
+		return nil, err
+	}
+	return c.Decrypt(echostr)
+}
+
+// envelope 企业微信推送的加密消息
+type envelope struct {
+	ToUserName string `xml:"ToUserName" json:"tousername"`
+	AgentID    string `xml:"AgentID" json:"agentid"`
+	Encrypt    string `xml:"Encrypt" json:"encrypt"`
+}
+
+// DecryptMsg 解析企业微信推送的加密消息, 校验签名后返回解密的消息
+func (c *Crypter) DecryptMsg(signature, timestamp, nonce string, body []byte, format Format) ([]byte, error) {
+	var (
+		env envelope
+		err error
+	)
+	if format == JSON {
+		err = json.Unmarshal(body, &env)
+	} else {
+		err = xml.Unmarshal(body, &env)
+	}
+	if err != nil || env.Encrypt == "" {
+		return nil, ErrParse
+	}
+	if err = c.VerifySignature(signature, timestamp, nonce, env.Encrypt); err != nil {
+		return nil, err
+	}
+	return c.Decrypt(env.Encrypt)
+}
+
+// cdata 以CDATA段编码的字符串
+type cdata struct {
+	Value string `xml:",cdata"`
+}
+
+// xmlReply XML格式的加密回复
+type xmlReply struct {
+	XMLName      xml.Name `xml:"xml"`
+	Encrypt      cdata
+	MsgSignature cdata
+	TimeStamp    string
+	Nonce        cdata
+}
+
+// jsonReply JSON格式的加密回复
+type jsonReply struct {
+	Encrypt      string `json:"encrypt"`
+	MsgSignature string `json:"msgsignature"`
+	TimeStamp    int64  `json:"timestamp"`
+	Nonce        string `json:"nonce"`
+}
+
+// EncryptMsg 加密被动回复的消息, 返回带签名的加密消息
+func (c *Crypter) EncryptMsg(plain []byte, timestamp, nonce string, format Format) ([]byte, error) {
+	encrypted, err := c.Encrypt(plain)
+	if err != nil {
+		return nil, err
+	}
+	signature := c.Signature(timestamp, nonce, encrypted)
+	if format == JSON {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "时间戳格式错误")
+		}
+		return json.Marshal(jsonReply{Encrypt: encrypted, MsgSignature: signature, TimeStamp: ts, Nonce: nonce})
+	}
+	return xml.Marshal(xmlReply{Encrypt: cdata{encrypted}, MsgSignature: cdata{signature}, TimeStamp: timestamp, Nonce: cdata{nonce}})
+}
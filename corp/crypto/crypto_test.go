@@ -0,0 +1,143 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	testToken  = "QDG6eK"
+	testAESKey = "jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2C"
+	testCorpID = "wx5823bf96d3bd56c7"
+)
+
+func newTestCrypter(t testing.TB) *Crypter {
+	c, err := New(testToken, testAESKey, testCorpID)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return c
+}
+
+func TestNew(t *testing.T) {
+	for _, key := range []string{"", "short", strings.Repeat("*", encodingAESKeyLen)} {
+		if _, err := New(testToken, key, testCorpID); !errors.Is(err, ErrAESKey) {
+			t.Errorf("New(%q) error = %v, want %v", key, err, ErrAESKey)
+		}
+	}
+}
+
+func TestCrypter_VerifyURL(t *testing.T) {
+	// 企业微信文档中的示例
+	c, err := New("qbw2JZV581j", "G5pMmqEIYdO8qneyK3fdxdbizm4f2noJ8t0MdhT97iF", "ww6a49152bad10fa40")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	const echostr = "DrdwnEhttJWlbTV1eFRz49NetjDXCrwPd3q+enXvHD4YBq6wEB4CmC21thyAy2fxw55D5L38YKMF55chC5MCQA=="
+	got, err := c.VerifyURL("e94e4549349cfaa88058d425e990237f06c01c0e", "1548646136", "1548381701", echostr)
+	if err != nil || string(got) != "6467373778033604605" {
+		t.Errorf("Crypter.VerifyURL() = %s, %v", got, err)
+	}
+	if _, err = c.VerifyURL("e94e4549349cfaa88058d425e990237f06c01c0f", "1548646136", "1548381701", echostr); err != ErrSignature {
+		t.Errorf("Crypter.VerifyURL() error = %v, want %v", err, ErrSignature)
+	}
+}
+
+func TestCrypter_Decrypt(t *testing.T) {
+	c := newTestCrypter(t)
+	other, _ := New(testToken, testAESKey, "other")
+	encrypted, err := other.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Crypter.Encrypt() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		encrypted string
+		wantErr   error
+	}{
+		{name: "base64", encrypted: "!!!", wantErr: ErrBase64},
+		{name: "empty", encrypted: "", wantErr: ErrPadding},
+		{name: "block", encrypted: "YWJj", wantErr: ErrPadding},
+		{name: "padding", encrypted: "AAAAAAAAAAAAAAAAAAAAAA==", wantErr: ErrPadding},
+		{name: "receiver", encrypted: encrypted, wantErr: ErrReceiverID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.Decrypt(tt.encrypted); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Crypter.Decrypt() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCrypter_Msg(t *testing.T) {
+	c := newTestCrypter(t)
+	plain := []byte("<xml><Content><![CDATA[你好]]></Content></xml>")
+	for _, format := range []Format{XML, JSON} {
+		b, err := c.EncryptMsg(plain, "1409659813", "1372623149", format)
+		if err != nil {
+			t.Fatalf("Crypter.EncryptMsg() error = %v", err)
+		}
+		var reply struct {
+			Encrypt      string `xml:"Encrypt" json:"encrypt"`
+			MsgSignature string `xml:"MsgSignature" json:"msgsignature"`
+		}
+		if format == JSON {
+			err = json.Unmarshal(b, &reply)
+		} else {
+			err = xml.Unmarshal(b, &reply)
+		}
+		if err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", b, err)
+		}
+		got, err := c.DecryptMsg(reply.MsgSignature, "1409659813", "1372623149", b, format)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("Crypter.DecryptMsg() = %s, %v", got, err)
+		}
+		if _, err = c.DecryptMsg(reply.MsgSignature, "1409659814", "1372623149", b, format); err != ErrSignature {
+			t.Errorf("Crypter.DecryptMsg() error = %v, want %v", err, ErrSignature)
+		}
+		if _, err = c.DecryptMsg(reply.MsgSignature, "1409659813", "1372623149", []byte("<xml>"), format); err != ErrParse {
+			t.Errorf("Crypter.DecryptMsg() error = %v, want %v", err, ErrParse)
+		}
+	}
+	if _, err := c.EncryptMsg(plain, "abc", "1", JSON); err == nil {
+		t.Errorf("Crypter.EncryptMsg() with invalid timestamp want error")
+	}
+}
+
+func FuzzCrypter_Decrypt(f *testing.F) {
+	c := newTestCrypter(f)
+	encrypted, _ := c.Encrypt([]byte("hello"))
+	for _, s := range []string{"", "YWJj", "AAAAAAAAAAAAAAAAAAAAAA==", encrypted} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		// 任意输入都不能panic
+		c.Decrypt(s)
+		c.DecryptMsg("", "", "", []byte(s), XML)
+		c.DecryptMsg("", "", "", []byte(s), JSON)
+	})
+}
+
+func FuzzCrypter_RoundTrip(f *testing.F) {
+	c := newTestCrypter(f)
+	for _, s := range []string{"", "hello", "<xml>]]></xml>", strings.Repeat("a", 100)} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, plain []byte) {
+		encrypted, err := c.Encrypt(plain)
+		if err != nil {
+			t.Fatalf("Crypter.Encrypt() error = %v", err)
+		}
+		got, err := c.Decrypt(encrypted)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("Crypter.Decrypt() = %q, %v, want %q", got, err, plain)
+		}
+	})
+}
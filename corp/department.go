@@ -1,12 +1,11 @@
 package corp
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"context"
 	"math"
+	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -14,10 +13,10 @@ import (
 )
 
 const (
-	defaultDepartmentListURL   = "https://qyapi.weixin.qq.com/cgi-bin/department/list"
-	defaultDepartmentCreateURL = "https://qyapi.weixin.qq.com/cgi-bin/department/create"
-	defaultDepartmentUpdateURL = "https://qyapi.weixin.qq.com/cgi-bin/department/update"
-	defaultDepartmentDeleteURL = "https://qyapi.weixin.qq.com/cgi-bin/department/delete"
+	departmentListPath   = "/cgi-bin/department/list"
+	departmentCreatePath = "/cgi-bin/department/create"
+	departmentUpdatePath = "/cgi-bin/department/update"
+	departmentDeletePath = "/cgi-bin/department/delete"
 )
 
 // Department 部门结构
@@ -80,7 +79,7 @@ func (res *ChangeDepartmentResponse) Validate() error {
 	if res == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(res.ErrCode)
+	return errcode.New(res.ErrCode, res.ErrMsg)
 }
 
 // DepartmentResponse 请求部门列表的响应
@@ -96,7 +95,7 @@ func (dept *DepartmentResponse) Validate() error {
 	if dept == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(dept.ErrCode)
+	return errcode.New(dept.ErrCode, dept.ErrMsg)
 }
 
 // NewGetDepartmentListURL 新建获取部门列表的URL
@@ -105,34 +104,24 @@ func NewGetDepartmentListURL(url, accessToken string, id int) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultDepartmentListURL
+		url = apiURL(departmentListPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s&id=%d", url, accessToken, id)
+	return buildURL(url, "access_token", accessToken, "id", strconv.Itoa(id))
 }
 
 // GetDepartment 获取部门信息
 // 未测试
 func GetDepartment(url, accessToken string, id int) (dept *DepartmentResponse, err error) {
+	return DefaultClient.GetDepartment(context.Background(), url, accessToken, id)
+}
+
+// GetDepartment 获取部门信息
+// 未测试
+func (c *Client) GetDepartment(ctx context.Context, url, accessToken string, id int) (dept *DepartmentResponse, err error) {
 	if accessToken == "" {
 		return nil, errcode.ErrInvalidAccessToken
 	}
-	url = NewGetDepartmentListURL(url, accessToken, id)
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
-	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	if err = json.Unmarshal(b, &dept); err != nil {
-		return nil, err
-	}
-	err = dept.Validate()
-	return
+	return execute[DepartmentResponse](ctx, c, http.MethodGet, NewGetDepartmentListURL(url, accessToken, id), nil)
 }
 
 // NewCreateDepartmentURL 新建创建部门请求的URL
@@ -141,9 +130,9 @@ func NewCreateDepartmentURL(url, accessToken string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultDepartmentCreateURL
+		url = apiURL(departmentCreatePath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
 // NewUpdateDepartmentURL 新建更新部门请求的URL
@@ -152,14 +141,14 @@ func NewUpdateDepartmentURL(url, accessToken string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultDepartmentUpdateURL
+		url = apiURL(departmentUpdatePath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
 // postDepartment 提交部门, action指定为"create"时按照创建部门检查名称是否为空
 // 未测试
-func postDepartment(url, accessToken, action string, dept *Department) (err error) {
+func (c *Client) postDepartment(ctx context.Context, url, accessToken, action string, dept *Department) (err error) {
 	if accessToken == "" {
 		return errcode.ErrInvalidAccessToken
 	}
@@ -171,46 +160,38 @@ func postDepartment(url, accessToken, action string, dept *Department) (err erro
 	default:
 		return errors.New("不支持的操作")
 	}
-	b, err := json.Marshal(dept)
-	if err != nil {
-		return
-	}
-	buf := bytes.NewReader(b)
-	resp, err := httpClient.Post(url, mimeApplicationJSONCharsetUTF8, buf)
-	if err != nil {
-		return
-	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
-	}
-	b, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
-	}
-	var res ChangeDepartmentResponse
-	if err = json.Unmarshal(b, &res); err != nil {
-		return
-	}
-	err = res.Validate()
+	_, err = execute[ChangeDepartmentResponse](ctx, c, http.MethodPost, url, dept)
 	return
 }
 
 // CreateDepartment 创建部门
 // 未测试
 func CreateDepartment(url, accessToken string, dept *Department) error {
+	return DefaultClient.CreateDepartment(context.Background(), url, accessToken, dept)
+}
+
+// CreateDepartment 创建部门
+// 未测试
+func (c *Client) CreateDepartment(ctx context.Context, url, accessToken string, dept *Department) error {
 	if err := dept.Validate("create"); err != nil {
 		return err
 	}
-	return postDepartment(url, accessToken, "create", dept)
+	return c.postDepartment(ctx, url, accessToken, "create", dept)
 }
 
 // UpdateDepartment 更新部门
 // 未测试
 func UpdateDepartment(url, accessToken string, dept *Department) error {
+	return DefaultClient.UpdateDepartment(context.Background(), url, accessToken, dept)
+}
+
+// UpdateDepartment 更新部门
+// 未测试
+func (c *Client) UpdateDepartment(ctx context.Context, url, accessToken string, dept *Department) error {
 	if err := dept.Validate("update"); err != nil {
 		return err
 	}
-	return postDepartment(url, accessToken, "update", dept)
+	return c.postDepartment(ctx, url, accessToken, "update", dept)
 }
 
 // NewDeleteDepartmentURL 新建删除部门的URL
@@ -219,30 +200,24 @@ func NewDeleteDepartmentURL(url, accessToken string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultDepartmentDeleteURL
+		url = apiURL(departmentDeletePath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
 // DeleteDepartment 删除部门,不能删除根部门；不能删除含有子部门、成员的部门, 所以要先确定id是否可以删除
 // 未测试
 func DeleteDepartment(url, accessToken string, id int) error {
+	return DefaultClient.DeleteDepartment(context.Background(), url, accessToken, id)
+}
+
+// DeleteDepartment 删除部门,不能删除根部门；不能删除含有子部门、成员的部门, 所以要先确定id是否可以删除
+// 未测试
+func (c *Client) DeleteDepartment(ctx context.Context, url, accessToken string, id int) error {
 	if accessToken == "" {
 		return errcode.ErrInvalidAccessToken
 	}
-	url = NewDeleteDepartmentURL(url, accessToken)
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-	var res ChangeDepartmentResponse
-	if err = json.Unmarshal(b, &res); err != nil {
-		return err
-	}
-	return res.Validate()
+	url = buildURL(NewDeleteDepartmentURL(url, accessToken), "id", strconv.Itoa(id))
+	_, err := execute[ChangeDepartmentResponse](ctx, c, http.MethodGet, url, nil)
+	return err
 }
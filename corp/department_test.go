@@ -214,6 +214,12 @@ func TestDeleteDepartment(t *testing.T) {
 		accesstoken := r.FormValue("access_token")
 		switch accesstoken {
 		case "ok":
+			// 删除的部门id通过查询参数id传递
+			if r.Method != http.MethodGet || r.FormValue("id") != "2" {
+				t.Errorf("DeleteDepartment() request = %s %s, want GET with id=2", r.Method, r.URL)
+				fmt.Fprint(w, `{"errcode":40001,"errmsg":"invalid id"}`)
+				return
+			}
 			fmt.Fprint(w, s)
 		case "json_error":
 			fmt.Fprint(w, `"errcode":0,"errmsg":"deleted"}`)
@@ -0,0 +1,112 @@
+package errcode
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// APIError 企业微信接口返回的错误, 可以使用errors.Is和下面的错误码变量比较,
+// 或者使用errors.As取得错误码
+type APIError struct {
+	// Code 错误码errcode
+	Code int
+	// Msg 服务器返回的错误信息errmsg
+	Msg string
+	// Hint 全局错误码文档中的说明
+	Hint string
+}
+
+// Error 实现error接口
+func (e *APIError) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = e.Hint
+	}
+	if e.Hint == "" || e.Hint == msg {
+		return fmt.Sprintf("[errcode]:%d,[errmsg]:%s", e.Code, msg)
+	}
+	return fmt.Sprintf("[errcode]:%d,[errmsg]:%s,[hint]:%s", e.Code, msg, e.Hint)
+}
+
+// Is 错误码相同时视为同一个错误, ErrInvalidAccessToken同时匹配40014和40021,
+// ErrUnknown匹配全局错误码中不存在的错误码
+func (e *APIError) Is(target error) bool {
+	if target == ErrUnknown {
+		_, ok := errCode[e.Code]
+		return !ok
+	}
+	t, ok := target.(*APIError)
+	if !ok {
+		return false
+	}
+	if t == ErrInvalidAccessToken && e.Code == 40021 {
+		return true
+	}
+	return t.Code == e.Code
+}
+
+var (
+	// ErrUnknown 未知错误, 错误码不在全局错误码中
+	ErrUnknown = errors.New("未知错误")
+
+	// ErrSystemBusy 系统繁忙
+	ErrSystemBusy = newSentinel(-1)
+	// ErrInvalidSecret 不合法的secret参数
+	ErrInvalidSecret = newSentinel(40001)
+	// ErrInvalidUserID 无效的UserID
+	ErrInvalidUserID = newSentinel(40003)
+	// ErrInvalidCorpID 不合法的CorpID
+	ErrInvalidCorpID = newSentinel(40013)
+	// ErrInvalidAccessToken 无效的访问令牌
+	ErrInvalidAccessToken = &APIError{Code: 40014, Msg: "无效的访问令牌", Hint: errCode[40014]}
+	// ErrInvalidOAuthCode 不合法的oauth_code
+	ErrInvalidOAuthCode = newSentinel(40029)
+	// ErrInvalidAgentID 不合法的agentid
+	ErrInvalidAgentID = newSentinel(40056)
+	// ErrMissingAccessToken 缺少access_token参数
+	ErrMissingAccessToken = newSentinel(41001)
+	// ErrAccessTokenExpired access_token已过期
+	ErrAccessTokenExpired = newSentinel(42001)
+	// ErrFrequencyLimit 接口调用超过限制
+	ErrFrequencyLimit = newSentinel(45009)
+	// ErrConcurrencyLimit 接口并发调用超过限制
+	ErrConcurrencyLimit = newSentinel(45033)
+	// ErrAPINoPrivilege API接口无权限调用
+	ErrAPINoPrivilege = newSentinel(48002)
+	// ErrNoPrivilege 指定的成员/部门/标签参数无权限
+	ErrNoPrivilege = newSentinel(60011)
+	// ErrIPNotInWhitelist 访问ip不在白名单之中
+	ErrIPNotInWhitelist = newSentinel(60020)
+	// ErrUserNotFound UserID不存在
+	ErrUserNotFound = newSentinel(60111)
+	// ErrAllRecipientsInvalid UserID、部门ID、标签ID全部非法或无权限
+	ErrAllRecipientsInvalid = newSentinel(81013)
+)
+
+// newSentinel 新建错误码变量
+func newSentinel(code int) *APIError {
+	return &APIError{Code: code, Hint: errCode[code]}
+}
+
+// New 根据错误码和服务器返回的错误信息新建错误, code为0时返回nil
+func New(code int, msg string) error {
+	if code == 0 {
+		return nil
+	}
+	return &APIError{Code: code, Msg: msg, Hint: errCode[code]}
+}
+
+// Error 取得错误码对应的错误, i为0时返回nil
+func Error(i int) error {
+	return New(i, "")
+}
+
+// Code 取得错误中的企业微信错误码, err不包含APIError时ok为false
+func Code(err error) (code int, ok bool) {
+	var e *APIError
+	if errors.As(err, &e) {
+		return e.Code, true
+	}
+	return 0, false
+}
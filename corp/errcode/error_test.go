@@ -0,0 +1,70 @@
+package errcode
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestAPIError_Is(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		target error
+		want   bool
+	}{
+		{name: "60011", err: New(60011, "no privilege"), target: ErrNoPrivilege, want: true},
+		{name: "45009", err: New(45009, "api freq out of limit"), target: ErrFrequencyLimit, want: true},
+		{name: "40014", err: New(40014, "invalid access_token"), target: ErrInvalidAccessToken, want: true},
+		{name: "40021", err: Error(40021), target: ErrInvalidAccessToken, want: true},
+		{name: "42001", err: New(42001, "access_token expired"), target: ErrInvalidAccessToken, want: false},
+		{name: "code", err: New(60011, ""), target: &APIError{Code: 60011}, want: true},
+		{name: "unknown", err: New(-2, "unknown"), target: ErrUnknown, want: true},
+		{name: "known", err: New(-1, "system busy"), target: ErrUnknown, want: false},
+		{name: "wrapped", err: errors.WithMessage(New(60111, "userid not found"), "invaliduser"), target: ErrUserNotFound, want: true},
+		{name: "other", err: errors.New("x"), target: ErrSystemBusy, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(tt.err, tt.target); got != tt.want {
+				t.Errorf("errors.Is(%v, %v) = %v, want %v", tt.err, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIError_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "msg", err: New(60011, "no privilege"), want: "[errcode]:60011,[errmsg]:no privilege,[hint]:指定的成员/部门/标签参数无权限 查看帮助"},
+		{name: "hint", err: Error(60011), want: "[errcode]:60011,[errmsg]:指定的成员/部门/标签参数无权限 查看帮助"},
+		{name: "unknown", err: New(-2, "unknown"), want: "[errcode]:-2,[errmsg]:unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("APIError.Error() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCode(t *testing.T) {
+	var e *APIError
+	err := errors.Wrap(New(45009, "api freq out of limit"), "send")
+	if !errors.As(err, &e) || e.Code != 45009 || e.Msg != "api freq out of limit" {
+		t.Errorf("errors.As() = %v", e)
+	}
+	if code, ok := Code(err); !ok || code != 45009 {
+		t.Errorf("Code() = %v, %v, want 45009", code, ok)
+	}
+	if code, ok := Code(errors.New("x")); ok || code != 0 {
+		t.Errorf("Code() = %v, %v, want 0, false", code, ok)
+	}
+	if err := New(0, "ok"); err != nil {
+		t.Errorf("New(0) = %v, want nil", err)
+	}
+}
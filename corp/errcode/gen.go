@@ -87,32 +87,7 @@ func main() {
 
 // 注意：此文件由代码生成工具生成，请勿直接编辑
 
-import (
-	"github.com/pkg/errors"
-)
-
-var (
-	// ErrInvalidAccessToken 无效的访问令牌
-	ErrInvalidAccessToken = errors.New("无效的访问令牌")
-	// ErrUnknown 未知错误
-	ErrUnknown = errors.New("未知错误")
-)
-
-// Error 取得错误信息
-func Error(i int) error {
-	if i == 0 {
-		return nil
-	}
-	if i == 40014 || i == 40021 {
-		return ErrInvalidAccessToken
-	}
-	msg, ok := errCode[i]
-	if !ok {
-		return ErrUnknown
-	}
-	return errors.New(msg)
-}
-
+// errCode 全局错误码说明
 var errCode = map[int]string{
 `)
 	for _, t := range all {
@@ -2,32 +2,7 @@ package errcode
 
 // 注意：此文件由代码生成工具生成，请勿直接编辑
 
-import (
-	"github.com/pkg/errors"
-)
-
-var (
-	// ErrInvalidAccessToken 无效的访问令牌
-	ErrInvalidAccessToken = errors.New("无效的访问令牌")
-	// ErrUnknown 未知错误
-	ErrUnknown = errors.New("未知错误")
-)
-
-// Error 取得错误信息
-func Error(i int) error {
-	if i == 0 {
-		return nil
-	}
-	if i == 40014 || i == 40021 {
-		return ErrInvalidAccessToken
-	}
-	msg, ok := errCode[i]
-	if !ok {
-		return ErrUnknown
-	}
-	return errors.New(msg)
-}
-
+// errCode 全局错误码说明
 var errCode = map[int]string{
 	-1: `系统繁忙 服务器暂不可用，建议稍候重试。建议重试次数不超过3次。`,
 	0: `请求成功 接口调用成功`,
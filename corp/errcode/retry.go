@@ -0,0 +1,67 @@
+package errcode
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// Action 出现错误时的处理方式
+type Action int
+
+const (
+	// Fatal 不可恢复的错误, 不再重试
+	Fatal Action = iota
+	// Retry 临时错误, 等待后重试
+	Retry
+	// RefreshToken 访问令牌无效或者过期, 刷新令牌后重试
+	RefreshToken
+)
+
+// String 处理方式的名称
+func (a Action) String() string {
+	switch a {
+	case Retry:
+		return "retry"
+	case RefreshToken:
+		return "refresh_token"
+	}
+	return "fatal"
+}
+
+// retryableCodes 可以重试的错误码
+var retryableCodes = map[int]Action{
+	-1:    Retry,        // 系统繁忙
+	45009: Retry,        // 接口调用超过限制
+	45033: Retry,        // 接口并发调用超过限制
+	40014: RefreshToken, // 不合法的access_token
+	40021: RefreshToken,
+	41001: RefreshToken, // 缺少access_token参数
+	42001: RefreshToken, // access_token已过期
+}
+
+// Classify 判断错误的处理方式: 系统繁忙、频率限制、网络错误和临时错误可以重试,
+// 令牌错误需要刷新令牌后重试, 其他错误以及调用方取消的请求不再重试
+func Classify(err error) Action {
+	if err == nil {
+		return Fatal
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return Fatal
+	}
+	if code, ok := Code(err); ok {
+		return retryableCodes[code]
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return Retry
+	}
+	// 例如http状态码为5xx的错误
+	var temp interface{ Temporary() bool }
+	if errors.As(err, &temp) && temp.Temporary() {
+		return Retry
+	}
+	return Fatal
+}
@@ -0,0 +1,44 @@
+package errcode
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type temporaryError bool
+
+func (e temporaryError) Error() string   { return "temporary" }
+func (e temporaryError) Temporary() bool { return bool(e) }
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Action
+	}{
+		{name: "nil", err: nil, want: Fatal},
+		{name: "-1", err: New(-1, "system busy"), want: Retry},
+		{name: "45009", err: New(45009, "api freq out of limit"), want: Retry},
+		{name: "40014", err: ErrInvalidAccessToken, want: RefreshToken},
+		{name: "42001", err: errors.WithMessage(New(42001, "access_token expired"), "x"), want: RefreshToken},
+		{name: "60011", err: New(60011, "no privilege"), want: Fatal},
+		{name: "net", err: &url.Error{Op: "Get", URL: "x", Err: &net.OpError{Op: "dial", Err: errors.New("refused")}}, want: Retry},
+		{name: "eof", err: io.ErrUnexpectedEOF, want: Retry},
+		{name: "temporary", err: errors.Wrap(temporaryError(true), "x"), want: Retry},
+		{name: "permanent", err: temporaryError(false), want: Fatal},
+		{name: "canceled", err: &url.Error{Op: "Get", URL: "x", Err: context.Canceled}, want: Fatal},
+		{name: "other", err: errors.New("消息为空"), want: Fatal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
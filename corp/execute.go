@@ -0,0 +1,135 @@
+package corp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxResponseSize 响应数据的最大长度
+const maxResponseSize = 10 << 20
+
+// ErrResponseTooLarge 响应数据超过maxResponseSize
+var ErrResponseTooLarge = errors.New("响应数据过大")
+
+// StatusError 企业微信服务器返回的http状态码不是200
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+// Error 实现error接口
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("http状态码错误: %s", e.Status)
+}
+
+// Temporary 服务器错误和请求过多时可以重试
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// validator 可以校验的响应结构
+type validator interface {
+	Validate() error
+}
+
+// buildURL 在base后添加查询参数, kv为依次排列的参数名和参数值, 参数值会被正确转义
+func buildURL(base string, kv ...string) string {
+	values := make(url.Values, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		values.Add(kv[i], kv[i+1])
+	}
+	if len(values) == 0 {
+		return base
+	}
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return base + sep + values.Encode()
+}
+
+// ownLimitPaths 有单独频率限制的接口, 由ratelimit.Limiter的SendMsg和JsAPITicket计数, 不计入调用单个接口的频率
+var ownLimitPaths = map[string]bool{
+	sendMsgPath:             true,
+	getJsAPITicketPath:      true,
+	getAgentJsAPITicketPath: true,
+}
+
+// apiPath 返回相对于APIBaseURL的接口路径, 使用SetAPIBaseURL设置了路径前缀时去掉前缀
+func apiPath(u *url.URL) string {
+	base, err := url.Parse(APIBaseURL())
+	if err != nil || base.Path == "" || base.Host != u.Host {
+		return u.Path
+	}
+	if strings.HasPrefix(u.Path, base.Path+"/") {
+		return strings.TrimPrefix(u.Path, base.Path)
+	}
+	return u.Path
+}
+
+// execute 发送请求并把JSON格式的响应解析到R, body不为nil时以JSON格式提交.
+// 解析成功后调用Validate检查错误码, 此时即使返回错误也会返回响应; 响应为null时返回ErrIsNil
+func execute[R any, PR interface {
+	*R
+	validator
+}](ctx context.Context, c *Client, method, rawurl string, body interface{}) (*R, error) {
+	var (
+		reader      io.Reader
+		contentType string
+	)
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader, contentType = bytes.NewReader(b), mimeApplicationJSONCharsetUTF8
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawurl, reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.limiter != nil {
+		if path := apiPath(req.URL); !ownLimitPaths[path] {
+			if err = c.limiter.API(ctx, path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxResponseSize {
+		return nil, ErrResponseTooLarge
+	}
+	// 响应为null时res仍然为nil
+	var res *R
+	if err = json.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, ErrIsNil
+	}
+	return res, PR(res).Validate()
+}
@@ -0,0 +1,112 @@
+package corp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp/errcode"
+)
+
+func TestBuildURL(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		kv   []string
+		want string
+	}{
+		{name: "empty", base: "https://a.com/x", want: "https://a.com/x"},
+		{name: "sorted", base: "https://a.com/x", kv: []string{"userid", "a", "access_token", "b"}, want: "https://a.com/x?access_token=b&userid=a"},
+		{name: "escape", base: "https://a.com/x", kv: []string{"userid", "a&b=c+d 中"}, want: "https://a.com/x?userid=a%26b%3Dc%2Bd+%E4%B8%AD"},
+		{name: "query", base: "https://a.com/x?debug=1", kv: []string{"id", "1"}, want: "https://a.com/x?debug=1&id=1"},
+		{name: "multi", base: "https://a.com/x", kv: []string{"status", "1", "status", "2"}, want: "https://a.com/x?status=1&status=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildURL(tt.base, tt.kv...); got != tt.want {
+				t.Errorf("buildURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecute(t *testing.T) {
+	ht := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+		case "/post":
+			if r.Header.Get("Content-Type") != mimeApplicationJSONCharsetUTF8 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			b, _ := ioutil.ReadAll(r.Body)
+			if string(b) != `{"userid":"a"}` {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+		case "/errcode":
+			fmt.Fprint(w, `{"errcode":60011,"errmsg":"no privilege"}`)
+		case "/large":
+			fmt.Fprint(w, `{"errmsg":"`+strings.Repeat("a", maxResponseSize)+`"}`)
+		case "/json":
+			fmt.Fprint(w, `{"errcode":0`)
+		case "/null":
+			fmt.Fprint(w, `null`)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ht.Close()
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    interface{}
+		wantRes bool
+		wantErr error
+	}{
+		{name: "ok", method: http.MethodGet, path: "/ok", wantRes: true},
+		{name: "post", method: http.MethodPost, path: "/post", body: map[string]string{"userid": "a"}, wantRes: true},
+		{name: "errcode", method: http.MethodGet, path: "/errcode", wantRes: true, wantErr: errcode.ErrNoPrivilege},
+		{name: "large", method: http.MethodGet, path: "/large", wantErr: ErrResponseTooLarge},
+		{name: "status", method: http.MethodGet, path: "/502", wantErr: &StatusError{}},
+		{name: "json", method: http.MethodGet, path: "/json", wantErr: errors.New("json")},
+		{name: "null", method: http.MethodGet, path: "/null", wantErr: ErrIsNil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := execute[Response](context.Background(), DefaultClient, tt.method, ht.URL+tt.path, tt.body)
+			if (res != nil) != tt.wantRes {
+				t.Errorf("execute() = %v, wantRes %v", res, tt.wantRes)
+			}
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("execute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			switch want := tt.wantErr.(type) {
+			case *errcode.APIError:
+				if !errors.Is(err, want) {
+					t.Errorf("execute() error = %v, want %v", err, want)
+				}
+			case *StatusError:
+				var se *StatusError
+				if !errors.As(err, &se) || se.StatusCode != http.StatusBadGateway || !se.Temporary() {
+					t.Errorf("execute() error = %v, want StatusError", err)
+				}
+				if errcode.Classify(err) != errcode.Retry {
+					t.Errorf("Classify(%v) = %v, want retry", err, errcode.Classify(err))
+				}
+			}
+			if (tt.wantErr == ErrResponseTooLarge || tt.wantErr == ErrIsNil) && err != tt.wantErr {
+				t.Errorf("execute() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
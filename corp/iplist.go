@@ -1,15 +1,18 @@
 package corp
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/qingtao/wxcorp/corp/errcode"
 )
 
-// defaultGetCallBackIPURL 默认的获取企业微信服务器IP的URL(不包含参数)
-const defaultGetCallBackIPURL = "https://qyapi.weixin.qq.com/cgi-bin/getcallbackip"
+// getCallBackIPPath 获取企业微信服务器IP的接口路径
+const getCallBackIPPath = "/cgi-bin/getcallbackip"
 
 // IPListResponse 响应
 type IPListResponse struct {
@@ -24,7 +27,7 @@ func (ipList *IPListResponse) Validate() error {
 	if ipList == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(ipList.ErrCode)
+	return errcode.New(ipList.ErrCode, ipList.ErrMsg)
 }
 
 // NewIPListURL IP地址段的请求的URL
@@ -33,32 +36,114 @@ func NewIPListURL(url, accessToken string) (s string) {
 		return
 	}
 	if url == "" {
-		url = defaultGetCallBackIPURL
+		url = apiURL(getCallBackIPPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
 // GetCallBackIPList 获取企业微信服务器地址段
 func GetCallBackIPList(url, accessToken string) (ipList *IPListResponse, err error) {
+	return DefaultClient.GetCallBackIPList(context.Background(), url, accessToken)
+}
+
+// GetCallBackIPList 获取企业微信服务器地址段
+func (c *Client) GetCallBackIPList(ctx context.Context, url, accessToken string) (ipList *IPListResponse, err error) {
 	if accessToken == "" {
 		return nil, errcode.ErrInvalidAccessToken
 	}
-	url = NewIPListURL(url, accessToken)
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, err
+	return execute[IPListResponse](ctx, c, http.MethodGet, NewIPListURL(url, accessToken), nil)
+}
+
+// ErrIPInvalid IP地址格式错误
+var ErrIPInvalid = errors.New("IP地址格式错误")
+
+// IPMatcher IP地址列表, 支持单个IP、CIDR和以*结尾的IPv4通配符(例如"101.226.103.*")
+type IPMatcher struct {
+	nets []*net.IPNet
+}
+
+// ParseIPList 解析IP地址列表, 例如GetCallBackIPList返回的IPList, 忽略空字符串
+func ParseIPList(list []string) (*IPMatcher, error) {
+	m := &IPMatcher{nets: make([]*net.IPNet, 0, len(list))}
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		n, err := parseIPNet(s)
+		if err != nil {
+			return nil, err
+		}
+		m.nets = append(m.nets, n)
+	}
+	return m, nil
+}
+
+// parseIPNet 解析单个IP、CIDR或者通配符
+func parseIPNet(s string) (*net.IPNet, error) {
+	switch {
+	case strings.Contains(s, "/"):
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Wrap(ErrIPInvalid, s)
+		}
+		return n, nil
+	case strings.Contains(s, "*"):
+		return parseWildcard(s)
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.Wrap(ErrIPInvalid, s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// parseWildcard 解析IPv4通配符, *只能出现在末尾的若干段
+func parseWildcard(s string) (*net.IPNet, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != net.IPv4len {
+		return nil, errors.Wrap(ErrIPInvalid, s)
+	}
+	ip := make(net.IP, net.IPv4len)
+	ones := 0
+	for i, p := range parts {
+		if p == "*" {
+			continue
+		}
+		// *之后不能再出现数字
+		if ones != i*8 {
+			return nil, errors.Wrap(ErrIPInvalid, s)
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || n > 255 {
+			return nil, errors.Wrap(ErrIPInvalid, s)
+		}
+		ip[i] = byte(n)
+		ones += 8
 	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(ones, 32)}, nil
+}
 
-	if resp.Body != nil {
-		defer resp.Body.Close()
+// Contains 判断ip是否在列表中, m为nil时返回false
+func (m *IPMatcher) Contains(ip net.IP) bool {
+	if m == nil || ip == nil {
+		return false
 	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	for _, n := range m.nets {
+		if n.Contains(ip) {
+			return true
+		}
 	}
-	if err = json.Unmarshal(b, &ipList); err != nil {
-		return nil, err
+	return false
+}
+
+// Len 返回列表中的地址段数量
+func (m *IPMatcher) Len() int {
+	if m == nil {
+		return 0
 	}
-	err = ipList.Validate()
-	return
+	return len(m.nets)
 }
@@ -3,10 +3,13 @@ package corp
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+
+	"github.com/pkg/errors"
 )
 
 func TestIPListResponse_Validate(t *testing.T) {
@@ -152,3 +155,44 @@ func TestNewIPListURL(t *testing.T) {
 		})
 	}
 }
+
+func TestParseIPList(t *testing.T) {
+	m, err := ParseIPList([]string{"101.226.103.*", "14.17.*.*", "182.254.11.176", "10.0.0.0/8", "2001:db8::/32", " "})
+	if err != nil {
+		t.Fatalf("ParseIPList() error = %v", err)
+	}
+	if m.Len() != 5 {
+		t.Errorf("IPMatcher.Len() = %d, want 5", m.Len())
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "101.226.103.59", want: true},
+		{ip: "101.226.104.59", want: false},
+		{ip: "14.17.255.1", want: true},
+		{ip: "182.254.11.176", want: true},
+		{ip: "182.254.11.177", want: false},
+		{ip: "10.1.2.3", want: true},
+		{ip: "::ffff:10.1.2.3", want: true},
+		{ip: "2001:db8::1", want: true},
+		{ip: "2001:db9::1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := m.Contains(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("IPMatcher.Contains(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+	var empty *IPMatcher
+	if empty.Contains(net.ParseIP("1.1.1.1")) || empty.Len() != 0 {
+		t.Errorf("nil IPMatcher should not match")
+	}
+
+	for _, s := range []string{"1.2.*.4", "1.2.3", "1.2.3.256.*", "1.2.3.x", "10.0.0.0/33", "abc", "*.1.1.1"} {
+		if _, err := ParseIPList([]string{s}); errors.Cause(err) != ErrIPInvalid {
+			t.Errorf("ParseIPList(%q) error = %v, want %v", s, err, ErrIPInvalid)
+		}
+	}
+}
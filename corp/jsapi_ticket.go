@@ -1,19 +1,19 @@
 package corp
 
 import (
+	"context"
 	"crypto/sha1"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"net/http"
 
 	"github.com/qingtao/wxcorp/corp/errcode"
 )
 
 const (
-	// defaultGetJsAPITicketURL 请求jsapi_ticket的URL(不包含参数)
-	defaultGetJsAPITicketURL = "https://qyapi.weixin.qq.com/cgi-bin/get_jsapi_ticket"
-	// defaultGetAgentJsAPITicketURL 请求应用的jsapi_ticket(不包含参数)
-	defaultGetAgentJsAPITicketURL = "https://qyapi.weixin.qq.com/cgi-bin/ticket/get"
+	// getJsAPITicketPath 请求jsapi_ticket的接口路径
+	getJsAPITicketPath = "/cgi-bin/get_jsapi_ticket"
+	// getAgentJsAPITicketPath 请求应用的jsapi_ticket的接口路径
+	getAgentJsAPITicketPath = "/cgi-bin/ticket/get"
 )
 
 // JsAPITicketResponse 企业的jsapi_ticket响应结果
@@ -31,7 +31,7 @@ func (res *JsAPITicketResponse) Validate() error {
 	if res == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(res.ErrCode)
+	return errcode.New(res.ErrCode, res.ErrMsg)
 }
 
 // NewJsAPITicketURL 新建请求jsapi_ticket的URL
@@ -41,38 +41,27 @@ func NewJsAPITicketURL(url, accessToken, typ string) string {
 	}
 	if typ == "agent_config" {
 		if url == "" {
-			url = defaultGetAgentJsAPITicketURL
+			url = apiURL(getAgentJsAPITicketPath)
 		}
-		return fmt.Sprintf("%s?access_token=%s&type=agent_config", url, accessToken)
+		return buildURL(url, "access_token", accessToken, "type", "agent_config")
 	}
 	if url == "" {
-		url = defaultGetJsAPITicketURL
+		url = apiURL(getJsAPITicketPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
 // GetJsAPITicket 请求jsapi_ticket
 func GetJsAPITicket(url, accessToken, typ string) (ticket *JsAPITicketResponse, err error) {
+	return DefaultClient.GetJsAPITicket(context.Background(), url, accessToken, typ)
+}
+
+// GetJsAPITicket 请求jsapi_ticket
+func (c *Client) GetJsAPITicket(ctx context.Context, url, accessToken, typ string) (ticket *JsAPITicketResponse, err error) {
 	if accessToken == "" {
 		return nil, errcode.ErrInvalidAccessToken
 	}
-	url = NewJsAPITicketURL(url, accessToken, typ)
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
-	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	if err = json.Unmarshal(b, &ticket); err != nil {
-		return
-	}
-	err = ticket.Validate()
-	return
+	return execute[JsAPITicketResponse](ctx, c, http.MethodGet, NewJsAPITicketURL(url, accessToken, typ), nil)
 }
 
 // genSignature 生成ticket的签名
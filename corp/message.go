@@ -0,0 +1,134 @@
+package corp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MsgHeader 回调消息和事件的公共字段
+type MsgHeader struct {
+	ToUserName   string
+	FromUserName string
+	CreateTime   int64
+	MsgType      string
+	AgentID      string
+}
+
+// TextMessage 文本消息
+type TextMessage struct {
+	MsgHeader
+	MsgID   int64 `xml:"MsgId"`
+	Content string
+}
+
+// ImageMessage 图片消息
+type ImageMessage struct {
+	MsgHeader
+	MsgID   int64  `xml:"MsgId"`
+	PicURL  string `xml:"PicUrl"`
+	MediaID string `xml:"MediaId"`
+}
+
+// VoiceMessage 语音消息
+type VoiceMessage struct {
+	MsgHeader
+	MsgID   int64  `xml:"MsgId"`
+	MediaID string `xml:"MediaId"`
+	Format  string
+}
+
+// VideoMessage 视频消息
+type VideoMessage struct {
+	MsgHeader
+	MsgID        int64  `xml:"MsgId"`
+	MediaID      string `xml:"MediaId"`
+	ThumbMediaID string `xml:"ThumbMediaId"`
+}
+
+// LocationMessage 位置消息
+type LocationMessage struct {
+	MsgHeader
+	MsgID     int64   `xml:"MsgId"`
+	LocationX float32 `xml:"Location_X" json:"Location_X"`
+	LocationY float32 `xml:"Location_Y" json:"Location_Y"`
+	Scale     float32
+	Label     string
+}
+
+// LinkMessage 链接消息
+type LinkMessage struct {
+	MsgHeader
+	MsgID       int64 `xml:"MsgId"`
+	Title       string
+	Description string
+	URL         string `xml:"Url"`
+	PicURL      string `xml:"PicUrl"`
+}
+
+// EventMessage 事件, 例如subscribe、enter_agent、click、view等
+type EventMessage struct {
+	MsgHeader
+	Event    string
+	EventKey string
+}
+
+// LocationEvent 上报地理位置事件(LOCATION)
+type LocationEvent struct {
+	EventMessage
+	Latitude  float32
+	Longitude float32
+	Precision float32
+}
+
+// ScanCodeEvent 扫码事件(scancode_push、scancode_waitmsg)
+type ScanCodeEvent struct {
+	EventMessage
+	ScanCodeInfo ScanCodeInfo
+}
+
+// SendPicsEvent 发图事件(pic_sysphoto、pic_photo_or_album、pic_weixin)
+type SendPicsEvent struct {
+	EventMessage
+	SendPicsInfo SendPicsInfo
+}
+
+// LocationSelectEvent 弹出地理位置选择器事件(location_select)
+type LocationSelectEvent struct {
+	EventMessage
+	SendLocationInfo SendLocationInfo
+}
+
+// BatchJobResultEvent 异步任务完成事件(batch_job_result)
+type BatchJobResultEvent struct {
+	EventMessage
+	BatchJob BatchJobEvent
+}
+
+// splitItems 分割以逗号分隔的列表
+func splitItems(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// TagChange 把标签成员变更事件(ChangeType为update_tag)转换为TagChangeEvent,
+// 成员和部门列表以逗号分隔
+func (e *ContactEvent) TagChange() *TagChangeEvent {
+	ev := &TagChangeEvent{
+		TagID:        e.TagID,
+		AddUserItems: splitItems(e.AddUserItems),
+		DelUserItems: splitItems(e.DelUserItems),
+	}
+	for _, s := range splitItems(e.AddPartyItems) {
+		if id, err := strconv.Atoi(s); err == nil {
+			ev.AddPartyItems = append(ev.AddPartyItems, id)
+		}
+	}
+	for _, s := range splitItems(e.DelPartyItems) {
+		if id, err := strconv.Atoi(s); err == nil {
+			ev.DelPartyItems = append(ev.DelPartyItems, id)
+		}
+	}
+	return ev
+}
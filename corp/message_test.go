@@ -0,0 +1,67 @@
+package corp
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+func TestMessage_Unmarshal(t *testing.T) {
+	header := MsgHeader{ToUserName: "wx5823bf96d3bd56c7", FromUserName: "zhangsan", CreateTime: 1348831860, AgentID: "1"}
+	withType := func(typ string) MsgHeader {
+		h := header
+		h.MsgType = typ
+		return h
+	}
+	const head = `<ToUserName><![CDATA[wx5823bf96d3bd56c7]]></ToUserName><FromUserName><![CDATA[zhangsan]]></FromUserName><CreateTime>1348831860</CreateTime><AgentID>1</AgentID>`
+
+	tests := []struct {
+		name string
+		data string
+		got  interface{}
+		want interface{}
+	}{
+		{
+			name: "text",
+			data: `<xml>` + head + `<MsgType><![CDATA[text]]></MsgType><Content><![CDATA[hello]]></Content><MsgId>1234567890123456</MsgId></xml>`,
+			got:  new(TextMessage),
+			want: &TextMessage{MsgHeader: withType("text"), MsgID: 1234567890123456, Content: "hello"},
+		},
+		{
+			name: "location",
+			data: `<xml>` + head + `<MsgType><![CDATA[location]]></MsgType><Location_X>23.134</Location_X><Location_Y>113.358</Location_Y><Scale>20</Scale><Label><![CDATA[位置]]></Label><MsgId>1</MsgId></xml>`,
+			got:  new(LocationMessage),
+			want: &LocationMessage{MsgHeader: withType("location"), MsgID: 1, LocationX: 23.134, LocationY: 113.358, Scale: 20, Label: "位置"},
+		},
+		{
+			name: "scancode",
+			data: `<xml>` + head + `<MsgType><![CDATA[event]]></MsgType><Event><![CDATA[scancode_push]]></Event><EventKey><![CDATA[6]]></EventKey><ScanCodeInfo><ScanType><![CDATA[qrcode]]></ScanType><ScanResult><![CDATA[1]]></ScanResult></ScanCodeInfo></xml>`,
+			got:  new(ScanCodeEvent),
+			want: &ScanCodeEvent{EventMessage: EventMessage{MsgHeader: withType("event"), Event: "scancode_push", EventKey: "6"}, ScanCodeInfo: ScanCodeInfo{ScanType: "qrcode", ScanResult: "1"}},
+		},
+		{
+			name: "batch_job_result",
+			data: `<xml>` + head + `<MsgType><![CDATA[event]]></MsgType><Event><![CDATA[batch_job_result]]></Event><BatchJob><JobId><![CDATA[S0Mrnndv]]></JobId><JobType><![CDATA[sync_user]]></JobType><ErrCode>0</ErrCode><ErrMsg><![CDATA[ok]]></ErrMsg></BatchJob></xml>`,
+			got:  new(BatchJobResultEvent),
+			want: &BatchJobResultEvent{EventMessage: EventMessage{MsgHeader: withType("event"), Event: "batch_job_result"}, BatchJob: BatchJobEvent{JobID: "S0Mrnndv", JobType: "sync_user", ErrMsg: "ok"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := xml.Unmarshal([]byte(tt.data), tt.got); err != nil {
+				t.Fatalf("xml.Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("xml.Unmarshal() = %+v, want %+v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContactEvent_TagChange(t *testing.T) {
+	e := &ContactEvent{TagID: 1, AddUserItems: "zhangsan,lisi", AddPartyItems: "1,2", DelPartyItems: "3"}
+	want := &TagChangeEvent{TagID: 1, AddUserItems: []string{"zhangsan", "lisi"}, AddPartyItems: []int{1, 2}, DelPartyItems: []int{3}}
+	if got := e.TagChange(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ContactEvent.TagChange() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,298 @@
+package corp
+
+import (
+	"strings"
+	"time"
+)
+
+// MsgOption 消息选项, 用于设置接收人和保密、id转译、重复消息检查等
+type MsgOption func(*Msg)
+
+// WithUsers 添加接收成员, 与已有的成员合并去重; ToUser为"@all"时忽略
+func WithUsers(ids ...string) MsgOption {
+	return func(msg *Msg) {
+		if msg.ToUser == "@all" {
+			return
+		}
+		msg.ToUser = joinIDs(msg.ToUser, ids)
+	}
+}
+
+// WithAllUsers 发送给应用可见范围内的全部成员
+func WithAllUsers() MsgOption {
+	return func(msg *Msg) {
+		msg.ToUser = "@all"
+	}
+}
+
+// WithParties 添加接收部门, 与已有的部门合并去重
+func WithParties(ids ...string) MsgOption {
+	return func(msg *Msg) {
+		msg.ToParty = joinIDs(msg.ToParty, ids)
+	}
+}
+
+// WithTags 添加接收标签, 与已有的标签合并去重
+func WithTags(ids ...string) MsgOption {
+	return func(msg *Msg) {
+		msg.ToTag = joinIDs(msg.ToTag, ids)
+	}
+}
+
+// WithSafe 发送保密消息
+func WithSafe() MsgOption {
+	return func(msg *Msg) {
+		msg.Safe = 1
+	}
+}
+
+// WithIDTrans 开启id转译
+func WithIDTrans() MsgOption {
+	return func(msg *Msg) {
+		msg.EnableIDTrans = 1
+	}
+}
+
+// WithDuplicateCheck 开启重复消息检查, interval为检查的时间间隔, 精确到秒, 为0时使用默认的1800秒
+func WithDuplicateCheck(interval time.Duration) MsgOption {
+	return func(msg *Msg) {
+		msg.EnableDuplicateCheck = 1
+		msg.DuplicateCheckInterval = int(interval / time.Second)
+	}
+}
+
+// joinIDs 把ids合并到使用"|"分割的s中, 删除空白和重复的id
+func joinIDs(s string, ids []string) string {
+	all := splitIDs(s)
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			all = append(all, id)
+		}
+	}
+	return strings.Join(RemoveDuplicateString(all), "|")
+}
+
+// setContent 设置消息类型, 并清除其他类型的消息内容
+func (msg *Msg) setContent(typ string, set func(*Msg)) {
+	msg.MsgType = typ
+	msg.Text, msg.Image, msg.Voice, msg.Video, msg.File = nil, nil, nil, nil, nil
+	msg.TextCard, msg.News, msg.MpNews, msg.Markdown = nil, nil, nil, nil
+	msg.MiniprogramNotice, msg.TemplateCard, msg.InteractiveTaskCard = nil, nil, nil
+	set(msg)
+}
+
+// newMsg 新建消息并应用选项
+func newMsg(agentID int, typ string, set func(*Msg), opts []MsgOption) *Msg {
+	msg := &Msg{AgentID: agentID}
+	msg.setContent(typ, set)
+	for _, opt := range opts {
+		opt(msg)
+	}
+	return msg
+}
+
+// NewTextMessage 新建文本消息
+func NewTextMessage(agentID int, content string, opts ...MsgOption) *Msg {
+	return newMsg(agentID, "text", func(msg *Msg) { msg.Text = &TextMsg{Content: content} }, opts)
+}
+
+// NewImageMessage 新建图片消息
+func NewImageMessage(agentID int, mediaID string, opts ...MsgOption) *Msg {
+	return newMsg(agentID, "image", func(msg *Msg) { msg.Image = &MediaMsg{MediaID: mediaID} }, opts)
+}
+
+// NewVoiceMessage 新建语音消息
+func NewVoiceMessage(agentID int, mediaID string, opts ...MsgOption) *Msg {
+	return newMsg(agentID, "voice", func(msg *Msg) { msg.Voice = &MediaMsg{MediaID: mediaID} }, opts)
+}
+
+// NewVideoMessage 新建视频消息
+func NewVideoMessage(agentID int, mediaID, title, desc string, opts ...MsgOption) *Msg {
+	return newMsg(agentID, "video", func(msg *Msg) {
+		msg.Video = &MediaMsg{MediaID: mediaID, Title: title, Desc: desc}
+	}, opts)
+}
+
+// NewFileMessage 新建文件消息
+func NewFileMessage(agentID int, mediaID string, opts ...MsgOption) *Msg {
+	return newMsg(agentID, "file", func(msg *Msg) { msg.File = &MediaMsg{MediaID: mediaID} }, opts)
+}
+
+// NewTextCardMessage 新建文本卡片消息
+func NewTextCardMessage(agentID int, title, desc, url, btntxt string, opts ...MsgOption) *Msg {
+	return newMsg(agentID, "textcard", func(msg *Msg) {
+		msg.TextCard = &TextCardMsg{Title: title, Desc: desc, URL: url, Btntxt: btntxt}
+	}, opts)
+}
+
+// NewNewsMessage 新建图文消息
+func NewNewsMessage(agentID int, articles []NewsItem, opts ...MsgOption) *Msg {
+	return newMsg(agentID, "news", func(msg *Msg) { msg.News = &NewsMsg{Articles: articles} }, opts)
+}
+
+// NewMpNewsMessage 新建mpnews图文消息
+func NewMpNewsMessage(agentID int, articles []MpNewsItem, opts ...MsgOption) *Msg {
+	return newMsg(agentID, "mpnews", func(msg *Msg) { msg.MpNews = &MpNewsMsg{Articles: articles} }, opts)
+}
+
+// NewMarkdownMessage 新建markdown消息
+func NewMarkdownMessage(agentID int, content string, opts ...MsgOption) *Msg {
+	return newMsg(agentID, "markdown", func(msg *Msg) { msg.Markdown = &MarkdownMsg{Content: content} }, opts)
+}
+
+// NewMiniprogramNoticeMessage 新建小程序通知消息, 小程序通知消息不需要agentid
+func NewMiniprogramNoticeMessage(notice *MiniprogramNoticeMsg, opts ...MsgOption) *Msg {
+	return newMsg(0, "miniprogram_notice", func(msg *Msg) { msg.MiniprogramNotice = notice }, opts)
+}
+
+// NewTemplateCardMessage 新建模板卡片消息
+func NewTemplateCardMessage(agentID int, card *TemplateCardMsg, opts ...MsgOption) *Msg {
+	return newMsg(agentID, "template_card", func(msg *Msg) { msg.TemplateCard = card }, opts)
+}
+
+// NewInteractiveTaskCardMessage 新建任务卡片消息
+func NewInteractiveTaskCardMessage(agentID int, card *InteractiveTaskCardMsg, opts ...MsgOption) *Msg {
+	return newMsg(agentID, "interactive_taskcard", func(msg *Msg) { msg.InteractiveTaskCard = card }, opts)
+}
+
+// MsgBuilder 消息构造器, 根据设置的内容自动填写消息类型, 接收人使用"|"拼接并去重, 例如:
+//
+//	msg, err := corp.NewMessage(agentID).ToUsers("u1", "u2").ToParties("1").Markdown("**hello**").Build()
+//
+// 多次设置内容时以最后一次为准
+type MsgBuilder struct {
+	msg Msg
+}
+
+// NewMessage 新建应用agentID的消息构造器
+func NewMessage(agentID int) *MsgBuilder {
+	return &MsgBuilder{msg: Msg{AgentID: agentID}}
+}
+
+// With 应用消息选项
+func (b *MsgBuilder) With(opts ...MsgOption) *MsgBuilder {
+	for _, opt := range opts {
+		opt(&b.msg)
+	}
+	return b
+}
+
+// ToUsers 添加接收成员
+func (b *MsgBuilder) ToUsers(ids ...string) *MsgBuilder {
+	return b.With(WithUsers(ids...))
+}
+
+// ToAll 发送给全部成员
+func (b *MsgBuilder) ToAll() *MsgBuilder {
+	return b.With(WithAllUsers())
+}
+
+// ToParties 添加接收部门
+func (b *MsgBuilder) ToParties(ids ...string) *MsgBuilder {
+	return b.With(WithParties(ids...))
+}
+
+// ToTags 添加接收标签
+func (b *MsgBuilder) ToTags(ids ...string) *MsgBuilder {
+	return b.With(WithTags(ids...))
+}
+
+// Safe 发送保密消息
+func (b *MsgBuilder) Safe() *MsgBuilder {
+	return b.With(WithSafe())
+}
+
+// IDTrans 开启id转译
+func (b *MsgBuilder) IDTrans() *MsgBuilder {
+	return b.With(WithIDTrans())
+}
+
+// DuplicateCheck 开启重复消息检查
+func (b *MsgBuilder) DuplicateCheck(interval time.Duration) *MsgBuilder {
+	return b.With(WithDuplicateCheck(interval))
+}
+
+// Text 文本消息
+func (b *MsgBuilder) Text(content string) *MsgBuilder {
+	b.msg.setContent("text", func(msg *Msg) { msg.Text = &TextMsg{Content: content} })
+	return b
+}
+
+// Image 图片消息
+func (b *MsgBuilder) Image(mediaID string) *MsgBuilder {
+	b.msg.setContent("image", func(msg *Msg) { msg.Image = &MediaMsg{MediaID: mediaID} })
+	return b
+}
+
+// Voice 语音消息
+func (b *MsgBuilder) Voice(mediaID string) *MsgBuilder {
+	b.msg.setContent("voice", func(msg *Msg) { msg.Voice = &MediaMsg{MediaID: mediaID} })
+	return b
+}
+
+// Video 视频消息
+func (b *MsgBuilder) Video(mediaID, title, desc string) *MsgBuilder {
+	b.msg.setContent("video", func(msg *Msg) {
+		msg.Video = &MediaMsg{MediaID: mediaID, Title: title, Desc: desc}
+	})
+	return b
+}
+
+// File 文件消息
+func (b *MsgBuilder) File(mediaID string) *MsgBuilder {
+	b.msg.setContent("file", func(msg *Msg) { msg.File = &MediaMsg{MediaID: mediaID} })
+	return b
+}
+
+// TextCard 文本卡片消息
+func (b *MsgBuilder) TextCard(title, desc, url, btntxt string) *MsgBuilder {
+	b.msg.setContent("textcard", func(msg *Msg) {
+		msg.TextCard = &TextCardMsg{Title: title, Desc: desc, URL: url, Btntxt: btntxt}
+	})
+	return b
+}
+
+// News 图文消息
+func (b *MsgBuilder) News(articles ...NewsItem) *MsgBuilder {
+	b.msg.setContent("news", func(msg *Msg) { msg.News = &NewsMsg{Articles: articles} })
+	return b
+}
+
+// MpNews mpnews图文消息
+func (b *MsgBuilder) MpNews(articles ...MpNewsItem) *MsgBuilder {
+	b.msg.setContent("mpnews", func(msg *Msg) { msg.MpNews = &MpNewsMsg{Articles: articles} })
+	return b
+}
+
+// Markdown markdown消息
+func (b *MsgBuilder) Markdown(content string) *MsgBuilder {
+	b.msg.setContent("markdown", func(msg *Msg) { msg.Markdown = &MarkdownMsg{Content: content} })
+	return b
+}
+
+// MiniprogramNotice 小程序通知消息
+func (b *MsgBuilder) MiniprogramNotice(notice *MiniprogramNoticeMsg) *MsgBuilder {
+	b.msg.setContent("miniprogram_notice", func(msg *Msg) { msg.MiniprogramNotice = notice })
+	return b
+}
+
+// TemplateCard 模板卡片消息
+func (b *MsgBuilder) TemplateCard(card *TemplateCardMsg) *MsgBuilder {
+	b.msg.setContent("template_card", func(msg *Msg) { msg.TemplateCard = card })
+	return b
+}
+
+// InteractiveTaskCard 任务卡片消息
+func (b *MsgBuilder) InteractiveTaskCard(card *InteractiveTaskCardMsg) *MsgBuilder {
+	b.msg.setContent("interactive_taskcard", func(msg *Msg) { msg.InteractiveTaskCard = card })
+	return b
+}
+
+// Build 验证并返回消息, 每次调用返回新的Msg
+func (b *MsgBuilder) Build() (*Msg, error) {
+	msg := b.msg
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
@@ -0,0 +1,132 @@
+package corp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *MsgBuilder
+		want    *Msg
+		wantErr bool
+	}{
+		{
+			name:    "markdown",
+			builder: NewMessage(1).ToUsers("u1", " u2", "").ToUsers("u1", "u3").ToParties("1", "2").ToTags("3").Markdown("**hi**"),
+			want: &Msg{
+				ToUser: "u1|u2|u3", ToParty: "1|2", ToTag: "3", AgentID: 1,
+				MsgType: "markdown", Markdown: &MarkdownMsg{Content: "**hi**"},
+			},
+		},
+		{
+			name:    "options",
+			builder: NewMessage(1).ToAll().ToUsers("u1").Safe().IDTrans().DuplicateCheck(time.Hour).Text("hi"),
+			want: &Msg{
+				ToUser: "@all", AgentID: 1, Safe: 1, EnableIDTrans: 1,
+				EnableDuplicateCheck: 1, DuplicateCheckInterval: 3600,
+				MsgType: "text", Text: &TextMsg{Content: "hi"},
+			},
+		},
+		{
+			name:    "replace content",
+			builder: NewMessage(1).ToUsers("u1").Text("hi").File("media"),
+			want: &Msg{
+				ToUser: "u1", AgentID: 1, MsgType: "file", File: &MediaMsg{MediaID: "media"},
+			},
+		},
+		{
+			name:    "no recipients",
+			builder: NewMessage(1).Text("hi"),
+			wantErr: true,
+		},
+		{
+			name:    "no content",
+			builder: NewMessage(1).ToUsers("u1"),
+			wantErr: true,
+		},
+		{
+			name:    "duplicate check interval",
+			builder: NewMessage(1).ToUsers("u1").DuplicateCheck(5 * time.Hour).Text("hi"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.builder.Build()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MsgBuilder.Build() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MsgBuilder.Build() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMessage_Types(t *testing.T) {
+	card := &TemplateCardMsg{
+		CardType:   TemplateCardTextNotice,
+		MainTitle:  &CardMainTitle{Title: "title"},
+		CardAction: &CardAction{Type: 1, URL: "https://example.com"},
+	}
+	task := &InteractiveTaskCardMsg{
+		Title: "title", Desc: "desc", TaskID: "task",
+		Btn: []TaskCardButton{{Key: "ok", Name: "ok"}},
+	}
+	notice := &MiniprogramNoticeMsg{AppID: "appid", Title: "会议室预订成功"}
+	to := WithUsers("u1")
+	tests := []struct {
+		name    string
+		msg     *Msg
+		builder *MsgBuilder
+		typ     string
+	}{
+		{"text", NewTextMessage(1, "hi", to), NewMessage(1).Text("hi"), "text"},
+		{"image", NewImageMessage(1, "media", to), NewMessage(1).Image("media"), "image"},
+		{"voice", NewVoiceMessage(1, "media", to), NewMessage(1).Voice("media"), "voice"},
+		{"video", NewVideoMessage(1, "media", "title", "desc", to), NewMessage(1).Video("media", "title", "desc"), "video"},
+		{"file", NewFileMessage(1, "media", to), NewMessage(1).File("media"), "file"},
+		{
+			"textcard",
+			NewTextCardMessage(1, "title", "desc", "https://example.com", "more", to),
+			NewMessage(1).TextCard("title", "desc", "https://example.com", "more"),
+			"textcard",
+		},
+		{
+			"news",
+			NewNewsMessage(1, []NewsItem{{Title: "title", URL: "https://example.com"}}, to),
+			NewMessage(1).News(NewsItem{Title: "title", URL: "https://example.com"}),
+			"news",
+		},
+		{
+			"mpnews",
+			NewMpNewsMessage(1, []MpNewsItem{{Title: "title", ThumbMediaID: "media", Content: "content"}}, to),
+			NewMessage(1).MpNews(MpNewsItem{Title: "title", ThumbMediaID: "media", Content: "content"}),
+			"mpnews",
+		},
+		{"markdown", NewMarkdownMessage(1, "hi", to), NewMessage(1).Markdown("hi"), "markdown"},
+		{"miniprogram_notice", NewMiniprogramNoticeMessage(notice, to), NewMessage(0).MiniprogramNotice(notice), "miniprogram_notice"},
+		{"template_card", NewTemplateCardMessage(1, card, to), NewMessage(1).TemplateCard(card), "template_card"},
+		{"interactive_taskcard", NewInteractiveTaskCardMessage(1, task, to), NewMessage(1).InteractiveTaskCard(task), "interactive_taskcard"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.msg.MsgType != tt.typ {
+				t.Errorf("MsgType = %s, want %s", tt.msg.MsgType, tt.typ)
+			}
+			if err := tt.msg.Validate(); err != nil {
+				t.Errorf("Msg.Validate() error = %v", err)
+			}
+			got, err := tt.builder.With(to).Build()
+			if err != nil {
+				t.Fatalf("MsgBuilder.Build() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Errorf("MsgBuilder.Build() = %+v, want %+v", got, tt.msg)
+			}
+		})
+	}
+}
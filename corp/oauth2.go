@@ -1,39 +1,41 @@
 package corp
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/url"
+	"context"
+	"net/http"
 
 	"github.com/qingtao/wxcorp/corp/errcode"
 )
 
 const (
-	defaultOAuth2AuthorizeURL   = "https://open.weixin.qq.com/connect/oauth2/authorize"
-	defaultOAuth2GetUserInfoURL = "https://qyapi.weixin.qq.com/cgi-bin/user/getuserinfo"
+	defaultOAuth2AuthorizeURL = "https://open.weixin.qq.com/connect/oauth2/authorize"
+	oAuth2GetUserInfoPath     = "/cgi-bin/user/getuserinfo"
 )
 
-// NewOAuth2RedirectURL 新建网页授权跳转的URL
+// NewOAuth2RedirectURL 新建网页授权跳转的URL, 授权页面由用户的浏览器打开, 不受SetAPIBaseURL影响
 func NewOAuth2RedirectURL(wxurl, appid, returnTo, targetURI, state string) string {
 	if wxurl == "" {
 		wxurl = defaultOAuth2AuthorizeURL
 	}
 	// 添加返回的地址
 	if returnTo != "" {
-		targetURI = fmt.Sprintf("%s?return_to=%s", targetURI, url.QueryEscape(returnTo))
+		targetURI = buildURL(targetURI, "return_to", returnTo)
 	}
-	targetURI = url.QueryEscape(targetURI)
-
-	return fmt.Sprintf("%s?appid=%s&redirect_uri=%s&response_type=code&scope=snsapi_base&state=%s#wechat_redirect", wxurl, appid, targetURI, state)
+	return buildURL(wxurl,
+		"appid", appid,
+		"redirect_uri", targetURI,
+		"response_type", "code",
+		"scope", "snsapi_base",
+		"state", state,
+	) + "#wechat_redirect"
 }
 
 // NewGetUserInfoURL 新建请求用户信息(userid)的URL
 func NewGetUserInfoURL(wxurl, accessToken, code string) string {
 	if wxurl == "" {
-		wxurl = defaultOAuth2GetUserInfoURL
+		wxurl = apiURL(oAuth2GetUserInfoPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s&code=%s", wxurl, accessToken, code)
+	return buildURL(wxurl, "access_token", accessToken, "code", code)
 }
 
 // UserInfoResponse 用户信息返回结构
@@ -49,29 +51,18 @@ func (res *UserInfoResponse) Validate() error {
 	if res == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(res.ErrCode)
+	return errcode.New(res.ErrCode, res.ErrMsg)
 }
 
 // GetUserInfoWithCode 获取用户基本信息, code为用户的授权码
 func GetUserInfoWithCode(url, accessToken, code string) (res *UserInfoResponse, err error) {
+	return DefaultClient.GetUserInfoWithCode(context.Background(), url, accessToken, code)
+}
+
+// GetUserInfoWithCode 获取用户基本信息, code为用户的授权码
+func (c *Client) GetUserInfoWithCode(ctx context.Context, url, accessToken, code string) (res *UserInfoResponse, err error) {
 	if accessToken == "" {
 		return nil, errcode.ErrInvalidAccessToken
 	}
-	url = NewGetUserInfoURL(url, accessToken, code)
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
-	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	if err = json.Unmarshal(b, &res); err != nil {
-		return nil, err
-	}
-	err = res.Validate()
-	return
+	return execute[UserInfoResponse](ctx, c, http.MethodGet, NewGetUserInfoURL(url, accessToken, code), nil)
 }
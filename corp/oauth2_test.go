@@ -36,6 +36,11 @@ func TestNewOAuth2RedirectURL(t *testing.T) {
 			args: argsOk,
 			want: `https://open.weixin.qq.com/connect/oauth2/authorize?appid=1002&redirect_uri=http%3A%2F%2Fb.b.com%3Freturn_to%3Dhttp%253A%252F%252Fa.b.com&response_type=code&scope=snsapi_base&state=123456#wechat_redirect`,
 		},
+		{
+			name: "escape",
+			args: args{appid: "1002", returnTo: "/a?b=1&c=2", targetURI: "http://b.b.com/?x=1", state: "a&b c"},
+			want: `https://open.weixin.qq.com/connect/oauth2/authorize?appid=1002&redirect_uri=http%3A%2F%2Fb.b.com%2F%3Fx%3D1%26return_to%3D%252Fa%253Fb%253D1%2526c%253D2&response_type=code&scope=snsapi_base&state=a%26b+c#wechat_redirect`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -68,6 +73,11 @@ func TestNewGetUserInfoURL(t *testing.T) {
 			args: argsOk,
 			want: "https://qyapi.weixin.qq.com/cgi-bin/user/getuserinfo?access_token=a&code=123456",
 		},
+		{
+			name: "escape",
+			args: args{"", "a", "x&y+z/中"},
+			want: "https://qyapi.weixin.qq.com/cgi-bin/user/getuserinfo?access_token=a&code=x%26y%2Bz%2F%E4%B8%AD",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
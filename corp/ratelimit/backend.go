@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Rule 滑动窗口的频率限制, Window时间内最多Limit次
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Backend 滑动窗口计数器的存储, 多个进程共享同一个企业的频率限制时可以使用redis等共享存储实现
+type Backend interface {
+	// Take 在key的窗口内记录n次调用. 记录后所有规则都不超过限制时返回0,
+	// 否则不记录并返回需要等待的时间
+	Take(ctx context.Context, key string, n int, rules []Rule) (wait time.Duration, err error)
+}
+
+// Releaser 可以回滚计数的Backend, Limiter在多个计数器中依次记录时,
+// 后面的计数器超过限制则回滚前面已经记录的次数; 未实现时不回滚
+type Releaser interface {
+	// Release 删除key最近记录的n次调用
+	Release(ctx context.Context, key string, n int) error
+}
+
+// ErrExceedsRule 单次调用的次数超过了规则的限制, 等待也无法满足
+var ErrExceedsRule = errors.New("调用次数超过频率限制的上限")
+
+// sweepInterval 清理内存中过期窗口的间隔
+const sweepInterval = time.Minute
+
+// MemoryBackend 内存中的滑动窗口计数器, 仅在当前进程内有效
+type MemoryBackend struct {
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+	// now 当前时间, 测试时替换
+	now func() time.Time
+}
+
+// NewMemoryBackend 新建内存计数器
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		windows: make(map[string]*window),
+		now:     time.Now,
+	}
+}
+
+// Take 在key的窗口内记录n次调用
+func (b *MemoryBackend) Take(_ context.Context, key string, n int, rules []Rule) (time.Duration, error) {
+	now := b.now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sweep(now)
+
+	w, ok := b.windows[key]
+	if !ok {
+		w = new(window)
+		b.windows[key] = w
+	}
+	var wait time.Duration
+	for _, r := range rules {
+		if n > r.Limit {
+			return 0, ErrExceedsRule
+		}
+		if r.Window > w.span {
+			w.span = r.Window
+		}
+	}
+	w.prune(now)
+	for _, r := range rules {
+		if d := w.wait(now, n, r); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return wait, nil
+	}
+	w.add(now, n)
+	return 0, nil
+}
+
+// Release 删除key最近记录的n次调用
+func (b *MemoryBackend) Release(_ context.Context, key string, n int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if w, ok := b.windows[key]; ok {
+		w.remove(n)
+	}
+	return nil
+}
+
+// sweep 定期删除所有记录都已过期的窗口
+func (b *MemoryBackend) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < sweepInterval {
+		return
+	}
+	b.lastSweep = now
+	for key, w := range b.windows {
+		if w.prune(now); len(w.entries) == 0 {
+			delete(b.windows, key)
+		}
+	}
+}
+
+// entry 一次调用的记录
+type entry struct {
+	at time.Time
+	n  int
+}
+
+// window 一个key的调用记录, 按时间顺序保存最长规则窗口内的记录
+type window struct {
+	entries []entry
+	span    time.Duration
+}
+
+// prune 删除超过最长窗口的记录
+func (w *window) prune(now time.Time) {
+	i := 0
+	for i < len(w.entries) && now.Sub(w.entries[i].at) >= w.span {
+		i++
+	}
+	if i > 0 {
+		w.entries = append(w.entries[:0], w.entries[i:]...)
+	}
+}
+
+// add 记录n次调用
+func (w *window) add(now time.Time, n int) {
+	if last := len(w.entries) - 1; last >= 0 && w.entries[last].at.Equal(now) {
+		w.entries[last].n += n
+		return
+	}
+	w.entries = append(w.entries, entry{at: now, n: n})
+}
+
+// remove 从最近的记录开始删除n次调用
+func (w *window) remove(n int) {
+	for i := len(w.entries) - 1; i >= 0 && n > 0; i-- {
+		if w.entries[i].n > n {
+			w.entries[i].n -= n
+			return
+		}
+		n -= w.entries[i].n
+		w.entries = w.entries[:i]
+	}
+}
+
+// wait 按照规则r再调用n次需要等待的时间
+func (w *window) wait(now time.Time, n int, r Rule) time.Duration {
+	var (
+		total int
+		first = len(w.entries)
+	)
+	for i := len(w.entries) - 1; i >= 0 && now.Sub(w.entries[i].at) < r.Window; i-- {
+		total += w.entries[i].n
+		first = i
+	}
+	excess := total + n - r.Limit
+	if excess <= 0 {
+		return 0
+	}
+	// 等待最早的记录移出窗口, 直到腾出足够的次数
+	for _, e := range w.entries[first:] {
+		if excess -= e.n; excess <= 0 {
+			return e.at.Add(r.Window).Sub(now)
+		}
+	}
+	return r.Window
+}
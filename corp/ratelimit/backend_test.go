@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryBackend_Take(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	b := NewMemoryBackend()
+	b.now = func() time.Time { return now }
+	rules := []Rule{
+		{Limit: 3, Window: time.Minute},
+		{Limit: 5, Window: time.Hour},
+	}
+	take := func(n int) time.Duration {
+		t.Helper()
+		wait, err := b.Take(context.Background(), "key", n, rules)
+		if err != nil {
+			t.Fatalf("MemoryBackend.Take() error = %v", err)
+		}
+		return wait
+	}
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		n       int
+		want    time.Duration
+	}{
+		{name: "first", n: 2, want: 0},
+		{name: "third", advance: 10 * time.Second, n: 1, want: 0},
+		{name: "minute limit", advance: 10 * time.Second, n: 1, want: 40 * time.Second},
+		{name: "minute limit 2", n: 2, want: 40 * time.Second},
+		{name: "after a minute", advance: 40 * time.Second, n: 2, want: 0},
+		{name: "hour limit", advance: time.Minute, n: 1, want: 58 * time.Minute},
+		{name: "after an hour", advance: 58 * time.Minute, n: 2, want: 0},
+	}
+	for _, tt := range tests {
+		now = now.Add(tt.advance)
+		if got := take(tt.n); got != tt.want {
+			t.Errorf("%s: MemoryBackend.Take() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := b.Take(context.Background(), "key", 4, rules); err != ErrExceedsRule {
+		t.Errorf("MemoryBackend.Take() error = %v, want %v", err, ErrExceedsRule)
+	}
+	if wait := take(0); wait != 0 {
+		t.Errorf("MemoryBackend.Take(0) = %v", wait)
+	}
+
+	now = now.Add(2 * time.Hour)
+	b.Take(context.Background(), "other", 1, rules)
+	if _, ok := b.windows["key"]; ok || len(b.windows) != 1 {
+		t.Errorf("MemoryBackend.sweep() windows = %v", b.windows)
+	}
+}
+
+func TestMemoryBackend_Release(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	rules := []Rule{{Limit: 3, Window: time.Minute}}
+	b.Take(ctx, "key", 1, rules)
+	b.Take(ctx, "key", 2, rules)
+	if wait, _ := b.Take(ctx, "key", 1, rules); wait <= 0 {
+		t.Fatalf("MemoryBackend.Take() wait = %v, want > 0", wait)
+	}
+	// 从最近的记录开始删除, 可以跨越多条记录
+	b.Release(ctx, "key", 2)
+	if wait, _ := b.Take(ctx, "key", 2, rules); wait != 0 {
+		t.Errorf("MemoryBackend.Take() after release wait = %v, want 0", wait)
+	}
+	b.Release(ctx, "key", 3)
+	if wait, _ := b.Take(ctx, "key", 3, rules); wait != 0 {
+		t.Errorf("MemoryBackend.Take() after release all wait = %v, want 0", wait)
+	}
+	if err := b.Release(ctx, "missing", 1); err != nil {
+		t.Errorf("MemoryBackend.Release() error = %v", err)
+	}
+}
@@ -1,4 +1,5 @@
-// Package ratelimit 描述了微信企业号接口相关的访问频率限制
+// Package ratelimit 描述了微信企业号接口相关的访问频率限制,
+// Limiter根据这些限制在客户端按滑动窗口计数, 避免超过限制后被企业微信屏蔽
 package ratelimit
 
 // 所有频率，按天拦截则被屏蔽一天（自然天），按月拦截则屏蔽一个月（30天，非自然月），按分钟拦截则被屏蔽60秒，按小时拦截则被屏蔽60分钟
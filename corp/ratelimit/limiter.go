@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLimited 超过频率限制, 使用WithFailFast时返回, 可以使用errors.As取得LimitError
+var ErrLimited = errors.New("超过频率限制")
+
+// LimitError 超过频率限制的错误
+type LimitError struct {
+	// Key 超过限制的计数器
+	Key string
+	// RetryAfter 需要等待的时间
+	RetryAfter time.Duration
+}
+
+// Error 实现error接口
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s, %v后重试", ErrLimited, e.Key, e.RetryAfter)
+}
+
+// Is 与ErrLimited视为同一个错误
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimited
+}
+
+var (
+	// apiRules 每企业调用单个cgi/api的频率
+	apiRules = []Rule{
+		{Limit: TimesCgiAPIOneCorpOneMinute, Window: time.Minute},
+		{Limit: TimesCgiAPIOneCorpOneHour, Window: time.Hour},
+	}
+	// sendMsgUserRules 每应用对同一个成员发送消息的频率
+	sendMsgUserRules = []Rule{
+		{Limit: BaseTimesSendMsgOneAppUserMinute, Window: time.Minute},
+	}
+	// jsAPITicketCorpRules 每企业获取jsapi_ticket的频率
+	jsAPITicketCorpRules = []Rule{
+		{Limit: TimesJsAPITicketOneCorpHour, Window: time.Hour},
+	}
+	// jsAPITicketAppRules 每应用获取jsapi_ticket的频率
+	jsAPITicketAppRules = []Rule{
+		{Limit: TimesJsAPITicketOneCorpAppHour, Window: time.Hour},
+	}
+)
+
+// Limiter 企业微信接口的客户端频率限制, 按企业、接口、应用和成员分别计数,
+// 超过限制时默认等待, 使用WithFailFast时立即返回LimitError
+type Limiter struct {
+	corpID      string
+	backend     Backend
+	failFast    bool
+	maxAccounts int
+}
+
+// Option 频率限制选项
+type Option func(*Limiter)
+
+// WithBackend 使用指定的计数器存储, 为nil时忽略, 默认使用内存存储
+func WithBackend(b Backend) Option {
+	return func(l *Limiter) {
+		if b != nil {
+			l.backend = b
+		}
+	}
+}
+
+// WithFailFast 超过限制时不等待, 立即返回LimitError
+func WithFailFast() Option {
+	return func(l *Limiter) {
+		l.failFast = true
+	}
+}
+
+// WithMaxAccounts 企业的帐号上限数, 大于0时限制每天发送消息的人次不超过帐号上限数*30
+func WithMaxAccounts(n int) Option {
+	return func(l *Limiter) {
+		l.maxAccounts = n
+	}
+}
+
+// New 新建企业corpID的频率限制
+func New(corpID string, opts ...Option) *Limiter {
+	l := &Limiter{corpID: corpID}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.backend == nil {
+		l.backend = NewMemoryBackend()
+	}
+	return l
+}
+
+// Take 在计数器key中记录n次调用, key会加上企业ID作为前缀;
+// 超过任意规则时等待到ctx结束, 使用WithFailFast时返回LimitError
+func (l *Limiter) Take(ctx context.Context, key string, n int, rules ...Rule) error {
+	if n <= 0 || len(rules) == 0 {
+		return nil
+	}
+	key = l.corpID + ":" + key
+	for {
+		wait, err := l.backend.Take(ctx, key, n, rules)
+		if err != nil || wait <= 0 {
+			return err
+		}
+		if l.failFast {
+			return &LimitError{Key: key, RetryAfter: wait}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take 一个计数器的记录
+type take struct {
+	key   string
+	n     int
+	rules []Rule
+}
+
+// takeAll 依次在多个计数器中记录, 任意一个返回错误时回滚前面已经记录的计数器
+func (l *Limiter) takeAll(ctx context.Context, takes []take) error {
+	for i, t := range takes {
+		if err := l.Take(ctx, t.key, t.n, t.rules...); err != nil {
+			l.release(takes[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// release 回滚已经记录的计数器, Backend未实现Releaser时忽略; 回滚失败时多占用的次数在窗口结束后释放
+func (l *Limiter) release(takes []take) {
+	r, ok := l.backend.(Releaser)
+	if !ok {
+		return
+	}
+	for _, t := range takes {
+		if t.n > 0 && len(t.rules) > 0 {
+			r.Release(context.Background(), l.corpID+":"+t.key, t.n)
+		}
+	}
+}
+
+// API 调用接口前检查每企业调用单个接口的频率, api为相对于接口地址的路径, 例如"/cgi-bin/user/get"
+func (l *Limiter) API(ctx context.Context, api string) error {
+	return l.Take(ctx, "api:"+api, 1, apiRules...)
+}
+
+// SendMsg 发送消息前检查应用对每个成员发送消息的频率, 设置了WithMaxAccounts时同时检查企业每天发送的人次.
+// 无法得知部门和标签包含的成员, 只计算users中的成员.
+// 返回错误时回滚已经记录的人次和成员, 需要Backend实现Releaser
+func (l *Limiter) SendMsg(ctx context.Context, agentID string, users []string) error {
+	takes := make([]take, 0, len(users)+1)
+	if quota, ok := l.sendMsgQuota(len(users)); ok {
+		takes = append(takes, quota)
+	}
+	for _, user := range users {
+		takes = append(takes, take{key: sendMsgUserKey(agentID, user), n: 1, rules: sendMsgUserRules})
+	}
+	return l.takeAll(ctx, takes)
+}
+
+// SendMsgQuota 只检查企业每天发送的人次, 未设置WithMaxAccounts时不限制,
+// 用于已经通过ReserveSendMsg为每个成员计数的调用方
+func (l *Limiter) SendMsgQuota(ctx context.Context, n int) error {
+	quota, ok := l.sendMsgQuota(n)
+	if !ok {
+		return nil
+	}
+	return l.Take(ctx, quota.key, quota.n, quota.rules...)
+}
+
+// sendMsgQuota 企业每天发送人次的计数器, 未设置WithMaxAccounts时返回false
+func (l *Limiter) sendMsgQuota(n int) (take, bool) {
+	if l.maxAccounts <= 0 {
+		return take{}, false
+	}
+	rule := Rule{Limit: l.maxAccounts * BaseTimesSendMsgOneCorp, Window: 24 * time.Hour}
+	return take{key: "sendmsg", n: n, rules: []Rule{rule}}, true
+}
+
+// ReserveSendMsg 不等待地为应用agentID发送给user的消息计数, 与SendMsg使用同一个计数器;
+// 超过频率时不计数并返回需要等待的时间
+func (l *Limiter) ReserveSendMsg(ctx context.Context, agentID, user string) (time.Duration, error) {
+	return l.backend.Take(ctx, l.corpID+":"+sendMsgUserKey(agentID, user), 1, sendMsgUserRules)
+}
+
+// sendMsgUserKey 应用对成员发送消息的计数器
+func sendMsgUserKey(agentID, user string) string {
+	return "sendmsg:" + agentID + ":" + user
+}
+
+// JsAPITicket 获取jsapi_ticket前检查企业和应用的频率, 企业超过限制时回滚应用的计数, 需要Backend实现Releaser
+func (l *Limiter) JsAPITicket(ctx context.Context, agentID string) error {
+	return l.takeAll(ctx, []take{
+		{key: "jsapi_ticket:" + agentID, n: 1, rules: jsAPITicketAppRules},
+		{key: "jsapi_ticket", n: 1, rules: jsAPITicketCorpRules},
+	})
+}
@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// recordBackend 记录调用的计数器
+type recordBackend struct {
+	keys []string
+	n    []int
+	wait time.Duration
+}
+
+func (b *recordBackend) Take(_ context.Context, key string, n int, rules []Rule) (time.Duration, error) {
+	b.keys = append(b.keys, key)
+	b.n = append(b.n, n)
+	return b.wait, nil
+}
+
+func TestLimiter_Take(t *testing.T) {
+	rule := Rule{Limit: 2, Window: 50 * time.Millisecond}
+	ctx := context.Background()
+
+	l := New("corp")
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Take(ctx, "key", 1, rule); err != nil {
+			t.Fatalf("Limiter.Take() error = %v", err)
+		}
+	}
+	if d := time.Since(start); d < rule.Window {
+		t.Errorf("Limiter.Take() waited %v, want >= %v", d, rule.Window)
+	}
+
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	l.Take(ctx2, "ctx", 2, rule)
+	if err := l.Take(ctx2, "ctx", 1, rule); err != context.DeadlineExceeded {
+		t.Errorf("Limiter.Take() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	l = New("corp", WithFailFast())
+	l.Take(ctx, "key", 2, rule)
+	err := l.Take(ctx, "key", 1, rule)
+	var le *LimitError
+	if !errors.Is(err, ErrLimited) || !errors.As(err, &le) || le.Key != "corp:key" || le.RetryAfter <= 0 {
+		t.Errorf("Limiter.Take() error = %v, want LimitError", err)
+	}
+	if err = l.Take(ctx, "other", 1, rule); err != nil {
+		t.Errorf("Limiter.Take() other key error = %v", err)
+	}
+	if err = l.Take(ctx, "key", 3, rule); err != ErrExceedsRule {
+		t.Errorf("Limiter.Take() error = %v, want %v", err, ErrExceedsRule)
+	}
+}
+
+func TestLimiter_Keys(t *testing.T) {
+	ctx := context.Background()
+	b := &recordBackend{}
+	l := New("corp", WithBackend(b), WithMaxAccounts(200))
+
+	l.API(ctx, "/cgi-bin/user/get")
+	l.SendMsg(ctx, "1000001", []string{"u1", "u2"})
+	l.JsAPITicket(ctx, "1000001")
+	l.ReserveSendMsg(ctx, "1000001", "u3")
+	l.SendMsgQuota(ctx, 1)
+	want := []string{
+		"corp:api:/cgi-bin/user/get",
+		"corp:sendmsg",
+		"corp:sendmsg:1000001:u1",
+		"corp:sendmsg:1000001:u2",
+		"corp:jsapi_ticket:1000001",
+		"corp:jsapi_ticket",
+		"corp:sendmsg:1000001:u3",
+		"corp:sendmsg",
+	}
+	if len(b.keys) != len(want) {
+		t.Fatalf("Limiter keys = %v, want %v", b.keys, want)
+	}
+	for i := range want {
+		if b.keys[i] != want[i] {
+			t.Errorf("Limiter keys[%d] = %v, want %v", i, b.keys[i], want[i])
+		}
+	}
+	if b.n[1] != 2 {
+		t.Errorf("Limiter.SendMsg() corp n = %d, want 2", b.n[1])
+	}
+
+	b.wait = time.Minute
+	l = New("corp", WithBackend(b), WithFailFast())
+	if err := l.SendMsg(ctx, "1000001", []string{"u1"}); !errors.Is(err, ErrLimited) {
+		t.Errorf("Limiter.SendMsg() error = %v, want %v", err, ErrLimited)
+	}
+	// ReserveSendMsg不等待, 返回需要等待的时间
+	if wait, err := l.ReserveSendMsg(ctx, "1000001", "u1"); err != nil || wait != time.Minute {
+		t.Errorf("Limiter.ReserveSendMsg() = %v, %v, want %v", wait, err, time.Minute)
+	}
+	// 未设置WithMaxAccounts时不检查企业每天发送的人次
+	if err := l.SendMsgQuota(ctx, 1); err != nil {
+		t.Errorf("Limiter.SendMsgQuota() error = %v", err)
+	}
+}
+
+// count 返回MemoryBackend中key记录的次数
+func count(b *MemoryBackend, key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var total int
+	if w, ok := b.windows[key]; ok {
+		for _, e := range w.entries {
+			total += e.n
+		}
+	}
+	return total
+}
+
+func TestLimiter_Rollback(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	l := New("corp", WithBackend(b), WithFailFast(), WithMaxAccounts(200))
+
+	// 企业的jsapi_ticket超过限制时不占用应用的次数
+	if err := l.Take(ctx, "jsapi_ticket", TimesJsAPITicketOneCorpHour, jsAPITicketCorpRules...); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.JsAPITicket(ctx, "1000001"); !errors.Is(err, ErrLimited) {
+		t.Errorf("Limiter.JsAPITicket() error = %v, want %v", err, ErrLimited)
+	}
+	if n := count(b, "corp:jsapi_ticket:1000001"); n != 0 {
+		t.Errorf("app jsapi_ticket count = %d, want 0", n)
+	}
+
+	// 任意成员超过限制时不占用企业的人次和其他成员的次数
+	for i := 0; i < BaseTimesSendMsgOneAppUserMinute; i++ {
+		if wait, err := l.ReserveSendMsg(ctx, "1000001", "u2"); err != nil || wait > 0 {
+			t.Fatalf("Limiter.ReserveSendMsg() = %v, %v", wait, err)
+		}
+	}
+	if err := l.SendMsg(ctx, "1000001", []string{"u1", "u2"}); !errors.Is(err, ErrLimited) {
+		t.Errorf("Limiter.SendMsg() error = %v, want %v", err, ErrLimited)
+	}
+	if n := count(b, "corp:sendmsg"); n != 0 {
+		t.Errorf("sendmsg quota count = %d, want 0", n)
+	}
+	if n := count(b, "corp:"+sendMsgUserKey("1000001", "u1")); n != 0 {
+		t.Errorf("u1 count = %d, want 0", n)
+	}
+	if err := l.SendMsg(ctx, "1000001", []string{"u1"}); err != nil {
+		t.Errorf("Limiter.SendMsg() error = %v", err)
+	}
+	if n := count(b, "corp:sendmsg"); n != 1 {
+		t.Errorf("sendmsg quota count = %d, want 1", n)
+	}
+}
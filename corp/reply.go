@@ -0,0 +1,174 @@
+package corp
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+)
+
+// CDATA 以CDATA段编码的字符串, 内容中的"]]>"会被拆分到相邻的CDATA段, 不会破坏XML结构
+type CDATA string
+
+// MarshalXML 实现xml.Marshaler
+func (c CDATA) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Value string `xml:",cdata"`
+	}{string(c)}, start)
+}
+
+// UnmarshalXML 实现xml.Unmarshaler
+func (c *CDATA) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var s string
+	if err := d.DecodeElement(&s, &start); err != nil {
+		return err
+	}
+	*c = CDATA(s)
+	return nil
+}
+
+// Reply 被动回复的消息, 由New*Reply创建, 使用MarshalReply或者MarshalReplyJSON编码
+type Reply interface {
+	header() *ReplyHeader
+}
+
+// ReplyHeader 被动回复消息的公共字段
+type ReplyHeader struct {
+	XMLName xml.Name `xml:"xml" json:"-"`
+	// ToUserName 成员UserID
+	ToUserName CDATA
+	// FromUserName 企业ID
+	FromUserName CDATA
+	// CreateTime 消息创建时间
+	CreateTime int64
+	// MsgType 消息类型
+	MsgType CDATA
+}
+
+// header 实现Reply
+func (h *ReplyHeader) header() *ReplyHeader {
+	return h
+}
+
+// newReplyHeader 新建被动回复消息的公共字段
+func newReplyHeader(toUserID, fromCorpID, msgType string, createTime int64) ReplyHeader {
+	return ReplyHeader{
+		ToUserName:   CDATA(toUserID),
+		FromUserName: CDATA(fromCorpID),
+		CreateTime:   createTime,
+		MsgType:      CDATA(msgType),
+	}
+}
+
+// MediaReply 图片或者语音的媒体文件
+type MediaReply struct {
+	MediaID CDATA `xml:"MediaId" json:"MediaId"`
+}
+
+// TextReply 文本消息
+type TextReply struct {
+	ReplyHeader
+	Content CDATA
+}
+
+// NewTextReply 新建文本消息
+func NewTextReply(toUserID, fromCorpID, content string, createTime int64) *TextReply {
+	return &TextReply{ReplyHeader: newReplyHeader(toUserID, fromCorpID, "text", createTime), Content: CDATA(content)}
+}
+
+// ImageReply 图片消息
+type ImageReply struct {
+	ReplyHeader
+	Image MediaReply
+}
+
+// NewImageReply 新建图片消息
+func NewImageReply(toUserID, fromCorpID, mediaID string, createTime int64) *ImageReply {
+	return &ImageReply{ReplyHeader: newReplyHeader(toUserID, fromCorpID, "image", createTime), Image: MediaReply{MediaID: CDATA(mediaID)}}
+}
+
+// VoiceReply 语音消息
+type VoiceReply struct {
+	ReplyHeader
+	Voice MediaReply
+}
+
+// NewVoiceReply 新建语音消息
+func NewVoiceReply(toUserID, fromCorpID, mediaID string, createTime int64) *VoiceReply {
+	return &VoiceReply{ReplyHeader: newReplyHeader(toUserID, fromCorpID, "voice", createTime), Voice: MediaReply{MediaID: CDATA(mediaID)}}
+}
+
+// VideoReply 视频消息
+type VideoReply struct {
+	ReplyHeader
+	Video struct {
+		MediaID     CDATA `xml:"MediaId" json:"MediaId"`
+		Title       CDATA
+		Description CDATA
+	}
+}
+
+// NewVideoReply 新建视频消息
+func NewVideoReply(toUserID, fromCorpID, mediaID, title, desc string, createTime int64) *VideoReply {
+	r := &VideoReply{ReplyHeader: newReplyHeader(toUserID, fromCorpID, "video", createTime)}
+	r.Video.MediaID, r.Video.Title, r.Video.Description = CDATA(mediaID), CDATA(title), CDATA(desc)
+	return r
+}
+
+// ArticleReply 图文消息的内容
+type ArticleReply struct {
+	XMLName     xml.Name `xml:"item" json:"-"`
+	Title       CDATA
+	Description CDATA
+	PicURL      CDATA `xml:"PicUrl" json:"PicUrl"`
+	URL         CDATA `xml:"Url" json:"Url"`
+}
+
+// NewArticleReply 新建图文消息的内容
+func NewArticleReply(title, desc, picURL, url string) ArticleReply {
+	return ArticleReply{Title: CDATA(title), Description: CDATA(desc), PicURL: CDATA(picURL), URL: CDATA(url)}
+}
+
+// NewsReply 图文消息
+type NewsReply struct {
+	ReplyHeader
+	ArticleCount int
+	Articles     []ArticleReply `xml:"Articles>item"`
+}
+
+// NewNewsReply 新建图文消息
+func NewNewsReply(toUserID, fromCorpID string, items []ArticleReply, createTime int64) *NewsReply {
+	return &NewsReply{ReplyHeader: newReplyHeader(toUserID, fromCorpID, "news", createTime), ArticleCount: len(items), Articles: items}
+}
+
+// UpdateButtonReply 更新点击用户的模板卡片按钮, 用于回复模板卡片事件(template_card_event)
+type UpdateButtonReply struct {
+	ReplyHeader
+	Button struct {
+		// ReplaceName 按钮替换后的文案
+		ReplaceName CDATA
+	}
+}
+
+// NewUpdateButtonReply 新建更新模板卡片按钮的消息, replaceName为按钮替换后的文案
+func NewUpdateButtonReply(toUserID, fromCorpID, replaceName string, createTime int64) *UpdateButtonReply {
+	r := &UpdateButtonReply{ReplyHeader: newReplyHeader(toUserID, fromCorpID, "update_button", createTime)}
+	r.Button.ReplaceName = CDATA(replaceName)
+	return r
+}
+
+// MarshalReply 把被动回复的消息编码为XML
+func MarshalReply(r Reply) ([]byte, error) {
+	// Reply只能由指针实现
+	if r == nil || reflect.ValueOf(r).IsNil() {
+		return nil, ErrIsNil
+	}
+	return xml.Marshal(r)
+}
+
+// MarshalReplyJSON 把被动回复的消息编码为JSON, 用于JSON格式的回调, 字段名与XML相同
+func MarshalReplyJSON(r Reply) ([]byte, error) {
+	if r == nil || reflect.ValueOf(r).IsNil() {
+		return nil, ErrIsNil
+	}
+	return json.Marshal(r)
+}
@@ -0,0 +1,73 @@
+package corp
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestMarshalReply(t *testing.T) {
+	const head = "<xml><ToUserName><![CDATA[toUser]]></ToUserName><FromUserName><![CDATA[fromUser]]></FromUserName><CreateTime>1357290913</CreateTime>"
+	tests := []struct {
+		name    string
+		reply   Reply
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "text",
+			reply: NewTextReply("toUser", "fromUser", "a]]>b<c>&", 1357290913),
+			want:  head + "<MsgType><![CDATA[text]]></MsgType><Content><![CDATA[a]]]]><![CDATA[>b<c>&]]></Content></xml>",
+		},
+		{
+			name:  "update_button",
+			reply: NewUpdateButtonReply("toUser", "fromUser", "已处理", 1357290913),
+			want:  head + "<MsgType><![CDATA[update_button]]></MsgType><Button><ReplaceName><![CDATA[已处理]]></ReplaceName></Button></xml>",
+		},
+		{
+			name:  "news",
+			reply: NewNewsReply("toUser", "fromUser", []ArticleReply{NewArticleReply("t", "d", "p", "u")}, 1357290913),
+			want:  head + "<MsgType><![CDATA[news]]></MsgType><ArticleCount>1</ArticleCount><Articles><item><Title><![CDATA[t]]></Title><Description><![CDATA[d]]></Description><PicUrl><![CDATA[p]]></PicUrl><Url><![CDATA[u]]></Url></item></Articles></xml>",
+		},
+		{
+			name:    "nil",
+			reply:   (*TextReply)(nil),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalReply(tt.reply)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MarshalReply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MarshalReply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCDATA_UnmarshalXML(t *testing.T) {
+	b, err := MarshalReply(NewTextReply("toUser", "fromUser", "a]]>b", 1))
+	if err != nil {
+		t.Fatalf("MarshalReply() error = %v", err)
+	}
+	var got TextReply
+	if err = xml.Unmarshal(b, &got); err != nil {
+		t.Fatalf("xml.Unmarshal() error = %v", err)
+	}
+	if got.Content != "a]]>b" || got.MsgType != "text" {
+		t.Errorf("xml.Unmarshal() = %+v", got)
+	}
+}
+
+func TestMarshalReplyJSON(t *testing.T) {
+	got, err := MarshalReplyJSON(NewImageReply("toUser", "fromUser", "media_id", 1))
+	want := `{"ToUserName":"toUser","FromUserName":"fromUser","CreateTime":1,"MsgType":"image","Image":{"MediaId":"media_id"}}`
+	if err != nil || string(got) != want {
+		t.Errorf("MarshalReplyJSON() = %s, %v, want %s", got, err, want)
+	}
+	if _, err = MarshalReplyJSON(nil); err != ErrIsNil {
+		t.Errorf("MarshalReplyJSON(nil) error = %v, want %v", err, ErrIsNil)
+	}
+}
@@ -1,7 +1,8 @@
 package corp
 
 import (
-	"fmt"
+	"encoding/xml"
+	"strings"
 )
 
 // Request 是微信推送的事件：如关注、取消关注，消息：如文本、图片类型的消息
@@ -21,8 +22,8 @@ type Request struct {
 	MediaID      string `xml:"MediaId"`
 	Format       string
 	ThumbMediaID string  `xml:"ThumbMediaId"`
-	LocationX    float32 `xml:"Location_X"`
-	LocationY    float32 `xml:"Location_Y"`
+	LocationX    float32 `xml:"Location_X" json:"Location_X"`
+	LocationY    float32 `xml:"Location_Y" json:"Location_Y"`
 	Scale        float32
 	Label        string
 	Title        string
@@ -74,8 +75,8 @@ type PicItem struct {
 
 // SendLocationInfo button location_select event
 type SendLocationInfo struct {
-	LocationX float32 `xml:"Location_X"`
-	LocationY float32 `xml:"Location_Y"`
+	LocationX float32 `xml:"Location_X" json:"Location_X"`
+	LocationY float32 `xml:"Location_Y" json:"Location_Y"`
 	Scale     float32
 	Label     string
 	Poiname   string
@@ -83,34 +84,46 @@ type SendLocationInfo struct {
 
 // NewTextReplyMsg 新建文本消息
 func NewTextReplyMsg(toUserID, fromCorpID, content string, createTime int64) string {
-	return fmt.Sprintf("<xml><ToUserName><![CDATA[%s]]></ToUserName><FromUserName><![CDATA[%s]]></FromUserName><CreateTime>%d</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[%s]]></Content></xml>", toUserID, fromCorpID, createTime, content)
+	return marshalReplyString(NewTextReply(toUserID, fromCorpID, content, createTime))
 }
 
 // NewImageReplyMsg 新建图片消息
 func NewImageReplyMsg(toUserID, fromCorpID, mediaID string, createTime int64) string {
-	return fmt.Sprintf("<xml><ToUserName><![CDATA[%s]]></ToUserName><FromUserName><![CDATA[%s]]></FromUserName><CreateTime>%d</CreateTime><MsgType><![CDATA[image]]></MsgType><Image><MediaId><![CDATA[%s]]></MediaId></Image></xml>", toUserID, fromCorpID, createTime, mediaID)
+	return marshalReplyString(NewImageReply(toUserID, fromCorpID, mediaID, createTime))
 }
 
 // NewVoiceReplyMsg 新建语音消息
 func NewVoiceReplyMsg(toUserID, fromCorpID, mediaID string, createTime int64) string {
-	return fmt.Sprintf("<xml><ToUserName><![CDATA[%s]]></ToUserName><FromUserName><![CDATA[%s]]></FromUserName><CreateTime>%d</CreateTime><MsgType><![CDATA[voice]]></MsgType><Voice><MediaId><![CDATA[%s]]></MediaId></Voice></xml>", toUserID, fromCorpID, createTime, mediaID)
+	return marshalReplyString(NewVoiceReply(toUserID, fromCorpID, mediaID, createTime))
 }
 
 // NewVideoReplyMsg 新建视频消息
 func NewVideoReplyMsg(toUserID, fromCorpID, mediaID, title, desc string, createTime int64) string {
-	return fmt.Sprintf("<xml><ToUserName><![CDATA[%s]]></ToUserName><FromUserName><![CDATA[%s]]></FromUserName><CreateTime>%d</CreateTime><MsgType><![CDATA[video]]></MsgType><Video><MediaId><![CDATA[%s]]></MediaId><Title><![CDATA[%s]]></Title><Description><![CDATA[%s]]></Description></Video></xml>", toUserID, fromCorpID, createTime, mediaID, title, desc)
+	return marshalReplyString(NewVideoReply(toUserID, fromCorpID, mediaID, title, desc, createTime))
 }
 
 // NewNewsItemMsg 新建图文消息内容
 func NewNewsItemMsg(title, desc, picURL, url string) string {
-	return fmt.Sprintf("<item><Title><![CDATA[%s]]></Title><Description><![CDATA[%s]]></Description><PicUrl><![CDATA[%s]]></PicUrl><Url><![CDATA[%s]]></Url></item>", title, desc, picURL, url)
+	b, _ := xml.Marshal(NewArticleReply(title, desc, picURL, url))
+	return string(b)
 }
 
 // NewNewsReplyMsg 新建图文消息, items是每条图文消息的字符串, 即NewNewsItemMsg的结果
 func NewNewsReplyMsg(toUserID, fromCorpID string, items []string, creatTime int64) string {
-	var s string
-	for _, item := range items {
-		s += item
-	}
-	return fmt.Sprintf("<xml><ToUserName><![CDATA[%s]]></ToUserName><FromUserName><![CDATA[%s]]></FromUserName><CreateTime>%d</CreateTime><MsgType><![CDATA[news]]></MsgType><ArticleCount>%d</ArticleCount><Articles>%s</Articles></xml>", toUserID, fromCorpID, creatTime, len(items), s)
+	r := struct {
+		ReplyHeader
+		ArticleCount int
+		Articles     struct {
+			Items string `xml:",innerxml"`
+		}
+	}{ReplyHeader: newReplyHeader(toUserID, fromCorpID, "news", creatTime), ArticleCount: len(items)}
+	r.Articles.Items = strings.Join(items, "")
+	b, _ := xml.Marshal(r)
+	return string(b)
+}
+
+// marshalReplyString 编码被动回复的消息, 回复结构不会编码失败
+func marshalReplyString(r Reply) string {
+	b, _ := MarshalReply(r)
+	return string(b)
 }
@@ -15,5 +15,5 @@ func (res *Response) Validate() error {
 	if res == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(res.ErrCode)
+	return errcode.New(res.ErrCode, res.ErrMsg)
 }
@@ -1,17 +1,18 @@
 package corp
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"context"
+	"net/http"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 	"github.com/qingtao/wxcorp/corp/errcode"
 )
 
 const (
-	defaultSendMsgURL              = "https://qyapi.weixin.qq.com/cgi-bin/message/send"
+	sendMsgPath                    = "/cgi-bin/message/send"
+	recallMsgPath                  = "/cgi-bin/message/recall"
 	mimeApplicationJSONCharsetUTF8 = "application/json; charset=utf-8"
 )
 
@@ -23,12 +24,19 @@ type Msg struct {
 	ToParty string `json:"toparty,omitempty"`
 	// ToTag 目标的标签, 多个标签使用"|"分割,如果ToUser为"@all",忽略此字段, 每次最多100
 	ToTag string `json:"totag,omitempty"`
-	// MsgType 消息类型, 支持"text|image|video|voice|file|news|mpnews|textcard|markdown"等的一个
+	// MsgType 消息类型, 支持"text|image|video|voice|file|news|mpnews|textcard|markdown|
+	// miniprogram_notice|template_card|interactive_taskcard"等的一个
 	MsgType string `json:"msgtype"`
 	// AgentID app的id
 	AgentID int `json:"agentid"`
 	// 是否是保密消息 0：否,1:是默认0
 	Safe int `json:"safe,omitempty"`
+	// EnableIDTrans 是否开启id转译 0：否,1:是默认0
+	EnableIDTrans int `json:"enable_id_trans,omitempty"`
+	// EnableDuplicateCheck 是否开启重复消息检查 0：否,1:是默认0
+	EnableDuplicateCheck int `json:"enable_duplicate_check,omitempty"`
+	// DuplicateCheckInterval 重复消息检查的时间间隔(秒), 默认1800s, 最大不超过4小时
+	DuplicateCheckInterval int `json:"duplicate_check_interval,omitempty"`
 
 	// 消息结构
 	Text  *TextMsg  `json:"text,omitempty"`
@@ -43,9 +51,29 @@ type Msg struct {
 	MpNews *MpNewsMsg `json:"mpnews,omitempty"`
 	// Markdown 的子集
 	Markdown *MarkdownMsg `json:"markdown,omitempty"`
-	// 小程序消息暂不支持
+	// 小程序通知
+	MiniprogramNotice *MiniprogramNoticeMsg `json:"miniprogram_notice,omitempty"`
+	// 模板卡片
+	TemplateCard *TemplateCardMsg `json:"template_card,omitempty"`
+	// 任务卡片
+	InteractiveTaskCard *InteractiveTaskCardMsg `json:"interactive_taskcard,omitempty"`
 }
 
+// 每次发送消息的接收人数量上限
+const (
+	// MaxMsgUsers 每次最多1000个成员
+	MaxMsgUsers = 1000
+	// MaxMsgParties 每次最多100个部门
+	MaxMsgParties = 100
+	// MaxMsgTags 每次最多100个标签
+	MaxMsgTags = 100
+)
+
+const (
+	// maxDuplicateCheckInterval 重复消息检查的最大时间间隔, 4小时
+	maxDuplicateCheckInterval = 4 * 60 * 60
+)
+
 // Validate 验证消息
 func (msg *Msg) Validate() error {
 	if msg == nil {
@@ -54,9 +82,16 @@ func (msg *Msg) Validate() error {
 	if msg.ToUser == "" && msg.ToParty == "" && msg.ToTag == "" {
 		return errors.New("touser/toparty/totag不能同时为空")
 	}
-	if msg.AgentID == 0 {
+	if err := msg.validateRecipients(); err != nil {
+		return err
+	}
+	// 小程序通知消息不需要agentid
+	if msg.AgentID == 0 && msg.MsgType != "miniprogram_notice" {
 		return errors.New("应用代理agentid不可为空")
 	}
+	if err := msg.validateOptions(); err != nil {
+		return err
+	}
 
 	switch msg.MsgType {
 	case "text":
@@ -77,10 +112,47 @@ func (msg *Msg) Validate() error {
 		return msg.TextCard.Validate()
 	case "markdown":
 		return msg.Markdown.Validate()
+	case "miniprogram_notice":
+		return msg.MiniprogramNotice.Validate()
+	case "template_card":
+		return msg.TemplateCard.Validate()
+	case "interactive_taskcard":
+		return msg.InteractiveTaskCard.Validate()
 	}
 	return errors.New("消息类型错误")
 }
 
+// validateRecipients 验证接收人数量, ToUser为"@all"时忽略
+func (msg *Msg) validateRecipients() error {
+	if msg.ToUser == "@all" {
+		return nil
+	}
+	if len(splitIDs(msg.ToUser)) > MaxMsgUsers {
+		return errors.Errorf("touser每次最多%d个", MaxMsgUsers)
+	}
+	if len(splitIDs(msg.ToParty)) > MaxMsgParties {
+		return errors.Errorf("toparty每次最多%d个", MaxMsgParties)
+	}
+	if len(splitIDs(msg.ToTag)) > MaxMsgTags {
+		return errors.Errorf("totag每次最多%d个", MaxMsgTags)
+	}
+	return nil
+}
+
+// validateOptions 验证消息的可选项
+func (msg *Msg) validateOptions() error {
+	if msg.EnableIDTrans != 0 && msg.EnableIDTrans != 1 {
+		return errors.New("enable_id_trans只能是0或1")
+	}
+	if msg.EnableDuplicateCheck != 0 && msg.EnableDuplicateCheck != 1 {
+		return errors.New("enable_duplicate_check只能是0或1")
+	}
+	if msg.DuplicateCheckInterval < 0 || msg.DuplicateCheckInterval > maxDuplicateCheckInterval {
+		return errors.New("duplicate_check_interval超过4小时")
+	}
+	return nil
+}
+
 // TextMsg 文本消息
 type TextMsg struct {
 	Content string `json:"content"`
@@ -280,6 +352,71 @@ func (msg *MarkdownMsg) Validate() error {
 	return nil
 }
 
+// MiniprogramNoticeMsg 小程序通知消息, 只允许绑定了小程序的应用发送
+type MiniprogramNoticeMsg struct {
+	// AppID 小程序appid, 必须是与当前应用关联的小程序
+	AppID string `json:"appid"`
+	// Page 点击消息卡片后的小程序页面, 仅限本小程序内的页面
+	Page string `json:"page,omitempty"`
+	// Title 消息标题, 长度限制4-12个汉字
+	Title string `json:"title"`
+	// Desc 消息描述, 长度限制4-12个汉字
+	Desc string `json:"description,omitempty"`
+	// EmphasisFirstItem 是否放大第一个content_item
+	EmphasisFirstItem bool `json:"emphasis_first_item,omitempty"`
+	// ContentItem 消息内容键值对, 最多允许10个item
+	ContentItem []ContentItem `json:"content_item,omitempty"`
+}
+
+// Validate 验证小程序通知消息
+func (msg *MiniprogramNoticeMsg) Validate() error {
+	if msg == nil {
+		return errors.New("消息为空")
+	}
+	if msg.AppID == "" {
+		return errors.New("小程序通知消息appid为空")
+	}
+	if n := utf8.RuneCountInString(msg.Title); n < 4 || n > 12 {
+		return errors.New("小程序通知消息标题长度限制4-12个汉字")
+	}
+	if n := utf8.RuneCountInString(msg.Desc); n > 0 && (n < 4 || n > 12) {
+		return errors.New("小程序通知消息描述长度限制4-12个汉字")
+	}
+	if len(msg.ContentItem) > 10 {
+		return errors.New("小程序通知消息最多允许10个content_item")
+	}
+	var err error
+	for _, item := range msg.ContentItem {
+		if err = item.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContentItem 小程序通知消息的内容键值对
+type ContentItem struct {
+	// Key 长度10个汉字以内
+	Key string `json:"key"`
+	// Value 长度30个汉字以内
+	Value string `json:"value"`
+}
+
+// Validate 验证小程序通知消息的内容键值对
+func (item ContentItem) Validate() error {
+	if item.Key == "" {
+		return errors.New("content_item的key为空")
+	} else if utf8.RuneCountInString(item.Key) > 10 {
+		return errors.New("content_item的key长度超过10个汉字")
+	}
+	if item.Value == "" {
+		return errors.New("content_item的value为空")
+	} else if utf8.RuneCountInString(item.Value) > 30 {
+		return errors.New("content_item的value长度超过30个汉字")
+	}
+	return nil
+}
+
 // SendMsgResponse 发送消息的响应结构
 //	收件人必须处于应用的可见范围内，并且管理组对应用有使用权限、对收件人有查看权限，否则本次调用失败。
 //	如果无权限或收件人不存在，则本次发送失败，返回无效的userid列表（注：由于userid不区分大小写，返回的列表都统一转为小写）；如果未关注，发送仍然执行。
@@ -289,6 +426,68 @@ type SendMsgResponse struct {
 	InvalidUser  string `json:"invaliduser,omitempty"`
 	InvalidParty string `json:"invalidparty,omitempty"`
 	InvalidTag   string `json:"invalidtag,omitempty"`
+	// UnlicensedUser 没有基础接口许可(包含已过期)的userid
+	UnlicensedUser string `json:"unlicenseduser,omitempty"`
+	// MsgID 消息id, 用于撤回应用消息
+	MsgID string `json:"msgid,omitempty"`
+	// ResponseCode 仅消息类型为按钮交互型, 投票选择型和多项选择型的模板卡片消息返回, 用于更新卡片, 72小时内有效且只能使用一次
+	ResponseCode string `json:"response_code,omitempty"`
+}
+
+// Validate 验证发送消息的响应
+func (res *SendMsgResponse) Validate() error {
+	if res == nil {
+		return ErrIsNil
+	}
+	return errcode.New(res.ErrCode, res.ErrMsg)
+}
+
+// Result 转换为发送消息的结果
+func (res *SendMsgResponse) Result() *SendResult {
+	return &SendResult{
+		MsgID:           res.MsgID,
+		ResponseCode:    res.ResponseCode,
+		InvalidUsers:    splitIDs(res.InvalidUser),
+		InvalidParties:  splitIDs(res.InvalidParty),
+		InvalidTags:     splitIDs(res.InvalidTag),
+		UnlicensedUsers: splitIDs(res.UnlicensedUser),
+	}
+}
+
+// SendResult 发送消息的结果
+type SendResult struct {
+	// MsgID 消息id, 用于撤回应用消息
+	MsgID string
+	// ResponseCode 用于更新交互型模板卡片
+	ResponseCode string
+	// InvalidUsers 无效或者无权限的userid, 统一转为小写
+	InvalidUsers []string
+	// InvalidParties 无效或者无权限的部门id
+	InvalidParties []string
+	// InvalidTags 无效或者无权限的标签id
+	InvalidTags []string
+	// UnlicensedUsers 没有基础接口许可的userid
+	UnlicensedUsers []string
+}
+
+// Partial 是否有接收人没有收到消息
+func (r *SendResult) Partial() bool {
+	return r != nil && (len(r.InvalidUsers) > 0 || len(r.InvalidParties) > 0 ||
+		len(r.InvalidTags) > 0 || len(r.UnlicensedUsers) > 0)
+}
+
+// splitIDs 拆分使用"|"分割的id列表
+func splitIDs(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var ids []string
+	for _, id := range strings.Split(s, "|") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
 }
 
 // NewSendMsgURL 新建发送消息URL
@@ -297,58 +496,58 @@ func NewSendMsgURL(url, accessToken string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultSendMsgURL
+		url = apiURL(sendMsgPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
-// SendMsg 发送应用消息
-func SendMsg(url, accessToken string, msg *Msg) (err error) {
+// SendMsg 发送应用消息, 部分接收人无效时仍然视为发送成功, 无效的接收人记录在结果中
+func SendMsg(url, accessToken string, msg *Msg) (*SendResult, error) {
+	return DefaultClient.SendMsg(context.Background(), url, accessToken, msg)
+}
+
+// SendMsg 发送应用消息, 部分接收人无效时仍然视为发送成功, 无效的接收人记录在结果中.
+// 只有请求失败或者errcode不为0时才返回错误, 错误码可以使用errors.Is和errors.As判断,
+// 此时如果服务器返回了响应, 结果同时返回
+func (c *Client) SendMsg(ctx context.Context, url, accessToken string, msg *Msg) (*SendResult, error) {
 	if accessToken == "" {
-		return errcode.ErrInvalidAccessToken
-	}
-	if err = msg.Validate(); err != nil {
-		return
-	}
-	b, err := json.Marshal(msg)
-	if err != nil {
-		return
+		return nil, errcode.ErrInvalidAccessToken
 	}
-	buf := bytes.NewBuffer(b)
-	defer buf.Reset()
-	url = NewSendMsgURL(url, accessToken)
-	resp, err := httpClient.Post(url, mimeApplicationJSONCharsetUTF8, buf)
-	if err != nil {
-		return
+	if err := msg.Validate(); err != nil {
+		return nil, err
 	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
-	}
-	b, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
-	}
-	var res SendMsgResponse
-	if err = json.Unmarshal(b, &res); err != nil {
-		return
+	res, err := execute[SendMsgResponse](ctx, c, http.MethodPost, NewSendMsgURL(url, accessToken), msg)
+	if res == nil {
+		return nil, err
 	}
+	return res.Result(), err
+}
 
-	var errMap = make(map[string]string)
-	if err = errcode.Error(res.ErrCode); err != nil {
-		errMap["error"] = err.Error()
-	}
-	if res.InvalidUser != "" { // 失败的用户
-		errMap["InvalidUser"] = res.InvalidUser
+// NewRecallMsgURL 新建撤回应用消息的URL
+func NewRecallMsgURL(url, accessToken string) string {
+	if accessToken == "" {
+		return ""
 	}
-	if res.InvalidParty != "" { // 失败的部门
-		errMap["InvalidParty"] = res.InvalidParty
+	if url == "" {
+		url = apiURL(recallMsgPath)
 	}
-	if res.InvalidTag != "" { // 失败的标签
-		errMap["InvalidTag"] = res.InvalidTag
+	return buildURL(url, "access_token", accessToken)
+}
+
+// RecallMsg 撤回24小时内通过发送应用消息接口推送的消息
+func RecallMsg(url, accessToken, msgid string) error {
+	return DefaultClient.RecallMsg(context.Background(), url, accessToken, msgid)
+}
+
+// RecallMsg 撤回24小时内通过发送应用消息接口推送的消息
+func (c *Client) RecallMsg(ctx context.Context, url, accessToken, msgid string) error {
+	if accessToken == "" {
+		return errcode.ErrInvalidAccessToken
 	}
-	if len(errMap) > 0 {
-		b, _ := json.Marshal(errMap)
-		err = errors.New(string(b))
+	if msgid == "" {
+		return errors.New("消息msgid为空")
 	}
-	return
+	body := map[string]string{"msgid": msgid}
+	_, err := execute[Response](ctx, c, http.MethodPost, NewRecallMsgURL(url, accessToken), body)
+	return err
 }
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp/errcode"
 )
 
 func TestNewSendMsgURL(t *testing.T) {
@@ -586,6 +590,177 @@ func TestMarkdownMsg_Validate(t *testing.T) {
 	}
 }
 
+func TestMsg_ValidateOptions(t *testing.T) {
+	newMsg := func() *Msg {
+		return &Msg{
+			ToUser:  "TOUSER",
+			AgentID: 1002,
+			MsgType: "text",
+			Text:    &TextMsg{Content: "abc"},
+		}
+	}
+	tests := []struct {
+		name    string
+		modify  func(msg *Msg)
+		wantErr bool
+	}{
+		{
+			name:    "default",
+			modify:  func(msg *Msg) {},
+			wantErr: false,
+		},
+		{
+			name: "duplicate check",
+			modify: func(msg *Msg) {
+				msg.EnableIDTrans = 1
+				msg.EnableDuplicateCheck = 1
+				msg.DuplicateCheckInterval = 1800
+			},
+			wantErr: false,
+		},
+		{
+			name:    "too many users",
+			modify:  func(msg *Msg) { msg.ToUser = strings.Repeat("u|", MaxMsgUsers) + "u" },
+			wantErr: true,
+		},
+		{
+			name:    "too many parties",
+			modify:  func(msg *Msg) { msg.ToParty = strings.Repeat("1|", MaxMsgParties) + "1" },
+			wantErr: true,
+		},
+		{
+			name:    "too many tags",
+			modify:  func(msg *Msg) { msg.ToTag = strings.Repeat("1|", MaxMsgTags) + "1" },
+			wantErr: true,
+		},
+		{
+			name: "@all ignores parties",
+			modify: func(msg *Msg) {
+				msg.ToUser = "@all"
+				msg.ToParty = strings.Repeat("1|", MaxMsgParties) + "1"
+			},
+			wantErr: false,
+		},
+		{
+			name:    "enable_id_trans",
+			modify:  func(msg *Msg) { msg.EnableIDTrans = 2 },
+			wantErr: true,
+		},
+		{
+			name:    "enable_duplicate_check",
+			modify:  func(msg *Msg) { msg.EnableDuplicateCheck = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "duplicate_check_interval",
+			modify:  func(msg *Msg) { msg.DuplicateCheckInterval = 4*60*60 + 1 },
+			wantErr: true,
+		},
+		{
+			name: "miniprogram_notice without agentid",
+			modify: func(msg *Msg) {
+				msg.AgentID = 0
+				msg.MsgType = "miniprogram_notice"
+				msg.MiniprogramNotice = &MiniprogramNoticeMsg{AppID: "wx123", Title: "会议室预订成功通知"}
+			},
+			wantErr: false,
+		},
+		{
+			name: "template_card",
+			modify: func(msg *Msg) {
+				msg.MsgType = "template_card"
+				msg.TemplateCard = &TemplateCardMsg{
+					CardType:     TemplateCardTextNotice,
+					SubTitleText: "下载企业微信还能抢红包！",
+					CardAction:   &CardAction{Type: 1, URL: "https://work.weixin.qq.com"},
+				}
+			},
+			wantErr: false,
+		},
+		{
+			name: "interactive_taskcard",
+			modify: func(msg *Msg) {
+				msg.MsgType = "interactive_taskcard"
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := newMsg()
+			tt.modify(msg)
+			if err := msg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Msg.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiniprogramNoticeMsg_Validate(t *testing.T) {
+	var s = `{"appid":"wx123123123123123","page":"pages/index?userid=zhangsan&orderid=123123123","title":"会议室预订成功通知","description":"4月27日 16:16","emphasis_first_item":true,"content_item":[{"key":"会议室","value":"402"},{"key":"会议地点","value":"广州TIT-402会议室"}]}`
+	var valid MiniprogramNoticeMsg
+	if err := json.Unmarshal([]byte(s), &valid); err != nil {
+		t.Fatal(err)
+	}
+	items := make([]ContentItem, 11)
+	for i := range items {
+		items[i] = ContentItem{Key: "会议室", Value: "402"}
+	}
+	tests := []struct {
+		name    string
+		msg     *MiniprogramNoticeMsg
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			msg:     &valid,
+			wantErr: false,
+		},
+		{
+			name:    "nil",
+			msg:     nil,
+			wantErr: true,
+		},
+		{
+			name:    "no appid",
+			msg:     &MiniprogramNoticeMsg{Title: "会议室预订成功通知"},
+			wantErr: true,
+		},
+		{
+			name:    "short title",
+			msg:     &MiniprogramNoticeMsg{AppID: "wx123", Title: "通知"},
+			wantErr: true,
+		},
+		{
+			name:    "long description",
+			msg:     &MiniprogramNoticeMsg{AppID: "wx123", Title: "会议室预订成功通知", Desc: strings.Repeat("长", 13)},
+			wantErr: true,
+		},
+		{
+			name:    "too many items",
+			msg:     &MiniprogramNoticeMsg{AppID: "wx123", Title: "会议室预订成功通知", ContentItem: items},
+			wantErr: true,
+		},
+		{
+			name:    "empty key",
+			msg:     &MiniprogramNoticeMsg{AppID: "wx123", Title: "会议室预订成功通知", ContentItem: []ContentItem{{Value: "402"}}},
+			wantErr: true,
+		},
+		{
+			name:    "long value",
+			msg:     &MiniprogramNoticeMsg{AppID: "wx123", Title: "会议室预订成功通知", ContentItem: []ContentItem{{Key: "会议室", Value: strings.Repeat("长", 31)}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.msg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("MiniprogramNoticeMsg.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestSendMsg(t *testing.T) {
 	s := `{
    "touser" : "UserID1|UserID2|UserID3",
@@ -606,7 +781,9 @@ func TestSendMsg(t *testing.T) {
    "errmsg" : "ok",
    "invaliduser" : "",
    "invalidparty" : "",
-   "invalidtag":""
+   "invalidtag":"",
+   "msgid":"xxxx",
+   "response_code":"xyzxyz"
  }`
 
 	var resErr = `{
@@ -614,7 +791,9 @@ func TestSendMsg(t *testing.T) {
    "errmsg" : "ok",
    "invaliduser" : "userid1|userid2",
    "invalidparty" : "partyid1|partyid2",
-   "invalidtag":"tagid1|tagid2"
+   "invalidtag":"tagid1|tagid2",
+   "unlicenseduser":"userid3",
+   "msgid":"xxxx"
  }`
 
 	ht := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -625,8 +804,10 @@ func TestSendMsg(t *testing.T) {
 		case "wantOk":
 		case "wantJSONErr":
 			res = `{"errcode:0,"errmsg":"ok"}`
-		case "wantErr":
+		case "wantPartial":
 			res = resErr
+		case "wantAllInvalid":
+			res = `{"errcode":81013,"errmsg":"user & party & tag all invalid","invaliduser":"userid1|userid2|userid3"}`
 		default:
 			res = `{"errcode":1,"errmsg":"未知错误"}`
 		}
@@ -641,6 +822,7 @@ func TestSendMsg(t *testing.T) {
 	tests := []struct {
 		name    string
 		args    args
+		want    *SendResult
 		wantErr bool
 	}{
 		// TODO: Add test cases.
@@ -671,12 +853,31 @@ func TestSendMsg(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name: "wantErr",
+			name: "wantPartial",
+			args: args{
+				url:         ht.URL,
+				accessToken: "wantPartial",
+				msg:         &msg,
+			},
+			want: &SendResult{
+				MsgID:           "xxxx",
+				InvalidUsers:    []string{"userid1", "userid2"},
+				InvalidParties:  []string{"partyid1", "partyid2"},
+				InvalidTags:     []string{"tagid1", "tagid2"},
+				UnlicensedUsers: []string{"userid3"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "wantAllInvalid",
 			args: args{
 				url:         ht.URL,
-				accessToken: "wantErr",
+				accessToken: "wantAllInvalid",
 				msg:         &msg,
 			},
+			want: &SendResult{
+				InvalidUsers: []string{"userid1", "userid2", "userid3"},
+			},
 			wantErr: true,
 		},
 		{
@@ -700,13 +901,63 @@ func TestSendMsg(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := SendMsg(tt.args.url, tt.args.accessToken, tt.args.msg)
+			res, err := SendMsg(tt.args.url, tt.args.accessToken, tt.args.msg)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("SendMsg() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.name == "wantOk" && (res == nil || res.MsgID != "xxxx" || res.ResponseCode != "xyzxyz" || res.Partial()) {
+				t.Errorf("SendMsg() = %+v, want msgid xxxx", res)
+			}
+			if tt.want != nil && !reflect.DeepEqual(res, tt.want) {
+				t.Errorf("SendMsg() = %+v, want %+v", res, tt.want)
+			}
+			if tt.name == "wantPartial" && !res.Partial() {
+				t.Error("SendResult.Partial() = false, want true")
+			}
+			if tt.name == "wantAllInvalid" && !errors.Is(err, errcode.ErrAllRecipientsInvalid) {
+				t.Errorf("SendMsg() error = %v, want %v", err, errcode.ErrAllRecipientsInvalid)
+			}
 			if err != nil {
 				t.Log(err.Error())
 			}
 		})
 	}
 }
+
+func TestRecallMsg(t *testing.T) {
+	ht := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			MsgID string `json:"msgid"`
+		}
+		if r.Method != http.MethodPost || r.URL.Path != recallMsgPath {
+			fmt.Fprint(w, `{"errcode":1,"errmsg":"未知错误"}`)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.MsgID != "vcT8gGc-7dFb4bxT35ONjBDz901sLlXPZw1DAMC_Gc26qRpK-AK5sTJkkb0128t" {
+			fmt.Fprint(w, `{"errcode":40008,"errmsg":"invalid message type"}`)
+			return
+		}
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+	}))
+	defer ht.Close()
+
+	tests := []struct {
+		name        string
+		accessToken string
+		msgid       string
+		wantErr     bool
+	}{
+		{name: "ok", accessToken: "a", msgid: "vcT8gGc-7dFb4bxT35ONjBDz901sLlXPZw1DAMC_Gc26qRpK-AK5sTJkkb0128t"},
+		{name: "no access token", msgid: "a", wantErr: true},
+		{name: "no msgid", accessToken: "a", wantErr: true},
+		{name: "invalid msgid", accessToken: "a", msgid: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RecallMsg(ht.URL+recallMsgPath, tt.accessToken, tt.msgid); (err != nil) != tt.wantErr {
+				t.Errorf("RecallMsg() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,16 +1,16 @@
 package corp
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"context"
+	"net/http"
+	"strconv"
 
 	"github.com/qingtao/wxcorp/corp/errcode"
 )
 
 const (
-	defaultGetTagListURL   = "https://qyapi.weixin.qq.com/cgi-bin/tag/list"
-	defaultGetUserOfTagURL = "https://qyapi.weixin.qq.com/cgi-bin/tag/get"
+	getTagListPath   = "/cgi-bin/tag/list"
+	getUserOfTagPath = "/cgi-bin/tag/get"
 )
 
 // Tag 企业号通讯录标签
@@ -33,7 +33,7 @@ func (res *TagListResponse) Validate() error {
 	if res == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(res.ErrCode)
+	return errcode.New(res.ErrCode, res.ErrMsg)
 }
 
 // UserlistOfTag 标签成员
@@ -63,7 +63,7 @@ func (res *MemberOfTagReponse) Validate() error {
 	if res == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(res.ErrCode)
+	return errcode.New(res.ErrCode, res.ErrMsg)
 }
 
 // NewGetTagListURL 新建获取标签列表的URL
@@ -72,33 +72,22 @@ func NewGetTagListURL(url, accessToken string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultGetTagListURL
+		url = apiURL(getTagListPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
 // GetTagList 获取标签列表
 func GetTagList(url, accessToken string) (res *TagListResponse, err error) {
+	return DefaultClient.GetTagList(context.Background(), url, accessToken)
+}
+
+// GetTagList 获取标签列表
+func (c *Client) GetTagList(ctx context.Context, url, accessToken string) (res *TagListResponse, err error) {
 	if accessToken == "" {
 		return nil, errcode.ErrInvalidAccessToken
 	}
-	url = NewGetTagListURL(url, accessToken)
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
-	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	if err = json.Unmarshal(b, &res); err != nil {
-		return
-	}
-	err = res.Validate()
-	return
+	return execute[TagListResponse](ctx, c, http.MethodGet, NewGetTagListURL(url, accessToken), nil)
 }
 
 // NewGetUserOfTagURL 新建获取标签用户的URL
@@ -107,31 +96,20 @@ func NewGetUserOfTagURL(url, accessToken string, tagid int) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultGetUserOfTagURL
+		url = apiURL(getUserOfTagPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s&tagid=%d", url, accessToken, tagid)
+	return buildURL(url, "access_token", accessToken, "tagid", strconv.Itoa(tagid))
 }
 
 // GetMemberOfTag 获取标签成员
 func GetMemberOfTag(url, accessToken string, tagid int) (member *MemberOfTagReponse, err error) {
+	return DefaultClient.GetMemberOfTag(context.Background(), url, accessToken, tagid)
+}
+
+// GetMemberOfTag 获取标签成员
+func (c *Client) GetMemberOfTag(ctx context.Context, url, accessToken string, tagid int) (member *MemberOfTagReponse, err error) {
 	if accessToken == "" {
 		return nil, errcode.ErrInvalidAccessToken
 	}
-	url = NewGetUserOfTagURL(url, accessToken, tagid)
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
-	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	if err = json.Unmarshal(b, &member); err != nil {
-		return
-	}
-	err = member.Validate()
-	return
+	return execute[MemberOfTagReponse](ctx, c, http.MethodGet, NewGetUserOfTagURL(url, accessToken, tagid), nil)
 }
@@ -0,0 +1,686 @@
+package corp
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/qingtao/wxcorp/corp/errcode"
+)
+
+const updateTemplateCardPath = "/cgi-bin/message/update_template_card"
+
+// 模板卡片类型
+const (
+	// TemplateCardTextNotice 文本通知型
+	TemplateCardTextNotice = "text_notice"
+	// TemplateCardNewsNotice 图文展示型
+	TemplateCardNewsNotice = "news_notice"
+	// TemplateCardButtonInteraction 按钮交互型
+	TemplateCardButtonInteraction = "button_interaction"
+	// TemplateCardVoteInteraction 投票选择型
+	TemplateCardVoteInteraction = "vote_interaction"
+	// TemplateCardMultipleInteraction 多项选择型
+	TemplateCardMultipleInteraction = "multiple_interaction"
+)
+
+// TemplateCardMsg 模板卡片消息, 不同的CardType使用不同的字段
+type TemplateCardMsg struct {
+	// CardType 卡片类型
+	CardType string `json:"card_type"`
+	// Source 卡片来源样式信息
+	Source *CardSource `json:"source,omitempty"`
+	// ActionMenu 卡片右上角更多操作按钮, 需要同时设置TaskID
+	ActionMenu *CardActionMenu `json:"action_menu,omitempty"`
+	// TaskID 任务id, 同一个应用任务id不能重复, 交互型卡片必填
+	TaskID string `json:"task_id,omitempty"`
+	// MainTitle 一级标题
+	MainTitle *CardMainTitle `json:"main_title,omitempty"`
+	// QuoteArea 引用文献样式
+	QuoteArea *CardQuoteArea `json:"quote_area,omitempty"`
+	// EmphasisContent 关键数据样式, 仅text_notice
+	EmphasisContent *CardEmphasisContent `json:"emphasis_content,omitempty"`
+	// SubTitleText 二级普通文本
+	SubTitleText string `json:"sub_title_text,omitempty"`
+	// HorizontalContentList 二级标题+文本列表, 最多6个
+	HorizontalContentList []CardHorizontalContent `json:"horizontal_content_list,omitempty"`
+	// JumpList 跳转指引样式的列表, 最多3个
+	JumpList []CardJump `json:"jump_list,omitempty"`
+	// CardAction 整体卡片的点击跳转事件, text_notice和news_notice必填
+	CardAction *CardAction `json:"card_action,omitempty"`
+	// ImageTextArea 左图右文样式, 仅news_notice
+	ImageTextArea *CardImageTextArea `json:"image_text_area,omitempty"`
+	// CardImage 图片样式, 仅news_notice
+	CardImage *CardImage `json:"card_image,omitempty"`
+	// VerticalContentList 卡片二级垂直内容, 最多4个, 仅news_notice
+	VerticalContentList []CardVerticalContent `json:"vertical_content_list,omitempty"`
+	// ButtonSelection 下拉式的选择器, 仅button_interaction
+	ButtonSelection *CardSelect `json:"button_selection,omitempty"`
+	// ButtonList 按钮列表, 1到6个, 仅button_interaction
+	ButtonList []CardButton `json:"button_list,omitempty"`
+	// Checkbox 选择题样式, 仅vote_interaction
+	Checkbox *CardCheckbox `json:"checkbox,omitempty"`
+	// SelectList 下拉式的选择器列表, 1到3个, 仅multiple_interaction
+	SelectList []CardSelect `json:"select_list,omitempty"`
+	// SubmitButton 提交按钮样式, vote_interaction和multiple_interaction必填
+	SubmitButton *CardSubmitButton `json:"submit_button,omitempty"`
+}
+
+// Validate 验证模板卡片消息
+func (msg *TemplateCardMsg) Validate() error {
+	if msg == nil {
+		return errors.New("消息为空")
+	}
+	if err := msg.validateCommon(); err != nil {
+		return err
+	}
+	switch msg.CardType {
+	case TemplateCardTextNotice:
+		if (msg.MainTitle == nil || msg.MainTitle.Title == "") && msg.SubTitleText == "" {
+			return errors.New("文本通知型卡片main_title.title和sub_title_text必须有一项填写")
+		}
+		return msg.validateCardAction(true)
+	case TemplateCardNewsNotice:
+		if msg.MainTitle == nil || msg.MainTitle.Title == "" {
+			return errors.New("图文展示型卡片main_title.title为空")
+		}
+		if (msg.CardImage == nil || msg.CardImage.URL == "") &&
+			(msg.ImageTextArea == nil || msg.ImageTextArea.ImageURL == "") {
+			return errors.New("图文展示型卡片card_image和image_text_area必须有一项填写")
+		}
+		if msg.ImageTextArea != nil {
+			if err := msg.ImageTextArea.Validate(); err != nil {
+				return err
+			}
+		}
+		if len(msg.VerticalContentList) > 4 {
+			return errors.New("卡片vertical_content_list最多4个")
+		}
+		for _, v := range msg.VerticalContentList {
+			if v.Title == "" {
+				return errors.New("卡片vertical_content_list的标题为空")
+			}
+		}
+		return msg.validateCardAction(true)
+	case TemplateCardButtonInteraction:
+		if err := msg.validateInteraction(); err != nil {
+			return err
+		}
+		if msg.ButtonSelection != nil {
+			if err := msg.ButtonSelection.Validate(); err != nil {
+				return err
+			}
+		}
+		if len(msg.ButtonList) < 1 || len(msg.ButtonList) > 6 {
+			return errors.New("卡片button_list支持1到6个按钮")
+		}
+		for _, button := range msg.ButtonList {
+			if err := button.Validate(); err != nil {
+				return err
+			}
+		}
+		return msg.validateCardAction(false)
+	case TemplateCardVoteInteraction:
+		if err := msg.validateInteraction(); err != nil {
+			return err
+		}
+		if err := msg.Checkbox.Validate(); err != nil {
+			return err
+		}
+		return msg.SubmitButton.Validate()
+	case TemplateCardMultipleInteraction:
+		if err := msg.validateInteraction(); err != nil {
+			return err
+		}
+		if len(msg.SelectList) < 1 || len(msg.SelectList) > 3 {
+			return errors.New("卡片select_list支持1到3个选择器")
+		}
+		for i := range msg.SelectList {
+			if err := msg.SelectList[i].Validate(); err != nil {
+				return err
+			}
+		}
+		return msg.SubmitButton.Validate()
+	}
+	return errors.New("模板卡片类型错误")
+}
+
+// validateCommon 验证所有卡片类型共有的字段
+func (msg *TemplateCardMsg) validateCommon() error {
+	if msg.TaskID != "" {
+		if err := validateTaskID(msg.TaskID); err != nil {
+			return err
+		}
+	}
+	if msg.ActionMenu != nil {
+		if msg.TaskID == "" {
+			return errors.New("卡片设置action_menu时task_id不能为空")
+		}
+		if err := msg.ActionMenu.Validate(); err != nil {
+			return err
+		}
+	}
+	if msg.QuoteArea != nil {
+		if err := validateJump(msg.QuoteArea.Type, msg.QuoteArea.URL, msg.QuoteArea.AppID); err != nil {
+			return err
+		}
+	}
+	if len(msg.HorizontalContentList) > 6 {
+		return errors.New("卡片horizontal_content_list最多6个")
+	}
+	for _, content := range msg.HorizontalContentList {
+		if err := content.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(msg.JumpList) > 3 {
+		return errors.New("卡片jump_list最多3个")
+	}
+	for _, jump := range msg.JumpList {
+		if err := jump.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateInteraction 交互型卡片的task_id必填
+func (msg *TemplateCardMsg) validateInteraction() error {
+	if msg.TaskID == "" {
+		return errors.New("交互型卡片task_id为空")
+	}
+	return nil
+}
+
+// validateCardAction 验证卡片的跳转事件, required为true时必须设置url或者小程序跳转
+func (msg *TemplateCardMsg) validateCardAction(required bool) error {
+	if msg.CardAction == nil || msg.CardAction.Type == 0 {
+		if required {
+			return errors.New("卡片card_action为空")
+		}
+		return nil
+	}
+	return validateJump(msg.CardAction.Type, msg.CardAction.URL, msg.CardAction.AppID)
+}
+
+// validateJump 验证跳转类型: 0不跳转, 1跳转url, 2打开小程序
+func validateJump(typ int, url, appID string) error {
+	switch typ {
+	case 0:
+	case 1:
+		if url == "" {
+			return errors.New("卡片跳转url为空")
+		}
+	case 2:
+		if appID == "" {
+			return errors.New("卡片跳转小程序appid为空")
+		}
+	default:
+		return errors.Errorf("卡片跳转类型%d错误", typ)
+	}
+	return nil
+}
+
+// validateTaskID 任务id最长128字节, 只能由数字、字母和"_-@"组成
+func validateTaskID(id string) error {
+	if len(id) > 128 {
+		return errors.New("task_id长度超过128字节")
+	}
+	for _, r := range id {
+		switch {
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r == '_', r == '-', r == '@':
+		default:
+			return errors.New("task_id只能由数字、字母和\"_-@\"组成")
+		}
+	}
+	return nil
+}
+
+// CardSource 卡片来源样式信息
+type CardSource struct {
+	IconURL string `json:"icon_url,omitempty"`
+	Desc    string `json:"desc,omitempty"`
+	// DescColor 来源文字的颜色, 0灰色, 1黑色, 2红色, 3绿色
+	DescColor int `json:"desc_color,omitempty"`
+}
+
+// CardActionMenu 卡片右上角更多操作按钮
+type CardActionMenu struct {
+	Desc string `json:"desc,omitempty"`
+	// ActionList 操作列表, 1到3个
+	ActionList []CardActionMenuItem `json:"action_list"`
+}
+
+// Validate 验证更多操作按钮
+func (menu *CardActionMenu) Validate() error {
+	if len(menu.ActionList) < 1 || len(menu.ActionList) > 3 {
+		return errors.New("卡片action_list支持1到3个操作")
+	}
+	for _, action := range menu.ActionList {
+		if action.Text == "" || action.Key == "" {
+			return errors.New("卡片action_list的text和key不能为空")
+		}
+	}
+	return nil
+}
+
+// CardActionMenuItem 更多操作的选项
+type CardActionMenuItem struct {
+	Text string `json:"text"`
+	Key  string `json:"key"`
+}
+
+// CardMainTitle 一级标题
+type CardMainTitle struct {
+	Title string `json:"title,omitempty"`
+	Desc  string `json:"desc,omitempty"`
+}
+
+// CardQuoteArea 引用文献样式
+type CardQuoteArea struct {
+	// Type 点击事件, 0不跳转, 1跳转url, 2跳转小程序
+	Type      int    `json:"type,omitempty"`
+	URL       string `json:"url,omitempty"`
+	AppID     string `json:"appid,omitempty"`
+	PagePath  string `json:"pagepath,omitempty"`
+	Title     string `json:"title,omitempty"`
+	QuoteText string `json:"quote_text,omitempty"`
+}
+
+// CardEmphasisContent 关键数据样式
+type CardEmphasisContent struct {
+	Title string `json:"title,omitempty"`
+	Desc  string `json:"desc,omitempty"`
+}
+
+// CardHorizontalContent 二级标题+文本
+type CardHorizontalContent struct {
+	// Type 链接类型, 0普通文本, 1跳转url, 2下载附件, 3点击跳转成员详情
+	Type    int    `json:"type,omitempty"`
+	KeyName string `json:"keyname"`
+	Value   string `json:"value,omitempty"`
+	URL     string `json:"url,omitempty"`
+	MediaID string `json:"media_id,omitempty"`
+	UserID  string `json:"userid,omitempty"`
+}
+
+// Validate 验证二级标题+文本
+func (content CardHorizontalContent) Validate() error {
+	if content.KeyName == "" {
+		return errors.New("卡片horizontal_content_list的keyname为空")
+	}
+	switch content.Type {
+	case 0:
+	case 1:
+		if content.URL == "" {
+			return errors.New("卡片horizontal_content_list的url为空")
+		}
+	case 2:
+		if content.MediaID == "" {
+			return errors.New("卡片horizontal_content_list的media_id为空")
+		}
+	case 3:
+		if content.UserID == "" {
+			return errors.New("卡片horizontal_content_list的userid为空")
+		}
+	default:
+		return errors.Errorf("卡片horizontal_content_list的类型%d错误", content.Type)
+	}
+	return nil
+}
+
+// CardJump 跳转指引样式
+type CardJump struct {
+	// Type 跳转类型, 0不跳转, 1跳转url, 2跳转小程序
+	Type     int    `json:"type,omitempty"`
+	Title    string `json:"title"`
+	URL      string `json:"url,omitempty"`
+	AppID    string `json:"appid,omitempty"`
+	PagePath string `json:"pagepath,omitempty"`
+}
+
+// Validate 验证跳转指引
+func (jump CardJump) Validate() error {
+	if jump.Title == "" {
+		return errors.New("卡片jump_list的标题为空")
+	}
+	return validateJump(jump.Type, jump.URL, jump.AppID)
+}
+
+// CardAction 整体卡片的点击跳转事件
+type CardAction struct {
+	// Type 跳转类型, 1跳转url, 2打开小程序
+	Type     int    `json:"type"`
+	URL      string `json:"url,omitempty"`
+	AppID    string `json:"appid,omitempty"`
+	PagePath string `json:"pagepath,omitempty"`
+}
+
+// CardImageTextArea 左图右文样式
+type CardImageTextArea struct {
+	// Type 点击事件, 0不跳转, 1跳转url, 2跳转小程序
+	Type     int    `json:"type,omitempty"`
+	URL      string `json:"url,omitempty"`
+	AppID    string `json:"appid,omitempty"`
+	PagePath string `json:"pagepath,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Desc     string `json:"desc,omitempty"`
+	ImageURL string `json:"image_url"`
+}
+
+// Validate 验证左图右文样式
+func (area *CardImageTextArea) Validate() error {
+	if area.ImageURL == "" {
+		return errors.New("卡片image_text_area的image_url为空")
+	}
+	return validateJump(area.Type, area.URL, area.AppID)
+}
+
+// CardImage 图片样式
+type CardImage struct {
+	URL string `json:"url"`
+	// AspectRatio 图片的宽高比, 大于1.3小于2.25, 默认1.3
+	AspectRatio float64 `json:"aspect_ratio,omitempty"`
+}
+
+// CardVerticalContent 卡片二级垂直内容
+type CardVerticalContent struct {
+	Title string `json:"title"`
+	Desc  string `json:"desc,omitempty"`
+}
+
+// CardOption 选择器或者选择题的选项
+type CardOption struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	// IsChecked 是否默认选中, 仅用于选择题
+	IsChecked bool `json:"is_checked,omitempty"`
+}
+
+// validateOptions 验证选项列表, 选项的id不能重复
+func validateOptions(options []CardOption, max int) error {
+	if len(options) < 1 || len(options) > max {
+		return errors.Errorf("卡片option_list支持1到%d个选项", max)
+	}
+	ids := make(map[string]struct{}, len(options))
+	for _, option := range options {
+		if option.ID == "" || option.Text == "" {
+			return errors.New("卡片option_list的id和text不能为空")
+		}
+		if _, ok := ids[option.ID]; ok {
+			return errors.New("卡片option_list的id重复")
+		}
+		ids[option.ID] = struct{}{}
+	}
+	return nil
+}
+
+// CardSelect 下拉式的选择器
+type CardSelect struct {
+	QuestionKey string       `json:"question_key"`
+	Title       string       `json:"title,omitempty"`
+	SelectedID  string       `json:"selected_id,omitempty"`
+	OptionList  []CardOption `json:"option_list"`
+}
+
+// Validate 验证下拉式的选择器
+func (sel *CardSelect) Validate() error {
+	if sel.QuestionKey == "" {
+		return errors.New("卡片选择器question_key为空")
+	}
+	return validateOptions(sel.OptionList, 10)
+}
+
+// CardButton 按钮
+type CardButton struct {
+	// Type 按钮点击事件类型, 0回调事件, 1跳转url
+	Type int    `json:"type,omitempty"`
+	Text string `json:"text"`
+	// Style 按钮样式, 1到4
+	Style int    `json:"style,omitempty"`
+	Key   string `json:"key,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// Validate 验证按钮
+func (button CardButton) Validate() error {
+	if button.Text == "" {
+		return errors.New("卡片按钮文案为空")
+	}
+	switch button.Type {
+	case 0:
+		if button.Key == "" {
+			return errors.New("卡片按钮key为空")
+		}
+	case 1:
+		if button.URL == "" {
+			return errors.New("卡片按钮url为空")
+		}
+	default:
+		return errors.Errorf("卡片按钮类型%d错误", button.Type)
+	}
+	return nil
+}
+
+// CardCheckbox 选择题样式
+type CardCheckbox struct {
+	QuestionKey string       `json:"question_key"`
+	OptionList  []CardOption `json:"option_list"`
+	// Mode 选择题模式, 0单选, 1多选
+	Mode int `json:"mode,omitempty"`
+}
+
+// Validate 验证选择题样式
+func (checkbox *CardCheckbox) Validate() error {
+	if checkbox == nil {
+		return errors.New("投票选择型卡片checkbox为空")
+	}
+	if checkbox.QuestionKey == "" {
+		return errors.New("卡片checkbox的question_key为空")
+	}
+	if checkbox.Mode != 0 && checkbox.Mode != 1 {
+		return errors.New("卡片checkbox的mode只能是0或1")
+	}
+	return validateOptions(checkbox.OptionList, 20)
+}
+
+// CardSubmitButton 提交按钮样式
+type CardSubmitButton struct {
+	Text string `json:"text"`
+	Key  string `json:"key"`
+}
+
+// Validate 验证提交按钮
+func (button *CardSubmitButton) Validate() error {
+	if button == nil {
+		return errors.New("卡片submit_button为空")
+	}
+	if button.Text == "" || button.Key == "" {
+		return errors.New("卡片submit_button的text和key不能为空")
+	}
+	return nil
+}
+
+// InteractiveTaskCardMsg 任务卡片消息
+type InteractiveTaskCardMsg struct {
+	// Title 标题, 不超过128个字节
+	Title string `json:"title"`
+	// Desc 描述, 不超过512个字节
+	Desc string `json:"description"`
+	// URL 点击后跳转的链接
+	URL string `json:"url,omitempty"`
+	// TaskID 任务id, 同一个应用任务id不能重复
+	TaskID string `json:"task_id"`
+	// Btn 按钮列表, 1到2个
+	Btn []TaskCardButton `json:"btn"`
+}
+
+// Validate 验证任务卡片消息
+func (msg *InteractiveTaskCardMsg) Validate() error {
+	if msg == nil {
+		return errors.New("消息为空")
+	}
+	if msg.Title == "" {
+		return errors.New("任务卡片消息标题为空")
+	} else if len(msg.Title) > 128 {
+		return errors.New("任务卡片消息标题长度超过128字节")
+	}
+	if msg.Desc == "" {
+		return errors.New("任务卡片消息描述为空")
+	} else if len(msg.Desc) > 512 {
+		return errors.New("任务卡片消息描述长度超过512字节")
+	}
+	if msg.TaskID == "" {
+		return errors.New("任务卡片消息task_id为空")
+	}
+	if err := validateTaskID(msg.TaskID); err != nil {
+		return err
+	}
+	if len(msg.Btn) < 1 || len(msg.Btn) > 2 {
+		return errors.New("任务卡片消息支持1到2个按钮")
+	}
+	for _, btn := range msg.Btn {
+		if err := btn.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TaskCardButton 任务卡片的按钮
+type TaskCardButton struct {
+	// Key 按钮key值, 不超过128字节
+	Key string `json:"key"`
+	// Name 按钮名称, 不超过18字节
+	Name string `json:"name"`
+	// ReplaceName 点击按钮后显示的名称, 默认为"已处理"
+	ReplaceName string `json:"replace_name,omitempty"`
+	// Color 按钮字体颜色, "red"或者"blue", 默认"blue"
+	Color string `json:"color,omitempty"`
+	// IsBold 按钮字体是否加粗
+	IsBold bool `json:"is_bold,omitempty"`
+}
+
+// Validate 验证任务卡片的按钮
+func (btn TaskCardButton) Validate() error {
+	if btn.Key == "" {
+		return errors.New("任务卡片按钮key为空")
+	} else if len(btn.Key) > 128 {
+		return errors.New("任务卡片按钮key长度超过128字节")
+	}
+	if btn.Name == "" {
+		return errors.New("任务卡片按钮名称为空")
+	} else if len(btn.Name) > 18 {
+		return errors.New("任务卡片按钮名称长度超过18字节")
+	}
+	if btn.Color != "" && btn.Color != "red" && btn.Color != "blue" {
+		return errors.New("任务卡片按钮颜色只能是red或blue")
+	}
+	return nil
+}
+
+// UpdateTemplateCardMsg 更新模板卡片消息, Button和TemplateCard只能设置一个
+type UpdateTemplateCardMsg struct {
+	// UserIDs 企业的成员ID列表, 最多支持1000个
+	UserIDs []string `json:"userids,omitempty"`
+	// PartyIDs 企业的部门ID列表, 最多支持100个
+	PartyIDs []int `json:"partyids,omitempty"`
+	// TagIDs 企业的标签ID列表, 最多支持100个
+	TagIDs []int `json:"tagids,omitempty"`
+	// AtAll 更新整个任务接收人员
+	AtAll int `json:"atall,omitempty"`
+	// AgentID 应用的agentid
+	AgentID int `json:"agentid"`
+	// ResponseCode 发送模板卡片消息或者回调事件返回的response_code
+	ResponseCode string `json:"response_code"`
+	// EnableIDTrans 是否开启id转译 0：否,1:是默认0
+	EnableIDTrans int `json:"enable_id_trans,omitempty"`
+	// Button 更新按钮为不可点击状态
+	Button *UpdateTemplateCardButton `json:"button,omitempty"`
+	// TemplateCard 更新为新的卡片
+	TemplateCard *TemplateCardMsg `json:"template_card,omitempty"`
+}
+
+// UpdateTemplateCardButton 更新按钮为不可点击状态
+type UpdateTemplateCardButton struct {
+	// ReplaceName 按钮替换的文案
+	ReplaceName string `json:"replace_name"`
+}
+
+// Validate 验证更新模板卡片消息
+func (msg *UpdateTemplateCardMsg) Validate() error {
+	if msg == nil {
+		return errors.New("消息为空")
+	}
+	if msg.AtAll != 1 && len(msg.UserIDs) == 0 && len(msg.PartyIDs) == 0 && len(msg.TagIDs) == 0 {
+		return errors.New("userids/partyids/tagids/atall不能同时为空")
+	}
+	if len(msg.UserIDs) > 1000 {
+		return errors.New("userids最多支持1000个")
+	}
+	if len(msg.PartyIDs) > 100 || len(msg.TagIDs) > 100 {
+		return errors.New("partyids/tagids最多支持100个")
+	}
+	if msg.AgentID == 0 {
+		return errors.New("应用代理agentid不可为空")
+	}
+	if msg.ResponseCode == "" {
+		return errors.New("response_code为空")
+	}
+	switch {
+	case msg.Button != nil && msg.TemplateCard != nil:
+		return errors.New("button和template_card只能设置一个")
+	case msg.Button != nil:
+		if msg.Button.ReplaceName == "" {
+			return errors.New("按钮替换文案为空")
+		}
+		return nil
+	case msg.TemplateCard != nil:
+		return msg.TemplateCard.Validate()
+	}
+	return errors.New("button和template_card不能同时为空")
+}
+
+// UpdateTemplateCardResponse 更新模板卡片消息的响应
+type UpdateTemplateCardResponse struct {
+	ErrCode int    `json:"errcode,omitempty"`
+	ErrMsg  string `json:"errmsg,omitempty"`
+	// InvalidUser 不合法的userid
+	InvalidUser []string `json:"invaliduser,omitempty"`
+}
+
+// Validate 验证更新模板卡片消息的响应
+func (res *UpdateTemplateCardResponse) Validate() error {
+	if res == nil {
+		return ErrIsNil
+	}
+	return errcode.New(res.ErrCode, res.ErrMsg)
+}
+
+// NewUpdateTemplateCardURL 新建更新模板卡片消息的URL
+func NewUpdateTemplateCardURL(url, accessToken string) string {
+	if accessToken == "" {
+		return ""
+	}
+	if url == "" {
+		url = apiURL(updateTemplateCardPath)
+	}
+	return buildURL(url, "access_token", accessToken)
+}
+
+// UpdateTemplateCard 更新模板卡片消息, 仅对交互型卡片生效
+func UpdateTemplateCard(url, accessToken string, msg *UpdateTemplateCardMsg) (*UpdateTemplateCardResponse, error) {
+	return DefaultClient.UpdateTemplateCard(context.Background(), url, accessToken, msg)
+}
+
+// UpdateTemplateCard 更新模板卡片消息, 仅对交互型卡片生效
+func (c *Client) UpdateTemplateCard(ctx context.Context, url, accessToken string, msg *UpdateTemplateCardMsg) (*UpdateTemplateCardResponse, error) {
+	if accessToken == "" {
+		return nil, errcode.ErrInvalidAccessToken
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	return execute[UpdateTemplateCardResponse](ctx, c, http.MethodPost, NewUpdateTemplateCardURL(url, accessToken), msg)
+}
@@ -0,0 +1,421 @@
+package corp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTemplateCardMsg_Validate(t *testing.T) {
+	textNotice := `{
+		"card_type":"text_notice",
+		"source":{"icon_url":"图片的url","desc":"企业微信","desc_color":1},
+		"action_menu":{"desc":"卡片副交互辅助文本说明","action_list":[{"text":"接收推送","key":"action_key1"},{"text":"不再推送","key":"action_key2"}]},
+		"task_id":"task_id",
+		"main_title":{"title":"欢迎使用企业微信","desc":"您的好友正在邀请您加入企业微信"},
+		"quote_area":{"type":1,"url":"https://work.weixin.qq.com","title":"企业微信的引用样式","quote_text":"企业微信真好用呀真好用"},
+		"emphasis_content":{"title":"100","desc":"核心数据"},
+		"sub_title_text":"下载企业微信还能抢红包！",
+		"horizontal_content_list":[{"keyname":"邀请人","value":"张三"},{"type":1,"keyname":"企业微信官网","value":"点击访问","url":"https://work.weixin.qq.com"},{"type":2,"keyname":"企业微信下载","value":"企业微信.apk","media_id":"文件的media_id"},{"type":3,"keyname":"员工信息","value":"点击查看","userid":"zhangsan"}],
+		"jump_list":[{"type":1,"title":"企业微信官网","url":"https://work.weixin.qq.com"},{"type":2,"title":"跳转小程序","appid":"小程序的appid","pagepath":"/index.html"}],
+		"card_action":{"type":2,"url":"https://work.weixin.qq.com","appid":"小程序的appid","pagepath":"/index.html"}
+	}`
+	newsNotice := `{
+		"card_type":"news_notice",
+		"main_title":{"title":"欢迎使用企业微信"},
+		"card_image":{"url":"图片的url","aspect_ratio":1.3},
+		"image_text_area":{"type":1,"url":"https://work.weixin.qq.com","title":"企业微信的左图右文样式","image_url":"https://img.url"},
+		"vertical_content_list":[{"title":"惊喜红包等你来拿","desc":"下载企业微信还能抢红包！"}],
+		"card_action":{"type":1,"url":"https://work.weixin.qq.com"}
+	}`
+	buttonInteraction := `{
+		"card_type":"button_interaction",
+		"task_id":"task_id",
+		"main_title":{"title":"欢迎使用企业微信"},
+		"button_selection":{"question_key":"btn_question_key1","title":"企业微信评分","option_list":[{"id":"btn_selection_id1","text":"100分"},{"id":"btn_selection_id2","text":"101分"}],"selected_id":"btn_selection_id1"},
+		"button_list":[{"text":"按钮1","style":1,"key":"BUTTONKEYONE"},{"type":1,"text":"按钮2","style":2,"url":"https://work.weixin.qq.com"}]
+	}`
+	voteInteraction := `{
+		"card_type":"vote_interaction",
+		"task_id":"task_id",
+		"main_title":{"title":"欢迎使用企业微信"},
+		"checkbox":{"question_key":"question_key1","option_list":[{"id":"option_id1","text":"选择题选项1","is_checked":true},{"id":"option_id2","text":"选择题选项2"}],"mode":1},
+		"submit_button":{"text":"提交","key":"key"}
+	}`
+	multipleInteraction := `{
+		"card_type":"multiple_interaction",
+		"task_id":"task_id",
+		"main_title":{"title":"欢迎使用企业微信"},
+		"select_list":[{"question_key":"question_key1","title":"选择器标签1","selected_id":"selection_id1","option_list":[{"id":"selection_id1","text":"选择器选项1"},{"id":"selection_id2","text":"选择器选项2"}]}],
+		"submit_button":{"text":"提交","key":"key"}
+	}`
+
+	tests := []struct {
+		name    string
+		json    string
+		modify  func(msg *TemplateCardMsg)
+		wantErr bool
+	}{
+		{name: "text_notice", json: textNotice},
+		{name: "news_notice", json: newsNotice},
+		{name: "button_interaction", json: buttonInteraction},
+		{name: "vote_interaction", json: voteInteraction},
+		{name: "multiple_interaction", json: multipleInteraction},
+		{
+			name:    "invalid card_type",
+			json:    textNotice,
+			modify:  func(msg *TemplateCardMsg) { msg.CardType = "invalid" },
+			wantErr: true,
+		},
+		{
+			name: "text_notice without title",
+			json: textNotice,
+			modify: func(msg *TemplateCardMsg) {
+				msg.MainTitle = nil
+				msg.SubTitleText = ""
+			},
+			wantErr: true,
+		},
+		{
+			name:    "text_notice without card_action",
+			json:    textNotice,
+			modify:  func(msg *TemplateCardMsg) { msg.CardAction = nil },
+			wantErr: true,
+		},
+		{
+			name:    "card_action without appid",
+			json:    textNotice,
+			modify:  func(msg *TemplateCardMsg) { msg.CardAction.AppID = "" },
+			wantErr: true,
+		},
+		{
+			name:    "action_menu without task_id",
+			json:    textNotice,
+			modify:  func(msg *TemplateCardMsg) { msg.TaskID = "" },
+			wantErr: true,
+		},
+		{
+			name:    "invalid task_id",
+			json:    textNotice,
+			modify:  func(msg *TemplateCardMsg) { msg.TaskID = "task id" },
+			wantErr: true,
+		},
+		{
+			name:    "long task_id",
+			json:    textNotice,
+			modify:  func(msg *TemplateCardMsg) { msg.TaskID = strings.Repeat("a", 129) },
+			wantErr: true,
+		},
+		{
+			name: "too many horizontal_content_list",
+			json: textNotice,
+			modify: func(msg *TemplateCardMsg) {
+				msg.HorizontalContentList = append(msg.HorizontalContentList, msg.HorizontalContentList...)
+			},
+			wantErr: true,
+		},
+		{
+			name:    "horizontal_content without media_id",
+			json:    textNotice,
+			modify:  func(msg *TemplateCardMsg) { msg.HorizontalContentList[2].MediaID = "" },
+			wantErr: true,
+		},
+		{
+			name:    "jump without title",
+			json:    textNotice,
+			modify:  func(msg *TemplateCardMsg) { msg.JumpList[0].Title = "" },
+			wantErr: true,
+		},
+		{
+			name: "news_notice without image",
+			json: newsNotice,
+			modify: func(msg *TemplateCardMsg) {
+				msg.CardImage = nil
+				msg.ImageTextArea = nil
+			},
+			wantErr: true,
+		},
+		{
+			name:    "news_notice without main_title",
+			json:    newsNotice,
+			modify:  func(msg *TemplateCardMsg) { msg.MainTitle = nil },
+			wantErr: true,
+		},
+		{
+			name:    "button_interaction without task_id",
+			json:    buttonInteraction,
+			modify:  func(msg *TemplateCardMsg) { msg.TaskID = "" },
+			wantErr: true,
+		},
+		{
+			name:    "button_interaction without buttons",
+			json:    buttonInteraction,
+			modify:  func(msg *TemplateCardMsg) { msg.ButtonList = nil },
+			wantErr: true,
+		},
+		{
+			name:    "button without key",
+			json:    buttonInteraction,
+			modify:  func(msg *TemplateCardMsg) { msg.ButtonList[0].Key = "" },
+			wantErr: true,
+		},
+		{
+			name:    "link button without url",
+			json:    buttonInteraction,
+			modify:  func(msg *TemplateCardMsg) { msg.ButtonList[1].URL = "" },
+			wantErr: true,
+		},
+		{
+			name: "button_selection with duplicate option",
+			json: buttonInteraction,
+			modify: func(msg *TemplateCardMsg) {
+				msg.ButtonSelection.OptionList[1].ID = msg.ButtonSelection.OptionList[0].ID
+			},
+			wantErr: true,
+		},
+		{
+			name:    "vote_interaction without checkbox",
+			json:    voteInteraction,
+			modify:  func(msg *TemplateCardMsg) { msg.Checkbox = nil },
+			wantErr: true,
+		},
+		{
+			name:    "vote_interaction with invalid mode",
+			json:    voteInteraction,
+			modify:  func(msg *TemplateCardMsg) { msg.Checkbox.Mode = 2 },
+			wantErr: true,
+		},
+		{
+			name:    "vote_interaction without submit_button",
+			json:    voteInteraction,
+			modify:  func(msg *TemplateCardMsg) { msg.SubmitButton = nil },
+			wantErr: true,
+		},
+		{
+			name: "multiple_interaction with too many selects",
+			json: multipleInteraction,
+			modify: func(msg *TemplateCardMsg) {
+				for i := 0; i < 3; i++ {
+					msg.SelectList = append(msg.SelectList, msg.SelectList[0])
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "multiple_interaction without question_key",
+			json:    multipleInteraction,
+			modify:  func(msg *TemplateCardMsg) { msg.SelectList[0].QuestionKey = "" },
+			wantErr: true,
+		},
+		{
+			name:    "multiple_interaction with empty submit_button",
+			json:    multipleInteraction,
+			modify:  func(msg *TemplateCardMsg) { msg.SubmitButton.Key = "" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg TemplateCardMsg
+			if err := json.Unmarshal([]byte(tt.json), &msg); err != nil {
+				t.Fatal(err)
+			}
+			if tt.modify != nil {
+				tt.modify(&msg)
+			}
+			if err := msg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("TemplateCardMsg.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	t.Run("nil", func(t *testing.T) {
+		var msg *TemplateCardMsg
+		if err := msg.Validate(); err == nil {
+			t.Error("此处应该返回错误")
+		}
+	})
+}
+
+func TestInteractiveTaskCardMsg_Validate(t *testing.T) {
+	var s = `{"title":"赵明登的礼物申请","description":"礼品：A31茶具套装<br>用途：赠与小黑科技张总经理","url":"URL","task_id":"taskid123","btn":[{"key":"key111","name":"批准","replace_name":"已批准","color":"red","is_bold":true},{"key":"key222","name":"驳回","replace_name":"已驳回"}]}`
+
+	tests := []struct {
+		name    string
+		modify  func(msg *InteractiveTaskCardMsg)
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			modify:  func(msg *InteractiveTaskCardMsg) {},
+			wantErr: false,
+		},
+		{
+			name:    "no title",
+			modify:  func(msg *InteractiveTaskCardMsg) { msg.Title = "" },
+			wantErr: true,
+		},
+		{
+			name:    "long description",
+			modify:  func(msg *InteractiveTaskCardMsg) { msg.Desc = strings.Repeat("a", 513) },
+			wantErr: true,
+		},
+		{
+			name:    "no task_id",
+			modify:  func(msg *InteractiveTaskCardMsg) { msg.TaskID = "" },
+			wantErr: true,
+		},
+		{
+			name:    "invalid task_id",
+			modify:  func(msg *InteractiveTaskCardMsg) { msg.TaskID = "任务" },
+			wantErr: true,
+		},
+		{
+			name:    "no buttons",
+			modify:  func(msg *InteractiveTaskCardMsg) { msg.Btn = nil },
+			wantErr: true,
+		},
+		{
+			name: "too many buttons",
+			modify: func(msg *InteractiveTaskCardMsg) {
+				msg.Btn = append(msg.Btn, msg.Btn[0])
+			},
+			wantErr: true,
+		},
+		{
+			name:    "long button name",
+			modify:  func(msg *InteractiveTaskCardMsg) { msg.Btn[0].Name = strings.Repeat("a", 19) },
+			wantErr: true,
+		},
+		{
+			name:    "invalid button color",
+			modify:  func(msg *InteractiveTaskCardMsg) { msg.Btn[0].Color = "green" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg InteractiveTaskCardMsg
+			if err := json.Unmarshal([]byte(s), &msg); err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(&msg)
+			if err := msg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("InteractiveTaskCardMsg.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	t.Run("nil", func(t *testing.T) {
+		var msg *InteractiveTaskCardMsg
+		if err := msg.Validate(); err == nil {
+			t.Error("此处应该返回错误")
+		}
+	})
+}
+
+func TestUpdateTemplateCardMsg_Validate(t *testing.T) {
+	card := &TemplateCardMsg{
+		CardType:     TemplateCardTextNotice,
+		SubTitleText: "下载企业微信还能抢红包！",
+		CardAction:   &CardAction{Type: 1, URL: "https://work.weixin.qq.com"},
+	}
+	tests := []struct {
+		name    string
+		msg     *UpdateTemplateCardMsg
+		wantErr bool
+	}{
+		{
+			name:    "button",
+			msg:     &UpdateTemplateCardMsg{UserIDs: []string{"userid1"}, AgentID: 1, ResponseCode: "code", Button: &UpdateTemplateCardButton{ReplaceName: "已处理"}},
+			wantErr: false,
+		},
+		{
+			name:    "template_card",
+			msg:     &UpdateTemplateCardMsg{AtAll: 1, AgentID: 1, ResponseCode: "code", TemplateCard: card},
+			wantErr: false,
+		},
+		{
+			name:    "nil",
+			msg:     nil,
+			wantErr: true,
+		},
+		{
+			name:    "no receiver",
+			msg:     &UpdateTemplateCardMsg{AgentID: 1, ResponseCode: "code", TemplateCard: card},
+			wantErr: true,
+		},
+		{
+			name:    "no agentid",
+			msg:     &UpdateTemplateCardMsg{PartyIDs: []int{2}, ResponseCode: "code", TemplateCard: card},
+			wantErr: true,
+		},
+		{
+			name:    "no response_code",
+			msg:     &UpdateTemplateCardMsg{TagIDs: []int{44}, AgentID: 1, TemplateCard: card},
+			wantErr: true,
+		},
+		{
+			name:    "both",
+			msg:     &UpdateTemplateCardMsg{AtAll: 1, AgentID: 1, ResponseCode: "code", Button: &UpdateTemplateCardButton{ReplaceName: "已处理"}, TemplateCard: card},
+			wantErr: true,
+		},
+		{
+			name:    "neither",
+			msg:     &UpdateTemplateCardMsg{AtAll: 1, AgentID: 1, ResponseCode: "code"},
+			wantErr: true,
+		},
+		{
+			name:    "empty replace_name",
+			msg:     &UpdateTemplateCardMsg{AtAll: 1, AgentID: 1, ResponseCode: "code", Button: &UpdateTemplateCardButton{}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid template_card",
+			msg:     &UpdateTemplateCardMsg{AtAll: 1, AgentID: 1, ResponseCode: "code", TemplateCard: &TemplateCardMsg{}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.msg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("UpdateTemplateCardMsg.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpdateTemplateCard(t *testing.T) {
+	ht := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg UpdateTemplateCardMsg
+		json.NewDecoder(r.Body).Decode(&msg)
+		if msg.ResponseCode != "code" {
+			fmt.Fprint(w, `{"errcode":1,"errmsg":"未知错误"}`)
+			return
+		}
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","invaliduser":["userid3"]}`)
+	}))
+	defer ht.Close()
+
+	msg := &UpdateTemplateCardMsg{
+		UserIDs:      []string{"userid1", "userid3"},
+		AgentID:      1,
+		ResponseCode: "code",
+		Button:       &UpdateTemplateCardButton{ReplaceName: "已处理"},
+	}
+	res, err := UpdateTemplateCard(ht.URL, "a", msg)
+	if err != nil {
+		t.Fatalf("UpdateTemplateCard() error = %v", err)
+	}
+	if len(res.InvalidUser) != 1 || res.InvalidUser[0] != "userid3" {
+		t.Errorf("UpdateTemplateCard() invaliduser = %v", res.InvalidUser)
+	}
+	if _, err = UpdateTemplateCard(ht.URL, "", msg); err == nil {
+		t.Error("UpdateTemplateCard() without access token should fail")
+	}
+	msg.ResponseCode = "other"
+	if _, err = UpdateTemplateCard(ht.URL, "a", msg); err == nil {
+		t.Error("UpdateTemplateCard() should return the errcode")
+	}
+}
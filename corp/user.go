@@ -1,11 +1,11 @@
 package corp
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"math"
+	"net/http"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -16,16 +16,16 @@ import (
 )
 
 const (
-	defaultGetUserURL         = "https://qyapi.weixin.qq.com/cgi-bin/user/get"
-	defaultGetSimpleListURL   = "https://qyapi.weixin.qq.com/cgi-bin/user/simplelist"
-	defaultGetUserListURL     = "https://qyapi.weixin.qq.com/cgi-bin/user/list"
-	defaultCreateUserURL      = "https://qyapi.weixin.qq.com/cgi-bin/user/create"
-	defaultUpdateUserURL      = "https://qyapi.weixin.qq.com/cgi-bin/user/update"
-	defaultDeleteUserURL      = "https://qyapi.weixin.qq.com/cgi-bin/user/delete"
-	defaultBatchDeleteUserURL = "https://qyapi.weixin.qq.com/cgi-bin/user/batchdelete"
+	getUserPath         = "/cgi-bin/user/get"
+	getSimpleListPath   = "/cgi-bin/user/simplelist"
+	getUserListPath     = "/cgi-bin/user/list"
+	createUserPath      = "/cgi-bin/user/create"
+	updateUserPath      = "/cgi-bin/user/update"
+	deleteUserPath      = "/cgi-bin/user/delete"
+	batchDeleteUserPath = "/cgi-bin/user/batchdelete"
 
-	defaultUserIDToOpenIDURL = "https://qyapi.weixin.qq.com/cgi-bin/user/convert_to_openid"
-	defaultOpenIDToUserIDURL = "https://qyapi.weixin.qq.com/cgi-bin/user/convert_to_userid"
+	userIDToOpenIDPath = "/cgi-bin/user/convert_to_openid"
+	openIDToUserIDPath = "/cgi-bin/user/convert_to_userid"
 
 	maxBatchDeleteUserCount = 200 // 批量删除时，一次请求最多可以删除200个用户
 )
@@ -43,7 +43,7 @@ func (res *UserResponse) Validate() error {
 	if res == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(res.ErrCode)
+	return errcode.New(res.ErrCode, res.ErrMsg)
 }
 
 // ExtAttr 扩展属性
@@ -274,33 +274,22 @@ func NewGetUserURL(url, accessToken, userid string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultGetUserURL
+		url = apiURL(getUserPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s&userid=%s", url, accessToken, userid)
+	return buildURL(url, "access_token", accessToken, "userid", userid)
 }
 
 // GetUser 获取user信息
 func GetUser(url, accessToken string, userid string) (user *UserResponse, err error) {
+	return DefaultClient.GetUser(context.Background(), url, accessToken, userid)
+}
+
+// GetUser 获取user信息
+func (c *Client) GetUser(ctx context.Context, url, accessToken string, userid string) (user *UserResponse, err error) {
 	if accessToken == "" {
 		return nil, errcode.ErrInvalidAccessToken
 	}
-	url = NewGetUserURL(url, accessToken, userid)
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
-	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	if err = json.Unmarshal(b, &user); err != nil {
-		return nil, err
-	}
-	err = user.Validate()
-	return
+	return execute[UserResponse](ctx, c, http.MethodGet, NewGetUserURL(url, accessToken, userid), nil)
 }
 
 func sliceIntRemoveDuplicate(a []int) (b []int) {
@@ -325,23 +314,19 @@ func NewGetUserListURL(url, typ, accessToken string, departmentID, fetchChild in
 	}
 	if url == "" {
 		if typ == "simple" {
-			url = defaultGetSimpleListURL
+			url = apiURL(getSimpleListPath)
 		} else { // 如果未提供请求路径(不包含请求参数),默认请求用户详情
-			url = defaultGetUserListURL
+			url = apiURL(getUserListPath)
 		}
 	}
-	url = fmt.Sprintf("%s?access_token=%s&department_id=%d", url, accessToken, departmentID)
+	kv := []string{"access_token", accessToken, "department_id", strconv.Itoa(departmentID)}
 	if fetchChild == 1 {
-		url += fmt.Sprintf("&fetch_child=%d", fetchChild)
-	}
-	if len(status) < 1 {
-		return url
+		kv = append(kv, "fetch_child", strconv.Itoa(fetchChild))
 	}
-	status = sliceIntRemoveDuplicate(status)
-	for _, st := range status {
-		url += fmt.Sprintf("&status=%d", st)
+	for _, st := range sliceIntRemoveDuplicate(status) {
+		kv = append(kv, "status", strconv.Itoa(st))
 	}
-	return url
+	return buildURL(url, kv...)
 }
 
 // UserListResponse 请求部门用户列表的响应结构
@@ -356,31 +341,20 @@ func (res *UserListResponse) Validate() error {
 	if res == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(res.ErrCode)
+	return errcode.New(res.ErrCode, res.ErrMsg)
 }
 
 // GetUserList 获取部门用户, 如果typ="simple"查询部门成员, 如果typ!="simple"查询部门成员详情
 func GetUserList(url, typ, accessToken string, departmenID, fetchChild int, status []int) (res *UserListResponse, err error) {
+	return DefaultClient.GetUserList(context.Background(), url, typ, accessToken, departmenID, fetchChild, status)
+}
+
+// GetUserList 获取部门用户, 如果typ="simple"查询部门成员, 如果typ!="simple"查询部门成员详情
+func (c *Client) GetUserList(ctx context.Context, url, typ, accessToken string, departmenID, fetchChild int, status []int) (res *UserListResponse, err error) {
 	if accessToken == "" {
 		return nil, errcode.ErrInvalidAccessToken
 	}
-	url = NewGetUserListURL(url, typ, accessToken, departmenID, fetchChild, status)
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
-	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	if err = json.Unmarshal(b, &res); err != nil {
-		return nil, err
-	}
-	err = res.Validate()
-	return
+	return execute[UserListResponse](ctx, c, http.MethodGet, NewGetUserListURL(url, typ, accessToken, departmenID, fetchChild, status), nil)
 }
 
 // NewCreateUserURL 新建创建用户的URL
@@ -389,9 +363,9 @@ func NewCreateUserURL(url, accessToken string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultCreateUserURL
+		url = apiURL(createUserPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
 //NewUpdateUserURL 新建更新用户的URL
@@ -400,9 +374,9 @@ func NewUpdateUserURL(url, accessToken string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultUpdateUserURL
+		url = apiURL(updateUserPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
 // NewDeleteUserURL 新建删除用户的URL
@@ -411,9 +385,9 @@ func NewDeleteUserURL(url, accessToken, userid string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultDeleteUserURL
+		url = apiURL(deleteUserPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s&userid=%s", url, accessToken, userid)
+	return buildURL(url, "access_token", accessToken, "userid", userid)
 }
 
 // NewBatchDeleteUserURL 新建批量删除用户的URL
@@ -422,14 +396,14 @@ func NewBatchDeleteUserURL(url, accessToken string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultBatchDeleteUserURL
+		url = apiURL(batchDeleteUserPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
 // postUser 提交修改用户请求
 // 未测试
-func postUser(url, accessToken, action string, data interface{}) error {
+func (c *Client) postUser(ctx context.Context, url, accessToken, action string, data interface{}) error {
 	if accessToken == "" {
 		return errcode.ErrInvalidAccessToken
 	}
@@ -446,57 +420,59 @@ func postUser(url, accessToken, action string, data interface{}) error {
 			url = NewUpdateUserURL(url, accessToken)
 		}
 	case "batchdelete":
-		_, ok := data.([]string)
+		userids, ok := data.([]string)
 		if !ok {
 			return errors.New("批量删除用户操作的数据类型必须是用户id数组")
 		}
+		data = map[string][]string{"useridlist": userids}
 
 		url = NewBatchDeleteUserURL(url, accessToken)
 	default:
 		return errors.New("不支持的操作")
 	}
-	b, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-	buf := bytes.NewReader(b)
-	resp, err := httpClient.Post(url, mimeApplicationJSONCharsetUTF8, buf)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	b, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-	var res Response
-	if err = json.Unmarshal(b, &res); err != nil {
-		return err
-	}
-	return res.Validate()
+	_, err := execute[Response](ctx, c, http.MethodPost, url, data)
+	return err
 }
 
 // CreateUser 创建用户
 // 未测试
 func CreateUser(url, accessToken string, user *User) error {
+	return DefaultClient.CreateUser(context.Background(), url, accessToken, user)
+}
+
+// CreateUser 创建用户
+// 未测试
+func (c *Client) CreateUser(ctx context.Context, url, accessToken string, user *User) error {
 	if err := user.Validate(); err != nil {
 		return err
 	}
-	return postUser(url, accessToken, "create", user)
+	return c.postUser(ctx, url, accessToken, "create", user)
 }
 
 // UpdateUser 创建用户
 // 未测试
 func UpdateUser(url, accessToken string, user *User) error {
+	return DefaultClient.UpdateUser(context.Background(), url, accessToken, user)
+}
+
+// UpdateUser 创建用户
+// 未测试
+func (c *Client) UpdateUser(ctx context.Context, url, accessToken string, user *User) error {
 	if err := user.Validate(); err != nil {
 		return err
 	}
-	return postUser(url, accessToken, "update", user)
+	return c.postUser(ctx, url, accessToken, "update", user)
 }
 
 // DeleteUser 删除用户
 // 未测试
 func DeleteUser(url, accessToken, userid string) error {
+	return DefaultClient.DeleteUser(context.Background(), url, accessToken, userid)
+}
+
+// DeleteUser 删除用户
+// 未测试
+func (c *Client) DeleteUser(ctx context.Context, url, accessToken, userid string) error {
 	userid = strings.Replace(userid, " ", "", -1)
 	if userid == "" {
 		return errors.New("成员UserID为空")
@@ -504,26 +480,19 @@ func DeleteUser(url, accessToken, userid string) error {
 	if accessToken == "" {
 		return errcode.ErrInvalidAccessToken
 	}
-	url = NewDeleteUserURL(url, accessToken, userid)
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-	var res Response
-	if err = json.Unmarshal(b, &res); err != nil {
-		return err
-	}
-	return res.Validate()
+	_, err := execute[Response](ctx, c, http.MethodGet, NewDeleteUserURL(url, accessToken, userid), nil)
+	return err
 }
 
 // BatchDeleteUser 批量删除用户
 // 未测试
 func BatchDeleteUser(url, accessToken string, userids []string) error {
+	return DefaultClient.BatchDeleteUser(context.Background(), url, accessToken, userids)
+}
+
+// BatchDeleteUser 批量删除用户
+// 未测试
+func (c *Client) BatchDeleteUser(ctx context.Context, url, accessToken string, userids []string) error {
 	userids = RemoveDuplicateString(userids)
 	if len(userids) < 1 {
 		return nil
@@ -535,7 +504,7 @@ func BatchDeleteUser(url, accessToken string, userids []string) error {
 			return errors.New("用户id存在空字符串")
 		}
 	}
-	return postUser(url, accessToken, "batchdelete", userids)
+	return c.postUser(ctx, url, accessToken, "batchdelete", userids)
 }
 
 // SwitchOpenIDAndUserIDResponse userid和openid转换的响应
@@ -551,7 +520,7 @@ func (res *SwitchOpenIDAndUserIDResponse) Validate() error {
 	if res == nil {
 		return ErrIsNil
 	}
-	return errcode.Error(res.ErrCode)
+	return errcode.New(res.ErrCode, res.ErrMsg)
 }
 
 // NewConverUserIDToOpenIDURL 新建userid转openid的URL
@@ -561,9 +530,9 @@ func NewConverUserIDToOpenIDURL(url, accessToken string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultUserIDToOpenIDURL
+		url = apiURL(userIDToOpenIDPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
 // NewConverOpenIDToUserIDURL 新建openid转userid的URL
@@ -573,69 +542,64 @@ func NewConverOpenIDToUserIDURL(url, accessToken string) string {
 		return ""
 	}
 	if url == "" {
-		url = defaultOpenIDToUserIDURL
+		url = apiURL(openIDToUserIDPath)
 	}
-	return fmt.Sprintf("%s?access_token=%s", url, accessToken)
+	return buildURL(url, "access_token", accessToken)
 }
 
 // switchOpenIDAndUserID 交换openid和userid
 // 未测试
-func switchOpenIDAndUserID(url, accessToken, id string, typ int) (s string, err error) {
+func (c *Client) switchOpenIDAndUserID(ctx context.Context, url, accessToken, id string, typ int) (s string, err error) {
 	if accessToken == "" {
 		return "", errcode.ErrInvalidAccessToken
 	}
 	id = strings.Replace(id, " ", "", -1)
 
-	var buf bytes.Buffer
 	switch typ {
 	case 1: // userid to openid
 		url = NewConverUserIDToOpenIDURL(url, accessToken)
-		buf.WriteString(`{"userid":"` + id + `"}`)
+		res, err := execute[SwitchOpenIDAndUserIDResponse](ctx, c, http.MethodPost, url, map[string]string{"userid": id})
+		if err != nil {
+			return "", err
+		}
+		return res.OpenID, nil
 	case 2: // openid to userid
 		url = NewConverOpenIDToUserIDURL(url, accessToken)
-		buf.WriteString(`{"openid":"` + id + `"}`)
-	default:
-		return "", errors.New("无效的操作类型")
-	}
-
-	resp, err := httpClient.Post(url, mimeApplicationJSONCharsetUTF8, &buf)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	var res SwitchOpenIDAndUserIDResponse
-	if err = json.Unmarshal(b, &res); err != nil {
-		return "", err
-	}
-	if err = res.Validate(); err != nil {
-		return "", err
-	}
-	if typ == 1 {
-		s = res.OpenID
-	} else {
-		s = res.UserID
+		res, err := execute[SwitchOpenIDAndUserIDResponse](ctx, c, http.MethodPost, url, map[string]string{"openid": id})
+		if err != nil {
+			return "", err
+		}
+		return res.UserID, nil
 	}
-	return s, nil
+	return "", errors.New("无效的操作类型")
 }
 
 // ConverUserIDToOpenID userid转openid
 // 未测试
 func ConverUserIDToOpenID(url, accessToken, userid string) (string, error) {
+	return DefaultClient.ConverUserIDToOpenID(context.Background(), url, accessToken, userid)
+}
+
+// ConverUserIDToOpenID userid转openid
+// 未测试
+func (c *Client) ConverUserIDToOpenID(ctx context.Context, url, accessToken, userid string) (string, error) {
 	if userid == "" {
 		return "", errors.New("userid为空")
 	}
-	return switchOpenIDAndUserID(url, accessToken, userid, 1)
+	return c.switchOpenIDAndUserID(ctx, url, accessToken, userid, 1)
 }
 
 // ConverOpenIDToUserID openid转userid
 // 未测试
 func ConverOpenIDToUserID(url, accessToken, openid string) (string, error) {
+	return DefaultClient.ConverOpenIDToUserID(context.Background(), url, accessToken, openid)
+}
+
+// ConverOpenIDToUserID openid转userid
+// 未测试
+func (c *Client) ConverOpenIDToUserID(ctx context.Context, url, accessToken, openid string) (string, error) {
 	if openid == "" {
 		return "", errors.New("openid为空")
 	}
-	return switchOpenIDAndUserID(url, accessToken, openid, 2)
+	return c.switchOpenIDAndUserID(ctx, url, accessToken, openid, 2)
 }
@@ -32,6 +32,11 @@ func TestNewGetUserURL(t *testing.T) {
 			args: args{accessToken: "123456", userid: "111"},
 			want: "https://qyapi.weixin.qq.com/cgi-bin/user/get?access_token=123456&userid=111",
 		},
+		{
+			name: "3",
+			args: args{accessToken: "123456", userid: "zhang+san&x=1"},
+			want: "https://qyapi.weixin.qq.com/cgi-bin/user/get?access_token=123456&userid=zhang%2Bsan%26x%3D1",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -346,6 +351,47 @@ func TestGetUserList(t *testing.T) {
 	}
 }
 
+func TestBatchDeleteUser(t *testing.T) {
+	ht := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 用户id列表必须放在useridlist字段中提交
+		var body struct {
+			UserIDList []string `json:"useridlist"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("BatchDeleteUser() body error = %v", err)
+		}
+		if r.Method != http.MethodPost || !reflect.DeepEqual(body.UserIDList, []string{"zhangsan", "lisi"}) {
+			t.Errorf("BatchDeleteUser() request = %s %v, want POST useridlist [zhangsan lisi]", r.Method, body.UserIDList)
+			fmt.Fprint(w, `{"errcode":40031,"errmsg":"invalid userid list"}`)
+			return
+		}
+		if r.URL.Query().Get("access_token") != "ok" {
+			fmt.Fprint(w, `{"errcode":1,"errmsg":"未知错误"}`)
+			return
+		}
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"deleted"}`)
+	}))
+	defer ht.Close()
+	tests := []struct {
+		name        string
+		accessToken string
+		userids     []string
+		wantErr     bool
+	}{
+		{name: "1", accessToken: "ok", userids: []string{"zhangsan", "lisi", "zhangsan"}},
+		{name: "2", accessToken: "a", userids: []string{"zhangsan", "lisi"}, wantErr: true},
+		{name: "3", userids: []string{"zhangsan", "lisi"}, wantErr: true},
+		{name: "4", accessToken: "ok", userids: []string{"zhangsan", ""}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := BatchDeleteUser(ht.URL, tt.accessToken, tt.userids); (err != nil) != tt.wantErr {
+				t.Errorf("BatchDeleteUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestExtText_Validate(t *testing.T) {
 	type fields struct {
 		Value string